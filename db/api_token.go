@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix 个人 API token 前缀，用于和 JWT 区分
+const APITokenPrefix = "pas_"
+
+// 最近使用时间的最小更新间隔，避免每次请求都写库
+const apiTokenTouchInterval = time.Minute
+
+// APIToken 个人 API token，供脚本和服务账号使用，只保存哈希
+type APIToken struct {
+	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId    int64  `gorm:"column:user_id;index;not null"`
+	Name      string `gorm:"column:name;type:varchar(64);not null"`
+	Prefix    string `gorm:"column:prefix;type:varchar(16)"` // token 前几位，用于辨认
+	TokenHash string `gorm:"column:token_hash;type:varchar(64);uniqueIndex;not null"`
+	// 权限范围，只能是所属用户权限的子集，实际权限为两者的交集
+	Permissions []string `gorm:"column:permissions;serializer:json"`
+	LegacyRole  int      `gorm:"column:role;default:0"` // 已废弃的权限位，启动时迁移到 permissions
+	ExpiresAt   int64    `gorm:"column:expires_at;not null"`
+	LastUsedAt  int64    `gorm:"column:last_used_at;default:0"`
+	LastUsedIp  string   `gorm:"column:last_used_ip;type:varchar(64)"`
+	RevokedAt   int64    `gorm:"column:revoked_at;default:0"`
+	CreatedAt   int64    `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// IsActive 是否可用
+func (t APIToken) IsActive() bool {
+	return t.RevokedAt == 0 && t.ExpiresAt > time.Now().UnixMilli()
+}
+
+// CreateAPIToken 创建 API token
+func CreateAPIToken(ctx context.Context, token *APIToken) error {
+	return getDb(ctx).Create(token).Error
+}
+
+// GetAPITokenByHash 根据哈希查询可用的 API token
+func GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	var token APIToken
+	err := getDb(ctx).Where("token_hash = ? AND revoked_at = 0", tokenHash).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPITokenInvalid
+		}
+		return nil, err
+	}
+	if token.ExpiresAt <= time.Now().UnixMilli() {
+		return nil, ErrAPITokenExpired
+	}
+	return &token, nil
+}
+
+// TouchAPIToken 记录最近使用时间和 IP
+func TouchAPIToken(ctx context.Context, token *APIToken, ip string) error {
+	now := time.Now()
+	if now.Sub(time.UnixMilli(token.LastUsedAt)) < apiTokenTouchInterval && token.LastUsedIp == ip {
+		return nil
+	}
+	return getDb(ctx).Model(&APIToken{}).Where("id = ?", token.Id).UpdateColumns(map[string]interface{}{
+		"last_used_at": now.UnixMilli(),
+		"last_used_ip": ip,
+	}).Error
+}
+
+// GetAPITokensByUser 查询用户的 API token 列表
+func GetAPITokensByUser(ctx context.Context, userId int64) ([]*APIToken, error) {
+	var tokens []*APIToken
+	err := getDb(ctx).Where("user_id = ? AND revoked_at = 0", userId).Order("id DESC").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken 吊销用户的 API token
+func RevokeAPIToken(ctx context.Context, userId, id int64) (bool, error) {
+	result := getDb(ctx).Model(&APIToken{}).Where("id = ? AND user_id = ? AND revoked_at = 0", id, userId).
+		Update("revoked_at", time.Now().UnixMilli())
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeUserAPITokens 吊销用户的所有 API token
+func RevokeUserAPITokens(ctx context.Context, userId int64) error {
+	return getDb(ctx).Model(&APIToken{}).Where("user_id = ? AND revoked_at = 0", userId).
+		Update("revoked_at", time.Now().UnixMilli()).Error
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"pionex-administrative-sys/utils"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// 审计对象类型
+const (
+	AuditTargetUser         = "user"
+	AuditTargetCoupon       = "coupon"
+	AuditTargetRegistration = "registration"
+	AuditTargetInvite       = "invite"
+	AuditTargetAPIToken     = "api_token"
+	AuditTargetSetting      = "setting"
+	AuditTargetLoginLock    = "login_lock"
+	AuditTargetRole         = "role"
+	AuditTargetDepartment   = "department"
+	AuditTargetCouponType   = "coupon_type"
+	AuditTargetQuotaPolicy  = "quota_policy"
+	AuditTargetDistribution = "coupon_distribution"
+	AuditTargetTransfer     = "coupon_transfer"
+)
+
+// AuditEvent 审计日志，只允许追加；每条记录包含上一条的哈希，修改或删除任意记录都会使后续哈希校验失败
+type AuditEvent struct {
+	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	ActorId    int64  `gorm:"column:actor_id;index;default:0"` // 操作人，0 表示未登录的操作
+	Actor      string `gorm:"column:actor;type:varchar(128)"`  // 操作人账号快照
+	Action     string `gorm:"column:action;type:varchar(64);index;not null"`
+	TargetType string `gorm:"column:target_type;type:varchar(32);index:idx_audit_target"`
+	TargetId   string `gorm:"column:target_id;type:varchar(64);index:idx_audit_target"`
+	Before     string `gorm:"column:before;type:text"` // 变更前有变化的字段，JSON
+	After      string `gorm:"column:after;type:text"`  // 变更后有变化的字段，JSON
+	IP         string `gorm:"column:ip;type:varchar(64)"`
+	RequestId  string `gorm:"column:request_id;type:varchar(64)"`
+	PrevHash   string `gorm:"column:prev_hash;type:varchar(64);uniqueIndex"`
+	Hash       string `gorm:"column:hash;type:varchar(64);uniqueIndex;not null"`
+	CreatedAt  int64  `gorm:"column:created_at;index;not null"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// AuditFilter 审计日志筛选条件
+type AuditFilter struct {
+	ActorId    *int64
+	Action     string
+	TargetType string
+	TargetId   string
+	Start      int64 // 起始时间（毫秒，含）
+	End        int64 // 结束时间（毫秒，不含）
+}
+
+func (f AuditFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.ActorId != nil {
+		db = db.Where("actor_id = ?", *f.ActorId)
+	}
+	if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetId != "" {
+		db = db.Where("target_id = ?", f.TargetId)
+	}
+	if f.Start > 0 {
+		db = db.Where("created_at >= ?", f.Start)
+	}
+	if f.End > 0 {
+		db = db.Where("created_at < ?", f.End)
+	}
+	return db
+}
+
+// computeHash 按固定顺序序列化所有字段后计算哈希
+func (e AuditEvent) computeHash() string {
+	data, _ := json.Marshal([]interface{}{
+		e.PrevHash, e.ActorId, e.Actor, e.Action, e.TargetType, e.TargetId,
+		e.Before, e.After, e.IP, e.RequestId, e.CreatedAt,
+	})
+	return utils.SHA256(string(data))
+}
+
+// createAuditTriggers 在数据库层禁止修改和删除审计日志
+func createAuditTriggers() error {
+	for _, sql := range []string{
+		"CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events " +
+			"BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END",
+		"CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events " +
+			"BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END",
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 串行追加，保证哈希链不分叉；prev_hash 的唯一索引兜底
+var auditMu sync.Mutex
+
+// AppendAuditEvent 追加审计日志，计算哈希链
+func AppendAuditEvent(ctx context.Context, e *AuditEvent) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var last AuditEvent
+		err := tx.Select("hash").Order("id DESC").First(&last).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		e.PrevHash = last.Hash
+		e.Hash = e.computeHash()
+		return tx.Create(e).Error
+	})
+}
+
+// GetAuditEvents 按条件查询审计日志，按时间倒序
+func GetAuditEvents(ctx context.Context, filter AuditFilter, offset, limit int) ([]*AuditEvent, error) {
+	var events []*AuditEvent
+	err := filter.apply(getDb(ctx).Model(&AuditEvent{})).Order("id DESC").Offset(offset).Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CountAuditEvents 按条件统计审计日志
+func CountAuditEvents(ctx context.Context, filter AuditFilter) (int64, error) {
+	var count int64
+	err := filter.apply(getDb(ctx).Model(&AuditEvent{})).Count(&count).Error
+	return count, err
+}
+
+// AuditVerifyResult 哈希链校验结果
+type AuditVerifyResult struct {
+	Checked  int64 `json:"checked"`   // 已校验的记录数
+	Valid    bool  `json:"valid"`     // 哈希链是否完整
+	BrokenId int64 `json:"broken_id"` // 第一条校验失败的记录
+}
+
+// VerifyAuditChain 按顺序重新计算哈希，检查记录是否被篡改、删除或插入
+func VerifyAuditChain(ctx context.Context) (*AuditVerifyResult, error) {
+	const batchSize = 500
+	res := &AuditVerifyResult{Valid: true}
+	prevHash := ""
+	var lastId int64
+	for {
+		var events []*AuditEvent
+		err := getDb(ctx).Where("id > ?", lastId).Order("id ASC").Limit(batchSize).Find(&events).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			if e.PrevHash != prevHash || e.computeHash() != e.Hash {
+				res.Valid = false
+				res.BrokenId = e.Id
+				return res, nil
+			}
+			prevHash = e.Hash
+			lastId = e.Id
+			res.Checked++
+		}
+		if len(events) < batchSize {
+			return res, nil
+		}
+	}
+}
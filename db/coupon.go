@@ -2,24 +2,36 @@ package db
 
 import (
 	"context"
+	"slices"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type Coupon struct {
-	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
-	Coupon    string `gorm:"column:coupon;type:varchar(128);uniqueIndex;not null"`
-	Type      int    `gorm:"column:type;index;not null;default:1"` // 卡券类型: 1=健身卡
-	Creator   int64  `gorm:"column:creator;index"`
-	Taker     int64  `gorm:"column:taker;index"`
-	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
-	UpdatedAt int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	Coupon     string `gorm:"column:coupon;type:varchar(128);uniqueIndex;not null"`
+	Type       int    `gorm:"column:type;index;index:idx_coupon_claims,priority:2;not null;default:1"` // 卡券类型: 1=健身卡
+	Creator    int64  `gorm:"column:creator;index"`
+	Taker      int64  `gorm:"column:taker;index;index:idx_coupon_claims,priority:1"`
+	State      string `gorm:"column:state;type:varchar(16);index;not null;default:issued"`  // 见 CouponState*
+	ValidFrom  int64  `gorm:"column:valid_from;default:0"`                                  // 生效时间，为 0 表示不限制
+	ValidUntil int64  `gorm:"column:valid_until;index;default:0"`                           // 过期时间，为 0 表示长期有效
+	ExpiredAt  int64  `gorm:"column:expired_at;default:0"`                                  // 未领取的卡券被过期任务标记的时间
+	TakenAt    int64  `gorm:"column:taken_at;index:idx_coupon_claims,priority:3;default:0"` // 领取时间，退回后清零
+	UsedAt     int64  `gorm:"column:used_at;default:0"`                                     // 使用时间
+	CreatedAt  int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt  int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
 }
 
 // CouponFilter 卡券筛选条件
 type CouponFilter struct {
-	Type  *int  // 卡券类型
-	Taken *bool // 是否已领取
+	Type    *int     // 卡券类型
+	Types   []int    // 限定的卡券类型，为 nil 时不限
+	Takers  []int64  // 限定的领取者，为 nil 时不限
+	States  []string // 限定的状态，为 nil 时不限
+	Taken   *bool    // 是否有领取者
+	Expired *bool    // 是否已过期
 }
 
 // applyFilter 应用筛选条件
@@ -27,6 +39,15 @@ func (f CouponFilter) applyFilter(db *gorm.DB) *gorm.DB {
 	if f.Type != nil {
 		db = db.Where("type = ?", *f.Type)
 	}
+	if f.Types != nil {
+		db = db.Where("type IN ?", f.Types)
+	}
+	if f.Takers != nil {
+		db = db.Where("taker IN ?", f.Takers)
+	}
+	if f.States != nil {
+		db = db.Where("state IN ?", f.States)
+	}
 	if f.Taken != nil {
 		if *f.Taken {
 			db = db.Where("taker > 0")
@@ -34,26 +55,75 @@ func (f CouponFilter) applyFilter(db *gorm.DB) *gorm.DB {
 			db = db.Where("taker = 0")
 		}
 	}
+	if f.Expired != nil {
+		now := time.Now().UnixMilli()
+		if *f.Expired {
+			db = db.Where("valid_until > 0 AND valid_until <= ?", now)
+		} else {
+			db = db.Where("(valid_until = 0 OR valid_until > ?)", now)
+		}
+	}
 	return db
 }
 
+// availableAt 库存中且在有效期内的卡券
+func availableAt(db *gorm.DB, now int64) *gorm.DB {
+	return db.Where("state IN ? AND taker = 0", couponStockStates).
+		Where("(valid_from = 0 OR valid_from <= ?) AND (valid_until = 0 OR valid_until > ?)", now, now)
+}
+
+// 先过期先发放，没有过期时间的排在最后
+const fefoOrder = "CASE WHEN valid_until = 0 THEN 1 ELSE 0 END, valid_until ASC, id ASC"
+
 func (Coupon) TableName() string {
 	return "coupons"
 }
 
-// IsTaken 检查卡券是否已被领取
+// IsTaken 检查卡券是否在领取者手中（已领取或已使用）
 func (c Coupon) IsTaken() bool {
-	return c.Taker > 0
+	return c.State == CouponStateTaken || c.State == CouponStateUsed
 }
 
-// CreateCoupon 创建卡券
+// InStock 检查卡券是否在库存中（未领取或已退回）
+func (c Coupon) InStock() bool {
+	return slices.Contains(couponStockStates, c.State)
+}
+
+// IsExpired 检查卡券在 now 时是否已过期
+func (c Coupon) IsExpired(now int64) bool {
+	return c.ValidUntil > 0 && c.ValidUntil <= now
+}
+
+// CreateCoupon 创建卡券，并记录入库
 func CreateCoupon(ctx context.Context, coupon *Coupon) error {
-	return getDb(ctx).Create(coupon).Error
+	if coupon.State == "" {
+		coupon.State = CouponStateIssued
+	}
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(coupon).Error; err != nil {
+			return err
+		}
+		return tx.Create(&CouponEvent{CouponId: coupon.Id, ToState: coupon.State, Operator: coupon.Creator}).Error
+	})
 }
 
-// BatchCreateCoupons 批量创建卡券
+// BatchCreateCoupons 批量创建卡券，并记录入库
 func BatchCreateCoupons(ctx context.Context, coupons []*Coupon) error {
-	return getDb(ctx).CreateInBatches(coupons, 100).Error
+	for _, c := range coupons {
+		if c.State == "" {
+			c.State = CouponStateIssued
+		}
+	}
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(coupons, 100).Error; err != nil {
+			return err
+		}
+		events := make([]*CouponEvent, 0, len(coupons))
+		for _, c := range coupons {
+			events = append(events, &CouponEvent{CouponId: c.Id, ToState: c.State, Operator: c.Creator})
+		}
+		return tx.CreateInBatches(events, 100).Error
+	})
 }
 
 // GetCouponById 根据ID查询卡券
@@ -96,10 +166,10 @@ func GetCouponListByCreator(ctx context.Context, creator int64, offset, limit in
 	return coupons, nil
 }
 
-// GetAvailableCoupons 获取未被领取的卡券列表
+// GetAvailableCoupons 获取未被领取且在有效期内的卡券列表
 func GetAvailableCoupons(ctx context.Context, offset, limit int) ([]*Coupon, error) {
 	var coupons []*Coupon
-	err := getDb(ctx).Where("taker = 0").Order("id DESC").Offset(offset).Limit(limit).Find(&coupons).Error
+	err := availableAt(getDb(ctx), time.Now().UnixMilli()).Order("id DESC").Offset(offset).Limit(limit).Find(&coupons).Error
 	if err != nil {
 		return nil, err
 	}
@@ -116,21 +186,22 @@ func UpdateCouponFields(ctx context.Context, id int64, fields map[string]interfa
 	return getDb(ctx).Model(&Coupon{}).Where("id = ?", id).Updates(fields).Error
 }
 
-// TakeCoupon 领取卡券
-func TakeCoupon(ctx context.Context, id int64, taker int64) error {
-	result := getDb(ctx).Model(&Coupon{}).Where("id = ? AND taker = 0", id).Update("taker", taker)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return ErrCouponAlreadyTaken
-	}
-	return nil
-}
-
-// DeleteCoupon 删除卡券
+// DeleteCoupon 删除卡券及其入库记录，入库后有过任何状态变更的卡券需要保留记录，不能删除
 func DeleteCoupon(ctx context.Context, id int64) error {
-	return getDb(ctx).Where("id = ?", id).Delete(&Coupon{}).Error
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var changes int64
+		err := tx.Model(&CouponEvent{}).Where("coupon_id = ? AND from_state <> ''", id).Count(&changes).Error
+		if err != nil {
+			return err
+		}
+		if changes > 0 {
+			return ErrCouponHasHistory
+		}
+		if err := tx.Where("coupon_id = ?", id).Delete(&CouponEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&Coupon{}).Error
+	})
 }
 
 // CountCoupons 统计卡券总数
@@ -147,10 +218,10 @@ func CountCouponsByCreator(ctx context.Context, creator int64) (int64, error) {
 	return count, err
 }
 
-// CountAvailableCoupons 统计未被领取的卡券总数
+// CountAvailableCoupons 统计未被领取且在有效期内的卡券总数
 func CountAvailableCoupons(ctx context.Context) (int64, error) {
 	var count int64
-	err := getDb(ctx).Model(&Coupon{}).Where("taker = 0").Count(&count).Error
+	err := availableAt(getDb(ctx).Model(&Coupon{}), time.Now().UnixMilli()).Count(&count).Error
 	return count, err
 }
 
@@ -173,14 +244,17 @@ func CountCouponsWithFilter(ctx context.Context, filter CouponFilter) (int64, er
 	return count, err
 }
 
-// GetCouponsByTaker 根据领取者查询卡券列表
-func GetCouponsByTaker(ctx context.Context, taker int64, typeFilter *int, offset, limit int) ([]*Coupon, error) {
+// GetCouponsByTaker 根据领取者查询卡券列表，states 为 nil 时不限状态
+func GetCouponsByTaker(ctx context.Context, taker int64, typeFilter *int, states []string, offset, limit int) ([]*Coupon, error) {
 	var coupons []*Coupon
 	query := getDb(ctx).Where("taker = ?", taker)
 	if typeFilter != nil {
 		query = query.Where("type = ?", *typeFilter)
 	}
-	err := query.Order("updated_at DESC").Offset(offset).Limit(limit).Find(&coupons).Error
+	if states != nil {
+		query = query.Where("state IN ?", states)
+	}
+	err := query.Order("taken_at DESC, id DESC").Offset(offset).Limit(limit).Find(&coupons).Error
 	if err != nil {
 		return nil, err
 	}
@@ -188,39 +262,43 @@ func GetCouponsByTaker(ctx context.Context, taker int64, typeFilter *int, offset
 }
 
 // CountCouponsByTaker 统计领取者的卡券总数
-func CountCouponsByTaker(ctx context.Context, taker int64, typeFilter *int) (int64, error) {
+func CountCouponsByTaker(ctx context.Context, taker int64, typeFilter *int, states []string) (int64, error) {
 	var count int64
 	query := getDb(ctx).Model(&Coupon{}).Where("taker = ?", taker)
 	if typeFilter != nil {
 		query = query.Where("type = ?", *typeFilter)
 	}
+	if states != nil {
+		query = query.Where("state IN ?", states)
+	}
 	err := query.Count(&count).Error
 	return count, err
 }
 
-// CountAvailableCouponsByType 统计指定类型未被领取的卡券总数
+// CountAvailableCouponsByType 统计指定类型未被领取且在有效期内的卡券总数
 func CountAvailableCouponsByType(ctx context.Context, couponType int) (int64, error) {
 	var count int64
-	err := getDb(ctx).Model(&Coupon{}).Where("taker = 0 AND type = ?", couponType).Count(&count).Error
+	query := availableAt(getDb(ctx).Model(&Coupon{}), time.Now().UnixMilli())
+	err := query.Where("type = ?", couponType).Count(&count).Error
 	return count, err
 }
 
-// GetOneAvailableCouponByType 获取一个指定类型的未领取卡券
-func GetOneAvailableCouponByType(ctx context.Context, couponType int) (*Coupon, error) {
-	var coupon Coupon
-	err := getDb(ctx).Where("taker = 0 AND type = ?", couponType).Order("id ASC").First(&coupon).Error
-	if err != nil {
-		return nil, err
-	}
-	return &coupon, nil
+// ExpireCoupons 标记已过期但未领取的卡券，返回标记的数量
+func ExpireCoupons(ctx context.Context, now int64) (int64, error) {
+	result := getDb(ctx).Model(&Coupon{}).
+		Where("state IN ? AND taker = 0 AND expired_at = 0 AND valid_until > 0 AND valid_until <= ?", couponStockStates, now).
+		Update("expired_at", now)
+	return result.RowsAffected, result.Error
 }
 
-// GetLastTakenCouponByTakerAndType 获取用户最后领取的指定类型卡券
-func GetLastTakenCouponByTakerAndType(ctx context.Context, taker int64, couponType int) (*Coupon, error) {
-	var coupon Coupon
-	err := getDb(ctx).Where("taker = ? AND type = ?", taker, couponType).Order("updated_at DESC").First(&coupon).Error
+// GetExpiringCouponsByTaker 查询用户已领取未使用且在 before 之前过期的卡券，按过期时间排序
+func GetExpiringCouponsByTaker(ctx context.Context, taker int64, now, before int64) ([]*Coupon, error) {
+	var coupons []*Coupon
+	err := getDb(ctx).Where("taker = ? AND state = ?", taker, CouponStateTaken).
+		Where("valid_until > ? AND valid_until <= ?", now, before).
+		Order("valid_until ASC").Find(&coupons).Error
 	if err != nil {
 		return nil, err
 	}
-	return &coupon, nil
-}
\ No newline at end of file
+	return coupons, nil
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"pionex-administrative-sys/utils/quota"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// claimMu 串行化进程内的领取和发放，减少等待写锁和重试。跨进程的正确性
+// 由事务开始时获取写锁保证（见 dsn），不依赖 claimMu
+var claimMu sync.Mutex
+
+// 领取遇到并发变更或数据库繁忙时的最大尝试次数
+const claimMaxAttempts = 3
+
+// CouponClaim 计入领取配额的记录：申领、发放和计入配额的转让各写入一条，
+// 退回时删除退回人的记录。卡券转让后仍计入原领取人的配额
+type CouponClaim struct {
+	Id         int64 `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId     int64 `gorm:"column:user_id;not null;index:idx_claim_quota"`
+	CouponType int   `gorm:"column:coupon_type;not null;index:idx_claim_quota"`
+	ClaimedAt  int64 `gorm:"column:claimed_at;not null;index:idx_claim_quota"`
+	CouponId   int64 `gorm:"column:coupon_id;index;not null"`
+}
+
+func (CouponClaim) TableName() string {
+	return "coupon_claims"
+}
+
+// migrateCouponClaims 升级前按领取者和领取时间计算配额，为没有记录的已领取卡券补写
+func migrateCouponClaims() error {
+	return db.Exec(`INSERT INTO coupon_claims (user_id, coupon_type, claimed_at, coupon_id)
+		SELECT taker, type, taken_at, id FROM coupons
+		WHERE taker > 0 AND NOT EXISTS (SELECT 1 FROM coupon_claims c WHERE c.coupon_id = coupons.id)`).Error
+}
+
+// ClaimResult 领取结果
+type ClaimResult struct {
+	Coupon    *Coupon      // 领取到的卡券，已更新为领取后的状态
+	FromState string       // 领取前的状态
+	Quota     quota.Result // 领取前的配额
+}
+
+// ClaimCoupon 为用户领取一张指定类型的卡券：在同一事务中校验配额，
+// 并按先过期先发放挑选和分配卡券。配额不足返回 ErrQuotaExceeded，
+// 此时结果中包含配额信息；没有可领取的卡券返回 ErrCouponOutOfStock
+func ClaimCoupon(ctx context.Context, userId int64, couponType int, now time.Time) (*ClaimResult, error) {
+	var res *ClaimResult
+	err := withClaimRetry(ctx, func() (err error) {
+		res, err = claimOnce(getDb(ctx), userId, couponType, now)
+		return err
+	})
+	return res, err
+}
+
+// withClaimRetry 持有 claimMu 执行分配卡券的事务，遇到并发变更或数据库繁忙时有限次重试。
+// 并发变更时库存中可能还有其他卡券，重试会重新挑选
+func withClaimRetry(ctx context.Context, fn func() error) error {
+	return withClaimLock(ctx, func(err error) bool {
+		return errors.Is(err, ErrCouponStateChanged) || isBusy(err)
+	}, fn)
+}
+
+// withTransferRetry 持有 claimMu 执行转让事务，只在数据库繁忙时重试。
+// 转让的卡券是确定的，状态变化说明转让人已使用或卡券已作废，重试也不会成功
+func withTransferRetry(ctx context.Context, fn func() error) error {
+	return withClaimLock(ctx, isBusy, fn)
+}
+
+// withClaimLock 持有 claimMu 执行 fn，retryable 返回 true 的错误有限次重试
+func withClaimLock(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if attempt >= claimMaxAttempts || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 20 * time.Millisecond):
+		}
+	}
+}
+
+// claimOnce 在一个事务中校验配额并分配卡券。事务开始时已持有写锁，
+// 校验配额到写入领取记录之间其他连接和进程无法写入，配额不会被并发申领超出
+func claimOnce(db *gorm.DB, userId int64, couponType int, now time.Time) (*ClaimResult, error) {
+	res := &ClaimResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		q, err := userQuota(tx, userId, couponType, now)
+		if err != nil {
+			return err
+		}
+		res.Quota = q
+		if !q.Unlimited && q.Remaining <= 0 {
+			return ErrQuotaExceeded
+		}
+
+		var c Coupon
+		err = availableAt(tx, now.UnixMilli()).Where("type = ?", couponType).Order(fefoOrder).First(&c).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCouponOutOfStock
+		}
+		if err != nil {
+			return err
+		}
+
+		// 以读取到的状态为条件更新，防止卡券在挑选后被其他写入修改
+		takenAt := now.UnixMilli()
+		result := tx.Model(&Coupon{}).Where("id = ? AND state = ? AND taker = 0", c.Id, c.State).
+			Updates(map[string]interface{}{"state": CouponStateTaken, "taker": userId, "taken_at": takenAt})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCouponStateChanged
+		}
+		err = tx.Create(&CouponEvent{
+			CouponId:  c.Id,
+			FromState: c.State,
+			ToState:   CouponStateTaken,
+			Operator:  userId,
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&CouponClaim{UserId: userId, CouponType: couponType, ClaimedAt: takenAt, CouponId: c.Id}).Error
+		if err != nil {
+			return err
+		}
+
+		res.FromState = c.State
+		c.State, c.Taker, c.TakenAt = CouponStateTaken, userId, takenAt
+		res.Coupon = &c
+		return nil
+	})
+	if errors.Is(err, ErrQuotaExceeded) {
+		return res, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// isBusy 是否为 SQLite 的 BUSY/LOCKED 错误，两个事务同时升级写锁时会立即返回
+func isBusy(err error) bool {
+	var e interface{ Code() int }
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Code() & 0xff {
+	case 5, 6: // SQLITE_BUSY, SQLITE_LOCKED
+		return true
+	}
+	return false
+}
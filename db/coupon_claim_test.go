@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"pionex-administrative-sys/utils/quota"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// openTestDB 切换到临时目录中的新数据库，测试结束后恢复，两次切换都清空缓存，返回数据库文件路径
+func openTestDB(t *testing.T) string {
+	t.Helper()
+	prev := db
+	clearSettingCache()
+	path := filepath.Join(t.TempDir(), "data.db")
+	if err := open(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+		db = prev
+		clearSettingCache()
+		if db != nil {
+			_ = reloadCouponTypes(db)
+		}
+	})
+	return path
+}
+
+func clearSettingCache() {
+	settingCache.Range(func(k, _ interface{}) bool {
+		settingCache.Delete(k)
+		return true
+	})
+}
+
+// setQuota 用一条默认策略替换类型的配额策略，rule 为 nil 时不限制
+func setQuota(t *testing.T, couponType int, rule *QuotaPolicy) {
+	t.Helper()
+	if err := db.Where("coupon_type = ?", couponType).Delete(&QuotaPolicy{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rule == nil {
+		return
+	}
+	rule.CouponType, rule.Scope = couponType, QuotaScopeDefault
+	if err := db.Create(rule).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addStock 为类型入库 n 张卡券
+func addStock(t *testing.T, couponType, n int) {
+	t.Helper()
+	coupons := make([]*Coupon, 0, n)
+	for i := 0; i < n; i++ {
+		coupons = append(coupons, &Coupon{Coupon: fmt.Sprintf("T%d-%04d", couponType, i), Type: couponType, Creator: 1})
+	}
+	if err := BatchCreateCoupons(context.Background(), coupons); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// claimConcurrently 并发执行 attempts 次申领，第 i 次由 userOf(i) 申领，返回各结果的数量
+func claimConcurrently(t *testing.T, couponType, attempts int, userOf func(i int) int64, now time.Time) map[error]int {
+	t.Helper()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[error]int)
+	)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, err := ClaimCoupon(context.Background(), userOf(i), couponType, now)
+			mu.Lock()
+			results[err]++
+			mu.Unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for err, n := range results {
+		if err != nil && !errors.Is(err, ErrQuotaExceeded) && !errors.Is(err, ErrCouponOutOfStock) {
+			t.Fatalf("unexpected error %v (%d times)", err, n)
+		}
+	}
+	return results
+}
+
+// assertNoDoubleClaims 每张卡券最多被领取一次，领取记录与卡券的领取者一致
+func assertNoDoubleClaims(t *testing.T, couponType int, wantTaken int) {
+	t.Helper()
+	var taken int64
+	if err := db.Model(&Coupon{}).Where("type = ? AND state = ?", couponType, CouponStateTaken).Count(&taken).Error; err != nil {
+		t.Fatal(err)
+	}
+	if taken != int64(wantTaken) {
+		t.Fatalf("taken coupons = %d, want %d", taken, wantTaken)
+	}
+
+	var dup []int64
+	err := db.Model(&CouponClaim{}).Where("coupon_type = ?", couponType).
+		Group("coupon_id").Having("COUNT(*) > 1").Pluck("coupon_id", &dup).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dup) > 0 {
+		t.Fatalf("coupons claimed more than once: %v", dup)
+	}
+
+	var mismatched int64
+	err = db.Model(&CouponClaim{}).Joins("JOIN coupons ON coupons.id = coupon_claims.coupon_id").
+		Where("coupon_claims.coupon_type = ? AND coupons.taker <> coupon_claims.user_id", couponType).
+		Count(&mismatched).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims int64
+	if err := db.Model(&CouponClaim{}).Where("coupon_type = ?", couponType).Count(&claims).Error; err != nil {
+		t.Fatal(err)
+	}
+	if mismatched > 0 || claims != taken {
+		t.Fatalf("claims = %d (%d mismatched), taken = %d", claims, mismatched, taken)
+	}
+
+	var events int64
+	err = db.Model(&CouponEvent{}).Joins("JOIN coupons ON coupons.id = coupon_events.coupon_id").
+		Where("coupons.type = ? AND coupon_events.to_state = ?", couponType, CouponStateTaken).Count(&events).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != taken {
+		t.Fatalf("take events = %d, want %d", events, taken)
+	}
+}
+
+func TestClaimCouponConcurrentQuota(t *testing.T) {
+	openTestDB(t)
+	const (
+		users    = 40
+		perUser  = 8
+		limit    = 2
+		stock    = 500
+		attempts = users * perUser
+	)
+	setQuota(t, couponTypeFitness, &QuotaPolicy{Period: quota.PeriodRolling, WindowMinutes: 12 * 60, Limit: limit})
+	addStock(t, couponTypeFitness, stock)
+
+	now := time.Now()
+	results := claimConcurrently(t, couponTypeFitness, attempts, func(i int) int64 { return int64(100 + i%users) }, now)
+	if results[nil] != users*limit || results[ErrQuotaExceeded] != attempts-users*limit {
+		t.Fatalf("results = %v, want %d claims and %d quota errors", results, users*limit, attempts-users*limit)
+	}
+	assertNoDoubleClaims(t, couponTypeFitness, users*limit)
+
+	// 每个用户都恰好用完配额
+	for u := int64(100); u < 100+users; u++ {
+		q, err := GetUserQuota(context.Background(), u, couponTypeFitness, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Used != limit || q.Remaining != 0 || q.Rule.Period != quota.PeriodRolling {
+			t.Fatalf("user %d quota = %+v", u, q)
+		}
+	}
+}
+
+func TestClaimCouponConcurrentStock(t *testing.T) {
+	openTestDB(t)
+	const (
+		stock    = 30
+		attempts = 300
+	)
+	setQuota(t, couponTypeFitness, nil)
+	addStock(t, couponTypeFitness, stock)
+
+	// 同一用户和不同用户混合申领，没有配额限制时只受库存约束
+	results := claimConcurrently(t, couponTypeFitness, attempts, func(i int) int64 { return int64(100 + i%50) }, time.Now())
+	if results[nil] != stock || results[ErrCouponOutOfStock] != attempts-stock {
+		t.Fatalf("results = %v, want %d claims and %d out of stock", results, stock, attempts-stock)
+	}
+	assertNoDoubleClaims(t, couponTypeFitness, stock)
+}
+
+func TestClaimCouponAcrossConnections(t *testing.T) {
+	path := openTestDB(t)
+	const (
+		users    = 20
+		perUser  = 10
+		limit    = 2
+		attempts = users * perUser
+	)
+	setQuota(t, couponTypeFitness, &QuotaPolicy{Period: quota.PeriodLifetime, Limit: limit})
+	addStock(t, couponTypeFitness, attempts)
+
+	// 另一个连接池模拟共用数据库文件的其他进程，不经过 claimMu。
+	// 事务开始时即获取写锁，等待期间不会因升级写锁冲突而返回繁忙
+	other, err := gorm.Open(sqlite.Open(dsn(path)), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := other.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	conns := []*gorm.DB{db, other}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[error]int)
+	)
+	now := time.Now()
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			var err error
+			for {
+				_, err = claimOnce(conns[i%len(conns)], int64(100+i%users), couponTypeFitness, now)
+				if !errors.Is(err, ErrCouponStateChanged) {
+					break
+				}
+			}
+			mu.Lock()
+			results[err]++
+			mu.Unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if results[nil] != users*limit || results[ErrQuotaExceeded] != attempts-users*limit {
+		t.Fatalf("results = %v, want %d claims and %d quota errors", results, users*limit, attempts-users*limit)
+	}
+	assertNoDoubleClaims(t, couponTypeFitness, users*limit)
+}
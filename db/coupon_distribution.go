@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"pionex-administrative-sys/utils/logger"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 发放对象
+const (
+	DistributionTargetUsers      = "users"      // 指定的用户或账号
+	DistributionTargetDepartment = "department" // 部门及其下级部门的用户
+	DistributionTargetRole       = "role"       // 拥有该角色的用户
+)
+
+// 发放任务状态
+const (
+	DistributionStatusRunning = "running" // 等待或正在发放，服务重启后继续
+	DistributionStatusDone    = "done"
+	DistributionStatusFailed  = "failed"
+)
+
+// CouponDistribution 管理员发起的卡券发放任务，按用户逐个分配
+type CouponDistribution struct {
+	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	CouponType int    `gorm:"column:coupon_type;index;not null"`
+	TargetType string `gorm:"column:target_type;type:varchar(16);not null"`
+	TargetId   int64  `gorm:"column:target_id;default:0"` // 部门或角色 ID
+	PerUser    int    `gorm:"column:per_user;not null"`   // 每人发放数量
+	Note       string `gorm:"column:note;type:varchar(255)"`
+	Status     string `gorm:"column:status;type:varchar(16);index;not null"`
+	UserCount  int    `gorm:"column:user_count;default:0"`
+	Processed  int    `gorm:"column:processed;default:0"` // 已处理的用户数
+	Assigned   int    `gorm:"column:assigned;default:0"`  // 已发放的卡券数
+	Shortfall  int    `gorm:"column:shortfall;default:0"` // 库存不足未发放的卡券数
+	Error      string `gorm:"column:error;type:varchar(255)"`
+	Operator   int64  `gorm:"column:operator;default:0"`
+	CreatedAt  int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt  int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+	FinishedAt int64  `gorm:"column:finished_at;default:0"`
+}
+
+func (CouponDistribution) TableName() string {
+	return "coupon_distributions"
+}
+
+// CouponDistributionItem 发放任务中每个用户的发放结果
+type CouponDistributionItem struct {
+	Id             int64   `gorm:"column:id;primaryKey;autoIncrement"`
+	DistributionId int64   `gorm:"column:distribution_id;index;not null"`
+	UserId         int64   `gorm:"column:user_id;not null"`
+	Requested      int     `gorm:"column:requested;not null"`
+	Assigned       int     `gorm:"column:assigned;default:0"`
+	CouponIds      []int64 `gorm:"column:coupon_ids;serializer:json"`
+	Done           bool    `gorm:"column:done;default:false"`
+	UpdatedAt      int64   `gorm:"column:updated_at;autoUpdateTime:milli"`
+}
+
+func (CouponDistributionItem) TableName() string {
+	return "coupon_distribution_items"
+}
+
+// DistributionPreview 按当前库存预估的发放结果，按用户顺序分配
+type DistributionPreview struct {
+	Users        int
+	Requested    int
+	Stock        int64
+	Shortfall    int
+	ShortUserIds []int64 // 不能足额发放的用户
+}
+
+// PreviewCouponDistribution 预估向 userIds 每人发放 perUser 张卡券的结果
+func PreviewCouponDistribution(ctx context.Context, couponType int, userIds []int64, perUser int) (*DistributionPreview, error) {
+	stock, err := CountAvailableCouponsByType(ctx, couponType)
+	if err != nil {
+		return nil, err
+	}
+	p := &DistributionPreview{
+		Users:        len(userIds),
+		Requested:    len(userIds) * perUser,
+		Stock:        stock,
+		ShortUserIds: []int64{},
+	}
+	if int64(p.Requested) > stock {
+		p.Shortfall = p.Requested - int(stock)
+		p.ShortUserIds = userIds[int(stock)/perUser:]
+	}
+	return p, nil
+}
+
+// GetDistributionTargetUsers 查询部门（含下级部门）或角色下的用户，按 ID 排序
+func GetDistributionTargetUsers(ctx context.Context, targetType string, targetId int64) ([]*User, error) {
+	query := getDb(ctx).Order("id")
+	switch targetType {
+	case DistributionTargetDepartment:
+		deptIds, err := GetDepartmentSubtreeIds(ctx, targetId)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("department_id IN ?", deptIds)
+	case DistributionTargetRole:
+		query = query.Where("id IN (?)", getDb(ctx).Model(&UserRole{}).Select("user_id").Where("role_id = ?", targetId))
+	default:
+		return nil, fmt.Errorf("unsupported distribution target: %s", targetType)
+	}
+	var users []*User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CreateCouponDistribution 创建发放任务和每个用户的发放记录，任务由后台按顺序执行
+func CreateCouponDistribution(ctx context.Context, d *CouponDistribution, userIds []int64) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		d.Status = DistributionStatusRunning
+		d.UserCount = len(userIds)
+		if err := tx.Create(d).Error; err != nil {
+			return err
+		}
+		items := make([]*CouponDistributionItem, 0, len(userIds))
+		for _, uid := range userIds {
+			items = append(items, &CouponDistributionItem{
+				DistributionId: d.Id,
+				UserId:         uid,
+				Requested:      d.PerUser,
+			})
+		}
+		return tx.CreateInBatches(items, 200).Error
+	})
+}
+
+// GetCouponDistributionById 根据 ID 查询发放任务
+func GetCouponDistributionById(ctx context.Context, id int64) (*CouponDistribution, error) {
+	var d CouponDistribution
+	if err := getDb(ctx).Where("id = ?", id).First(&d).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetCouponDistributions 查询发放任务列表，types 为 nil 时不限类型
+func GetCouponDistributions(ctx context.Context, types []int, offset, limit int) ([]*CouponDistribution, int64, error) {
+	query := getDb(ctx).Model(&CouponDistribution{})
+	if types != nil {
+		query = query.Where("coupon_type IN ?", types)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []*CouponDistribution
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// GetCouponDistributionItems 查询发放任务中每个用户的发放结果
+func GetCouponDistributionItems(ctx context.Context, distributionId int64) ([]*CouponDistributionItem, error) {
+	var items []*CouponDistributionItem
+	if err := getDb(ctx).Where("distribution_id = ?", distributionId).Order("id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetRunningCouponDistributionIds 查询未完成的发放任务，按创建顺序
+func GetRunningCouponDistributionIds(ctx context.Context) ([]int64, error) {
+	var ids []int64
+	err := getDb(ctx).Model(&CouponDistribution{}).Where("status = ?", DistributionStatusRunning).
+		Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// RunCouponDistribution 依次为未处理的用户分配卡券，每个用户在一个事务中完成；
+// ctx 取消时停止，任务保持 running，下次启动后继续
+func RunCouponDistribution(ctx context.Context, id int64) error {
+	d, err := GetCouponDistributionById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if d.Status != DistributionStatusRunning {
+		return nil
+	}
+	var items []*CouponDistributionItem
+	if err := getDb(ctx).Where("distribution_id = ? AND done = ?", id, false).Order("id").Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := withClaimRetry(ctx, func() error {
+			return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+				return assignDistributionItem(tx, d, item, time.Now().UnixMilli())
+			})
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			// 状态未能更新时发放仍为 running，重启后会继续执行
+			if ferr := finishCouponDistribution(ctx, id, DistributionStatusFailed, err.Error()); ferr != nil {
+				logger.Error("mark coupon distribution failed", zap.Int64("id", id), zap.NamedError("cause", err), zap.Error(ferr))
+			}
+			return err
+		}
+	}
+	return finishCouponDistribution(ctx, id, DistributionStatusDone, "")
+}
+
+// assignDistributionItem 按先过期先发放为用户分配卡券，库存不足时部分发放并记录缺口
+func assignDistributionItem(tx *gorm.DB, d *CouponDistribution, item *CouponDistributionItem, now int64) error {
+	var coupons []*Coupon
+	err := availableAt(tx, now).Where("type = ?", d.CouponType).Order(fefoOrder).Limit(item.Requested).Find(&coupons).Error
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(coupons))
+	events := make([]*CouponEvent, 0, len(coupons))
+	claims := make([]*CouponClaim, 0, len(coupons))
+	note := fmt.Sprintf("发放任务 #%d", d.Id)
+	for _, c := range coupons {
+		result := tx.Model(&Coupon{}).Where("id = ? AND state = ? AND taker = 0", c.Id, c.State).
+			Updates(map[string]interface{}{"state": CouponStateTaken, "taker": item.UserId, "taken_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCouponStateChanged
+		}
+		ids = append(ids, c.Id)
+		events = append(events, &CouponEvent{
+			CouponId:  c.Id,
+			FromState: c.State,
+			ToState:   CouponStateTaken,
+			Operator:  d.Operator,
+			Note:      note,
+		})
+		claims = append(claims, &CouponClaim{UserId: item.UserId, CouponType: d.CouponType, ClaimedAt: now, CouponId: c.Id})
+	}
+	if len(events) > 0 {
+		if err := tx.Create(&events).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&claims).Error; err != nil {
+			return err
+		}
+	}
+
+	err = tx.Model(&CouponDistributionItem{}).Where("id = ?", item.Id).Select("assigned", "coupon_ids", "done").
+		Updates(&CouponDistributionItem{Assigned: len(ids), CouponIds: ids, Done: true}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&CouponDistribution{}).Where("id = ?", d.Id).Updates(map[string]interface{}{
+		"processed": gorm.Expr("processed + 1"),
+		"assigned":  gorm.Expr("assigned + ?", len(ids)),
+		"shortfall": gorm.Expr("shortfall + ?", item.Requested-len(ids)),
+	}).Error
+}
+
+func finishCouponDistribution(ctx context.Context, id int64, status, errMsg string) error {
+	if len(errMsg) > 255 {
+		errMsg = errMsg[:255]
+	}
+	return getDb(ctx).Model(&CouponDistribution{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      status,
+		"error":       errMsg,
+		"finished_at": time.Now().UnixMilli(),
+	}).Error
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 卡券状态
+const (
+	CouponStateIssued   = "issued"   // 已入库，等待领取
+	CouponStateTaken    = "taken"    // 已领取
+	CouponStateUsed     = "used"     // 已使用
+	CouponStateReturned = "returned" // 领取后退回，可以再次领取
+	CouponStateVoided   = "voided"   // 已作废，如供应商取消
+)
+
+// couponTransitions 允许的状态变更，已使用和已作废为终态
+var couponTransitions = map[string][]string{
+	CouponStateIssued:   {CouponStateTaken, CouponStateVoided},
+	CouponStateTaken:    {CouponStateUsed, CouponStateReturned, CouponStateVoided},
+	CouponStateReturned: {CouponStateTaken, CouponStateVoided},
+}
+
+// 库存中可以领取的状态
+var couponStockStates = []string{CouponStateIssued, CouponStateReturned}
+
+// IsValidCouponState 是否为已定义的卡券状态
+func IsValidCouponState(state string) bool {
+	switch state {
+	case CouponStateIssued, CouponStateTaken, CouponStateUsed, CouponStateReturned, CouponStateVoided:
+		return true
+	}
+	return false
+}
+
+// CanTransitionCoupon 是否允许从 from 变更为 to
+func CanTransitionCoupon(from, to string) bool {
+	return slices.Contains(couponTransitions[from], to)
+}
+
+// CouponEvent 卡券状态变更记录
+type CouponEvent struct {
+	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	CouponId  int64  `gorm:"column:coupon_id;index;not null"`
+	FromState string `gorm:"column:from_state;type:varchar(16)"` // 入库时为空
+	ToState   string `gorm:"column:to_state;type:varchar(16);not null"`
+	Operator  int64  `gorm:"column:operator;default:0"` // 操作人，系统任务为 0
+	Note      string `gorm:"column:note;type:varchar(255)"`
+	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (CouponEvent) TableName() string {
+	return "coupon_events"
+}
+
+// migrateCouponStates 升级前只通过 taker 区分是否领取，已领取的卡券标记为 taken
+func migrateCouponStates() error {
+	return db.Model(&Coupon{}).
+		Where("taker > 0 AND state = ?", CouponStateIssued).
+		UpdateColumn("state", CouponStateTaken).Error
+}
+
+// migrateCouponTimes 回填领取和使用时间：优先使用状态变更记录，
+// 没有记录的历史数据使用 updated_at（升级前领取后不再修改）
+func migrateCouponTimes() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Coupon{}).Where("taker > 0 AND taken_at = 0").
+			UpdateColumn("taken_at", gorm.Expr(
+				"COALESCE((SELECT MAX(e.created_at) FROM coupon_events e WHERE e.coupon_id = coupons.id AND e.to_state = ?), updated_at)",
+				CouponStateTaken)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Coupon{}).Where("state = ? AND used_at = 0", CouponStateUsed).
+			UpdateColumn("used_at", gorm.Expr(
+				"COALESCE((SELECT MAX(e.created_at) FROM coupon_events e WHERE e.coupon_id = coupons.id AND e.to_state = ?), updated_at)",
+				CouponStateUsed)).Error
+	})
+}
+
+// GetCouponEvents 查询卡券的状态变更记录，按时间升序
+func GetCouponEvents(ctx context.Context, couponId int64) ([]*CouponEvent, error) {
+	var events []*CouponEvent
+	if err := getDb(ctx).Where("coupon_id = ?", couponId).Order("id ASC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// couponTransition 卡券状态变更
+type couponTransition struct {
+	to       string
+	operator int64
+	note     string
+	check    func(tx *gorm.DB, c *Coupon) error // 进一步校验当前卡券
+	fields   map[string]interface{}             // 需要同时更新的其他字段
+	after    func(tx *gorm.DB, c *Coupon) error // 变更后在同一事务中执行
+}
+
+// transitionCoupon 在事务中校验并变更卡券状态，同时写入变更记录
+func transitionCoupon(ctx context.Context, id int64, t couponTransition) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var c Coupon
+		if err := tx.Where("id = ?", id).First(&c).Error; err != nil {
+			return err
+		}
+		if !CanTransitionCoupon(c.State, t.to) {
+			return ErrCouponStateInvalid
+		}
+		if t.check != nil {
+			if err := t.check(tx, &c); err != nil {
+				return err
+			}
+		}
+		fields := make(map[string]interface{}, len(t.fields)+1)
+		for k, v := range t.fields {
+			fields[k] = v
+		}
+		fields["state"] = t.to
+		// 以读取到的状态为条件，并发变更时只有一个成功
+		result := tx.Model(&Coupon{}).Where("id = ? AND state = ?", id, c.State).Updates(fields)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCouponStateChanged
+		}
+		err := tx.Create(&CouponEvent{
+			CouponId:  id,
+			FromState: c.State,
+			ToState:   t.to,
+			Operator:  t.operator,
+			Note:      t.note,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if t.after != nil {
+			return t.after(tx, &c)
+		}
+		return nil
+	})
+}
+
+// UseCoupon 领取者标记卡券已使用
+func UseCoupon(ctx context.Context, id int64, userId int64) error {
+	return transitionCoupon(ctx, id, couponTransition{
+		to:       CouponStateUsed,
+		operator: userId,
+		check:    holderCheck(userId),
+		fields:   map[string]interface{}{"used_at": time.Now().UnixMilli()},
+	})
+}
+
+// ReturnCoupon 领取者退回未使用的卡券，退回后可以再次被领取，同时释放领取配额
+func ReturnCoupon(ctx context.Context, id int64, userId int64) error {
+	return transitionCoupon(ctx, id, couponTransition{
+		to:       CouponStateReturned,
+		operator: userId,
+		check:    holderCheck(userId),
+		fields:   map[string]interface{}{"taker": 0, "taken_at": 0},
+		after: func(tx *gorm.DB, c *Coupon) error {
+			return tx.Where("coupon_id = ? AND user_id = ?", c.Id, userId).Delete(&CouponClaim{}).Error
+		},
+	})
+}
+
+// VoidCoupon 作废卡券，已领取的卡券保留领取者，未完成的转让一并取消
+func VoidCoupon(ctx context.Context, id int64, operator int64, note string) error {
+	return transitionCoupon(ctx, id, couponTransition{
+		to:       CouponStateVoided,
+		operator: operator,
+		note:     note,
+		after: func(tx *gorm.DB, c *Coupon) error {
+			return tx.Model(&CouponTransfer{}).Where("coupon_id = ? AND status IN ?", c.Id, transferActiveStatuses).
+				Updates(map[string]interface{}{
+					"status":      TransferStatusCancelled,
+					"review_note": "卡券已作废",
+					"finished_at": time.Now().UnixMilli(),
+				}).Error
+		},
+	})
+}
+
+// holderCheck 只有领取者可以操作，正在转让的卡券需要先撤回转让
+func holderCheck(userId int64) func(tx *gorm.DB, c *Coupon) error {
+	return func(tx *gorm.DB, c *Coupon) error {
+		if c.Taker != userId {
+			return ErrCouponNotHolder
+		}
+		var pending int64
+		err := tx.Model(&CouponTransfer{}).Where("coupon_id = ? AND status IN ?", c.Id, transferActiveStatuses).
+			Count(&pending).Error
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return ErrCouponTransferPending
+		}
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"gorm.io/gorm/clause"
+)
+
+// CouponGrant 按卡券类型授予的权限，没有对应全局权限的用户只能操作被授权的类型
+type CouponGrant struct {
+	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId     int64  `gorm:"column:user_id;not null;uniqueIndex:idx_coupon_grant"`
+	CouponType int    `gorm:"column:coupon_type;not null;uniqueIndex:idx_coupon_grant"`
+	Permission string `gorm:"column:permission;type:varchar(64);not null;uniqueIndex:idx_coupon_grant"`
+	CreatedBy  int64  `gorm:"column:created_by;default:0"`
+	CreatedAt  int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (CouponGrant) TableName() string {
+	return "coupon_grants"
+}
+
+// 可以按卡券类型授予的权限
+var couponScopedPermissions = []string{
+	PermCouponView, PermCouponCreate, PermCouponImport, PermCouponUpdate, PermCouponDelete, PermCouponVoid,
+	PermCouponDistribute, PermCouponTransferReview, PermCouponTake,
+}
+
+// IsCouponScopedPermission 是否为可以按卡券类型授予的权限
+func IsCouponScopedPermission(perm string) bool {
+	return slices.Contains(couponScopedPermissions, perm)
+}
+
+// CouponGrants 用户按卡券类型拥有的权限，权限 -> 卡券类型
+type CouponGrants map[string][]int
+
+// Has 是否拥有指定卡券类型的权限
+func (g CouponGrants) Has(perm string, couponType int) bool {
+	return slices.Contains(g[perm], couponType)
+}
+
+// Filter 只保留权限集合中包含的权限，用于限制 API token 的范围
+func (g CouponGrants) Filter(perms PermissionSet) CouponGrants {
+	out := CouponGrants{}
+	for p, types := range g {
+		if perms.Has(p) {
+			out[p] = types
+		}
+	}
+	return out
+}
+
+// GetUserCouponGrants 查询用户按卡券类型拥有的权限
+func GetUserCouponGrants(ctx context.Context, userId int64) (CouponGrants, error) {
+	var list []*CouponGrant
+	if err := getDb(ctx).Where("user_id = ?", userId).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	grants := CouponGrants{}
+	for _, g := range list {
+		grants[g.Permission] = append(grants[g.Permission], g.CouponType)
+	}
+	return grants, nil
+}
+
+// GetCouponGrantList 查询授权列表，userId 为 0 时查询全部
+func GetCouponGrantList(ctx context.Context, userId int64) ([]*CouponGrant, error) {
+	var list []*CouponGrant
+	query := getDb(ctx).Order("user_id, coupon_type, permission")
+	if userId > 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err := query.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetCouponGrantById 根据 ID 查询授权
+func GetCouponGrantById(ctx context.Context, id int64) (*CouponGrant, error) {
+	var grant CouponGrant
+	if err := getDb(ctx).Where("id = ?", id).First(&grant).Error; err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// AddCouponGrants 为用户授予指定卡券类型的权限，已有的授权保持不变
+func AddCouponGrants(ctx context.Context, userId int64, couponType int, perms []string, createdBy int64) error {
+	if len(perms) == 0 {
+		return nil
+	}
+	list := make([]CouponGrant, 0, len(perms))
+	for _, p := range perms {
+		list = append(list, CouponGrant{UserId: userId, CouponType: couponType, Permission: p, CreatedBy: createdBy})
+	}
+	err := getDb(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&list).Error
+	if err == nil {
+		InvalidateUserCache(userId)
+	}
+	return err
+}
+
+// DeleteCouponGrant 删除授权
+func DeleteCouponGrant(ctx context.Context, grant *CouponGrant) error {
+	err := getDb(ctx).Delete(&CouponGrant{}, grant.Id).Error
+	if err == nil {
+		InvalidateUserCache(grant.UserId)
+	}
+	return err
+}
@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"pionex-administrative-sys/utils/quota"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 转让状态
+const (
+	TransferStatusPending   = "pending"   // 等待接收人确认
+	TransferStatusApproving = "approving" // 接收人已确认，等待审批
+	TransferStatusCompleted = "completed" // 已转让给接收人
+	TransferStatusDeclined  = "declined"  // 接收人拒绝
+	TransferStatusCancelled = "cancelled" // 转让人撤回或卡券已作废
+	TransferStatusRejected  = "rejected"  // 审批未通过
+)
+
+// 未完成的转让，同一张卡券同时只能有一个
+var transferActiveStatuses = []string{TransferStatusPending, TransferStatusApproving}
+
+// IsValidTransferStatus 是否为已定义的转让状态
+func IsValidTransferStatus(status string) bool {
+	switch status {
+	case TransferStatusPending, TransferStatusApproving, TransferStatusCompleted,
+		TransferStatusDeclined, TransferStatusCancelled, TransferStatusRejected:
+		return true
+	}
+	return false
+}
+
+// CouponTransfer 领取者把卡券转让给其他用户，接收人确认后生效，按类型规则可能还需要审批
+type CouponTransfer struct {
+	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	CouponId   int64  `gorm:"column:coupon_id;index;not null"`
+	CouponType int    `gorm:"column:coupon_type;index;not null"`
+	FromUser   int64  `gorm:"column:from_user;index;not null"`
+	ToUser     int64  `gorm:"column:to_user;index;not null"`
+	Status     string `gorm:"column:status;type:varchar(16);index;not null"`
+	Note       string `gorm:"column:note;type:varchar(255)"` // 转让人的留言
+	Reviewer   int64  `gorm:"column:reviewer;default:0"`
+	ReviewNote string `gorm:"column:review_note;type:varchar(255)"`
+	CreatedAt  int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt  int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+	FinishedAt int64  `gorm:"column:finished_at;default:0"`
+}
+
+func (CouponTransfer) TableName() string {
+	return "coupon_transfers"
+}
+
+// IsActive 是否未完成
+func (t CouponTransfer) IsActive() bool {
+	return slices.Contains(transferActiveStatuses, t.Status)
+}
+
+// TransferFilter 转让记录筛选条件
+type TransferFilter struct {
+	FromUser int64    // 转让人，为 0 时不限
+	ToUser   int64    // 接收人，为 0 时不限
+	Types    []int    // 卡券类型，为 nil 时不限
+	Statuses []string // 为 nil 时不限
+}
+
+func (f TransferFilter) applyFilter(db *gorm.DB) *gorm.DB {
+	if f.FromUser > 0 {
+		db = db.Where("from_user = ?", f.FromUser)
+	}
+	if f.ToUser > 0 {
+		db = db.Where("to_user = ?", f.ToUser)
+	}
+	if f.Types != nil {
+		db = db.Where("coupon_type IN ?", f.Types)
+	}
+	if f.Statuses != nil {
+		db = db.Where("status IN ?", f.Statuses)
+	}
+	return db
+}
+
+// GetCouponTransfers 根据筛选条件查询转让记录，按时间倒序
+func GetCouponTransfers(ctx context.Context, filter TransferFilter, offset, limit int) ([]*CouponTransfer, int64, error) {
+	query := filter.applyFilter(getDb(ctx).Model(&CouponTransfer{}))
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []*CouponTransfer
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// GetCouponTransferById 根据 ID 查询转让记录
+func GetCouponTransferById(ctx context.Context, id int64) (*CouponTransfer, error) {
+	var t CouponTransfer
+	if err := getDb(ctx).Where("id = ?", id).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetActiveTransferIds 查询卡券未完成的转让，卡券 ID -> 转让 ID
+func GetActiveTransferIds(ctx context.Context, couponIds []int64) (map[int64]int64, error) {
+	m := make(map[int64]int64)
+	if len(couponIds) == 0 {
+		return m, nil
+	}
+	var list []*CouponTransfer
+	err := getDb(ctx).Select("id", "coupon_id").
+		Where("coupon_id IN ? AND status IN ?", couponIds, transferActiveStatuses).Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		m[t.CouponId] = t.Id
+	}
+	return m, nil
+}
+
+// CreateCouponTransfer 领取者发起转让，卡券类型需要允许转让，且卡券没有未完成的转让
+func CreateCouponTransfer(ctx context.Context, t *CouponTransfer) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var c Coupon
+		if err := tx.Where("id = ?", t.CouponId).First(&c).Error; err != nil {
+			return err
+		}
+		if c.Taker != t.FromUser {
+			return ErrCouponNotHolder
+		}
+		if c.State != CouponStateTaken {
+			return ErrCouponStateInvalid
+		}
+		if ct, ok := GetCouponTypeById(c.Type); !ok || !ct.Transferable {
+			return ErrCouponNotTransferable
+		}
+		var pending int64
+		err := tx.Model(&CouponTransfer{}).Where("coupon_id = ? AND status IN ?", c.Id, transferActiveStatuses).
+			Count(&pending).Error
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return ErrCouponTransferPending
+		}
+		t.CouponType = c.Type
+		t.Status = TransferStatusPending
+		return tx.Create(t).Error
+	})
+}
+
+// AcceptCouponTransfer 接收人确认转让：类型需要审批时等待审批，否则立即转让。
+// 计入配额的类型在接收人配额不足时返回 ErrQuotaExceeded 和配额结果
+func AcceptCouponTransfer(ctx context.Context, id int64, userId int64) (quota.Result, error) {
+	var q quota.Result
+	err := withTransferRetry(ctx, func() error {
+		return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+			t, err := transferInStatus(tx, id, TransferStatusPending)
+			if err != nil {
+				return err
+			}
+			if t.ToUser != userId {
+				return ErrTransferStateInvalid
+			}
+			if ct, _ := GetCouponTypeById(t.CouponType); ct.TransferApproval {
+				return updateTransfer(tx, t, map[string]interface{}{"status": TransferStatusApproving})
+			}
+			q, err = completeTransfer(tx, t, userId, nil)
+			return err
+		})
+	})
+	return q, err
+}
+
+// ApproveCouponTransfer 审批通过，立即转让给接收人
+func ApproveCouponTransfer(ctx context.Context, id int64, reviewer int64, note string) (quota.Result, error) {
+	var q quota.Result
+	err := withTransferRetry(ctx, func() error {
+		return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+			t, err := transferInStatus(tx, id, TransferStatusApproving)
+			if err != nil {
+				return err
+			}
+			q, err = completeTransfer(tx, t, reviewer, map[string]interface{}{"reviewer": reviewer, "review_note": note})
+			return err
+		})
+	})
+	return q, err
+}
+
+// DeclineCouponTransfer 接收人拒绝转让
+func DeclineCouponTransfer(ctx context.Context, id int64, userId int64) error {
+	return finishTransfer(ctx, id, TransferStatusDeclined, func(t *CouponTransfer) bool {
+		return t.ToUser == userId
+	}, nil)
+}
+
+// CancelCouponTransfer 转让人撤回未完成的转让
+func CancelCouponTransfer(ctx context.Context, id int64, userId int64) error {
+	return finishTransfer(ctx, id, TransferStatusCancelled, func(t *CouponTransfer) bool {
+		return t.FromUser == userId
+	}, nil)
+}
+
+// RejectCouponTransfer 审批不通过，卡券保留在转让人手中
+func RejectCouponTransfer(ctx context.Context, id int64, reviewer int64, note string) error {
+	return finishTransfer(ctx, id, TransferStatusRejected, func(t *CouponTransfer) bool {
+		return t.Status == TransferStatusApproving
+	}, map[string]interface{}{"reviewer": reviewer, "review_note": note})
+}
+
+// finishTransfer 结束未完成的转让，allow 校验操作人和当前状态
+func finishTransfer(ctx context.Context, id int64, status string, allow func(t *CouponTransfer) bool,
+	fields map[string]interface{}) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		t, err := transferInStatus(tx, id, transferActiveStatuses...)
+		if err != nil {
+			return err
+		}
+		if !allow(t) {
+			return ErrTransferStateInvalid
+		}
+		if fields == nil {
+			fields = make(map[string]interface{})
+		}
+		fields["status"] = status
+		fields["finished_at"] = time.Now().UnixMilli()
+		return updateTransfer(tx, t, fields)
+	})
+}
+
+// transferInStatus 查询转让记录，状态不在 statuses 中时返回 ErrTransferStateInvalid
+func transferInStatus(tx *gorm.DB, id int64, statuses ...string) (*CouponTransfer, error) {
+	var t CouponTransfer
+	if err := tx.Where("id = ?", id).First(&t).Error; err != nil {
+		return nil, err
+	}
+	if !slices.Contains(statuses, t.Status) {
+		return nil, ErrTransferStateInvalid
+	}
+	return &t, nil
+}
+
+// updateTransfer 以读取到的状态为条件更新转让记录
+func updateTransfer(tx *gorm.DB, t *CouponTransfer, fields map[string]interface{}) error {
+	result := tx.Model(&CouponTransfer{}).Where("id = ? AND status = ?", t.Id, t.Status).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTransferStateInvalid
+	}
+	return nil
+}
+
+// completeTransfer 把卡券转给接收人并写入变更记录，转让人已使用、退回或卡券已作废时返回 ErrCouponStateChanged
+func completeTransfer(tx *gorm.DB, t *CouponTransfer, operator int64, fields map[string]interface{}) (quota.Result, error) {
+	var c Coupon
+	if err := tx.Where("id = ?", t.CouponId).First(&c).Error; err != nil {
+		return quota.Result{}, err
+	}
+	if c.State != CouponStateTaken || c.Taker != t.FromUser {
+		return quota.Result{}, ErrCouponStateChanged
+	}
+
+	now := time.Now()
+	if ct, _ := GetCouponTypeById(c.Type); ct.TransferCountsQuota {
+		q, err := userQuota(tx, t.ToUser, c.Type, now)
+		if err != nil {
+			return q, err
+		}
+		if !q.Unlimited && q.Remaining <= 0 {
+			return q, ErrQuotaExceeded
+		}
+		err = tx.Create(&CouponClaim{UserId: t.ToUser, CouponType: c.Type, ClaimedAt: now.UnixMilli(), CouponId: c.Id}).Error
+		if err != nil {
+			return q, err
+		}
+	}
+
+	result := tx.Model(&Coupon{}).Where("id = ? AND state = ? AND taker = ?", c.Id, CouponStateTaken, t.FromUser).
+		Updates(map[string]interface{}{"taker": t.ToUser, "taken_at": now.UnixMilli()})
+	if result.Error != nil {
+		return quota.Result{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return quota.Result{}, ErrCouponStateChanged
+	}
+
+	names := make(map[int64]string, 2)
+	var users []*User
+	if err := tx.Select("id", "name").Where("id IN ?", []int64{t.FromUser, t.ToUser}).Find(&users).Error; err != nil {
+		return quota.Result{}, err
+	}
+	for _, u := range users {
+		names[u.Id] = u.Name
+	}
+	err := tx.Create(&CouponEvent{
+		CouponId:  c.Id,
+		FromState: CouponStateTaken,
+		ToState:   CouponStateTaken,
+		Operator:  operator,
+		Note:      fmt.Sprintf("转让 #%d：%s → %s", t.Id, names[t.FromUser], names[t.ToUser]),
+	}).Error
+	if err != nil {
+		return quota.Result{}, err
+	}
+
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["status"] = TransferStatusCompleted
+	fields["finished_at"] = now.UnixMilli()
+	return quota.Result{}, updateTransfer(tx, t, fields)
+}
@@ -1,43 +1,219 @@
 package db
 
-// CouponType 卡券类型
+import (
+	"context"
+	"pionex-administrative-sys/utils/logger"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CouponType 卡券类型，保存在数据库中，读取时使用内存缓存
 type CouponType struct {
-	Type int    `json:"type"`
-	Name string `json:"name"`
+	Type        int    `gorm:"column:type;primaryKey;autoIncrement" json:"type"`
+	Name        string `gorm:"column:name;type:varchar(64);not null" json:"name"`
+	Description string `gorm:"column:description;type:varchar(255)" json:"description"`
+	Icon        string `gorm:"column:icon;type:varchar(255)" json:"icon"`     // 图标地址或 emoji
+	Enabled     bool   `gorm:"column:enabled;default:true" json:"enabled"`    // 停用后不能添加、导入和申领
+	SortOrder   int    `gorm:"column:sort_order;default:0" json:"sort_order"` // 显示顺序，越小越靠前
+	// 转让规则：是否允许领取者转让、转让是否需要审批、接收的卡券是否计入接收人的领取配额
+	Transferable        bool  `gorm:"column:transferable;default:false" json:"transferable"`
+	TransferApproval    bool  `gorm:"column:transfer_approval;default:false" json:"transfer_approval"`
+	TransferCountsQuota bool  `gorm:"column:transfer_counts_quota;default:false" json:"transfer_counts_quota"`
+	CreatedAt           int64 `gorm:"column:created_at;autoCreateTime:milli" json:"created_at"`
+	UpdatedAt           int64 `gorm:"column:updated_at;autoUpdateTime:milli" json:"updated_at"`
 }
 
-// 卡券类型定义
-const (
-	couponTypeFitness = iota + 1
-)
+func (CouponType) TableName() string {
+	return "coupon_types"
+}
+
+// 健身卡是最早的卡券类型，保持类型为 1 兼容历史数据
+const couponTypeFitness = 1
 
 var (
-	CouponTypeFitness = CouponType{Type: couponTypeFitness, Name: "健身卡"}
+	couponTypesMu    sync.RWMutex
+	couponTypesCache []CouponType // 按显示顺序排列的所有类型
 )
 
-// AllCouponTypes 获取所有卡券类型
+// seedCouponTypes 没有任何类型时写入健身卡，并加载缓存
+func seedCouponTypes() error {
+	var count int64
+	if err := db.Model(&CouponType{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := db.Create(&CouponType{Type: couponTypeFitness, Name: "健身卡", Enabled: true}).Error; err != nil {
+			return err
+		}
+	}
+	return reloadCouponTypes(db)
+}
+
+// reloadCouponTypes 重新加载类型缓存，需在事务提交后调用，避免回滚时缓存读到未提交的数据。
+// 查询和替换都持有 couponTypesMu，并发修改后的多次加载按顺序执行，后加载的结果不会被先前的覆盖
+func reloadCouponTypes(tx *gorm.DB) error {
+	couponTypesMu.Lock()
+	defer couponTypesMu.Unlock()
+	var list []CouponType
+	if err := tx.Order("sort_order, type").Find(&list).Error; err != nil {
+		return err
+	}
+	couponTypesCache = list
+	return nil
+}
+
+// 修改提交后刷新类型缓存的最大尝试次数
+const couponTypesReloadAttempts = 3
+
+// refreshCouponTypes 修改提交后刷新类型缓存。修改已经生效，刷新失败时重试并记录日志，不返回错误；
+// 请求取消也不影响刷新
+func refreshCouponTypes(ctx context.Context) {
+	tx := getDb(context.WithoutCancel(ctx))
+	for attempt := 1; ; attempt++ {
+		err := reloadCouponTypes(tx)
+		if err == nil {
+			return
+		}
+		if attempt >= couponTypesReloadAttempts {
+			logger.Error("reload coupon types failed, cache is stale", zap.Int("attempts", attempt), zap.Error(err))
+			return
+		}
+		logger.Warn("reload coupon types failed, retrying", zap.Int("attempt", attempt), zap.Error(err))
+		time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+	}
+}
+
+// GetCouponTypes 获取所有卡券类型，包含已停用的
+func GetCouponTypes() []CouponType {
+	couponTypesMu.RLock()
+	defer couponTypesMu.RUnlock()
+	return append([]CouponType(nil), couponTypesCache...)
+}
+
+// AllCouponTypes 获取所有启用的卡券类型
 func AllCouponTypes() []CouponType {
-	return []CouponType{
-		CouponTypeFitness,
+	couponTypesMu.RLock()
+	defer couponTypesMu.RUnlock()
+	list := make([]CouponType, 0, len(couponTypesCache))
+	for _, ct := range couponTypesCache {
+		if ct.Enabled {
+			list = append(list, ct)
+		}
 	}
+	return list
 }
 
-// GetCouponTypeName 根据类型获取名称
-func GetCouponTypeName(t int) string {
-	for _, ct := range AllCouponTypes() {
+// GetCouponTypeById 根据类型从缓存中查询，包含已停用的
+func GetCouponTypeById(t int) (CouponType, bool) {
+	couponTypesMu.RLock()
+	defer couponTypesMu.RUnlock()
+	for _, ct := range couponTypesCache {
 		if ct.Type == t {
-			return ct.Name
+			return ct, true
 		}
 	}
+	return CouponType{}, false
+}
+
+// GetCouponTypeName 根据类型获取名称，已停用的类型同样返回名称
+func GetCouponTypeName(t int) string {
+	if ct, ok := GetCouponTypeById(t); ok {
+		return ct.Name
+	}
 	return "未知类型"
 }
 
-// IsValidCouponType 检查是否为有效的卡券类型
+// IsValidCouponType 检查是否为启用的卡券类型
 func IsValidCouponType(t int) bool {
-	for _, ct := range AllCouponTypes() {
-		if ct.Type == t {
-			return true
+	ct, ok := GetCouponTypeById(t)
+	return ok && ct.Enabled
+}
+
+// CreateCouponType 创建卡券类型
+func CreateCouponType(ctx context.Context, ct *CouponType) error {
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkCouponTypeName(tx, ct.Name, 0); err != nil {
+			return err
+		}
+		// enabled 有默认值，创建时零值会被忽略并回填为默认值
+		enabled := ct.Enabled
+		if err := tx.Create(ct).Error; err != nil {
+			return err
+		}
+		if !enabled {
+			if err := tx.Model(ct).Update("enabled", false).Error; err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	return false
-}
\ No newline at end of file
+	refreshCouponTypes(ctx)
+	return nil
+}
+
+// UpdateCouponTypeFields 更新卡券类型指定字段
+func UpdateCouponTypeFields(ctx context.Context, t int, fields map[string]interface{}) error {
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if name, ok := fields["name"].(string); ok {
+			if err := checkCouponTypeName(tx, name, t); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&CouponType{}).Where("type = ?", t).Updates(fields).Error; err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	refreshCouponTypes(ctx)
+	return nil
+}
+
+// DeleteCouponType 删除卡券类型，已有卡券或授权的类型不能删除，只能停用
+func DeleteCouponType(ctx context.Context, t int) error {
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var coupons, grants int64
+		if err := tx.Model(&Coupon{}).Where("type = ?", t).Count(&coupons).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&CouponGrant{}).Where("coupon_type = ?", t).Count(&grants).Error; err != nil {
+			return err
+		}
+		if coupons > 0 || grants > 0 {
+			return ErrCouponTypeInUse
+		}
+		if err := tx.Where("coupon_type = ?", t).Delete(&QuotaPolicy{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("type = ?", t).Delete(&CouponType{}).Error; err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	refreshCouponTypes(ctx)
+	return nil
+}
+
+// checkCouponTypeName 类型名不能重复
+func checkCouponTypeName(tx *gorm.DB, name string, excludeType int) error {
+	var count int64
+	err := tx.Model(&CouponType{}).Where("name = ? AND type != ?", name, excludeType).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCouponTypeNameExists
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package db
 
 import (
 	"context"
+	"errors"
 	"pionex-administrative-sys/utils"
 	"pionex-administrative-sys/utils/app"
+	"pionex-administrative-sys/utils/consts"
 	"pionex-administrative-sys/utils/logger"
 	"time"
 
@@ -14,21 +16,45 @@ import (
 
 var db *gorm.DB
 
-// Init 初始化 SQLite 数据库连接
-func init() {
+// Init 初始化 SQLite 数据库连接，需在使用其他函数前调用
+func Init() error {
+	return open(app.DBPath("data.db"))
+}
+
+// open 打开数据库，依次建表、升级历史数据并写入初始数据
+func open(path string) error {
 	var err error
-	db, err = gorm.Open(sqlite.Open(app.DBPath("data.db")), &gorm.Config{
+	db, err = gorm.Open(sqlite.Open(dsn(path)), &gorm.Config{
 		Logger: logger.NewGormLogger(),
 	})
 	if err != nil {
-		logger.Fatal(err.Error())
+		return err
 	}
-	if err = autoMigrate(); err != nil {
-		logger.Fatal(err.Error())
+	steps := []func() error{
+		autoMigrate,
+		migrateLegacyPwd,
+		migrateCouponStates,
+		migrateCouponTimes,
+		migrateCouponClaims,
+		createAuditTriggers,
+		seedRoles,
+		migrateLegacyRoles,
+		seedCouponTypes,
+		seedQuotaPolicies,
+		initializeData,
 	}
-	if err = initializeData(); err != nil {
-		logger.Fatal(err.Error())
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// dsn 事务开始时即获取写锁（BEGIN IMMEDIATE），事务内先读后写的校验
+// 在多个进程同时写入时也不会被其他事务插入，等待写锁时按 busy_timeout 排队
+func dsn(path string) string {
+	return path + "?_txlock=immediate"
 }
 
 // AutoMigrate 自动建表
@@ -36,19 +62,81 @@ func autoMigrate() error {
 	return db.AutoMigrate(
 		&User{},
 		&Coupon{},
+		&CouponEvent{},
+		&CouponClaim{},
+		&CouponTransfer{},
+		&CouponDistribution{},
+		&CouponDistributionItem{},
+		&RefreshToken{},
+		&RevokedToken{},
+		&LoginFailure{},
+		&Setting{},
+		&RecoveryCode{},
+		&APIToken{},
+		&Registration{},
+		&InviteCode{},
+		&PasswordReset{},
+		&PasswordHistory{},
+		&AuditEvent{},
+		&Permission{},
+		&Role{},
+		&RolePermission{},
+		&UserRole{},
+		&CouponGrant{},
+		&Department{},
+		&CouponType{},
+		&QuotaPolicy{},
 	)
 }
 
-// initializeData 初始化基础数据
+// 初始化的默认管理员
+const (
+	defaultAdminAccount = "admin"
+	defaultAdminPwd     = "123456"
+)
+
+// initializeData 初始化基础数据，默认管理员拥有所有内置角色
 func initializeData() error {
-	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&User{
+	admin := &User{
 		Name:      "管理员",
-		Account:   "admin",
-		Md5Pwd:    utils.MD5("123456"),
-		Role:      MergeRole(AllRoles()...),
+		Account:   defaultAdminAccount,
 		CreatedAt: time.Now().UnixMilli(),
 		UpdatedAt: time.Now().UnixMilli(),
-	}).Error
+	}
+	// 首次初始化时可通过环境变量指定管理员密码，避免使用默认密码
+	pwd := utils.Env(consts.APP_ADMIN_PASSWORD_KEY, "")
+	if pwd == "" {
+		pwd = defaultAdminPwd
+	}
+	if err := admin.SetPwd(pwd); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(admin)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		var ids []int64
+		if err := tx.Model(&Role{}).Where("builtin = ?", true).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		return addUserRoles(tx, admin.Id, ids)
+	})
+}
+
+// DefaultAdminPwdInUse 默认管理员是否仍在使用初始密码
+func DefaultAdminPwdInUse(ctx context.Context) (*User, bool, error) {
+	admin, err := GetUserByAccount(ctx, defaultAdminAccount)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if _, err := admin.CheckPwd(defaultAdminPwd); err != nil {
+		return admin, false, nil
+	}
+	return admin, true, nil
 }
 
 // GetDB 获取数据库实例
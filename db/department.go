@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// Department 部门，通过 ParentId 组成树
+type Department struct {
+	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	Name      string `gorm:"column:name;type:varchar(64);not null"`
+	ParentId  int64  `gorm:"column:parent_id;index;default:0"` // 上级部门，为 0 表示顶级部门
+	HeadId    int64  `gorm:"column:head_id;index;default:0"`   // 部门负责人，可以查看本部门及下级部门的卡券使用情况
+	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+}
+
+func (Department) TableName() string {
+	return "departments"
+}
+
+// GetDepartments 查询所有部门
+func GetDepartments(ctx context.Context) ([]*Department, error) {
+	var list []*Department
+	if err := getDb(ctx).Order("parent_id, id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetDepartmentById 根据 ID 查询部门
+func GetDepartmentById(ctx context.Context, id int64) (*Department, error) {
+	var dept Department
+	if err := getDb(ctx).Where("id = ?", id).First(&dept).Error; err != nil {
+		return nil, err
+	}
+	return &dept, nil
+}
+
+// CreateDepartment 创建部门
+func CreateDepartment(ctx context.Context, dept *Department) error {
+	return getDb(ctx).Create(dept).Error
+}
+
+// UpdateDepartmentFields 更新部门指定字段，上级部门不能是自身或下级部门
+func UpdateDepartmentFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if parentId, ok := fields["parent_id"].(int64); ok && parentId != 0 {
+			subtree, err := departmentSubtreeIds(tx, []int64{id})
+			if err != nil {
+				return err
+			}
+			if slices.Contains(subtree, parentId) {
+				return ErrDepartmentCycle
+			}
+		}
+		return tx.Model(&Department{}).Where("id = ?", id).Updates(fields).Error
+	})
+}
+
+// DeleteDepartment 删除部门，存在下级部门或用户时不能删除
+func DeleteDepartment(ctx context.Context, id int64) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var children, users int64
+		if err := tx.Model(&Department{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("department_id = ?", id).Count(&users).Error; err != nil {
+			return err
+		}
+		if children > 0 || users > 0 {
+			return ErrDepartmentNotEmpty
+		}
+		if err := tx.Where("scope = ? AND scope_id = ?", QuotaScopeDepartment, id).Delete(&QuotaPolicy{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&Department{}).Error
+	})
+}
+
+// CountDepartmentUsers 统计各部门的直属用户数
+func CountDepartmentUsers(ctx context.Context) (map[int64]int64, error) {
+	var rows []struct {
+		DepartmentId int64
+		Count        int64
+	}
+	err := getDb(ctx).Model(&User{}).Select("department_id, COUNT(*) AS count").
+		Where("department_id != 0").Group("department_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int64]int64, len(rows))
+	for _, r := range rows {
+		counts[r.DepartmentId] = r.Count
+	}
+	return counts, nil
+}
+
+// GetDepartmentSubtreeIds 查询部门及其所有下级部门
+func GetDepartmentSubtreeIds(ctx context.Context, rootIds ...int64) ([]int64, error) {
+	return departmentSubtreeIds(getDb(ctx), rootIds)
+}
+
+// GetHeadedDepartmentIds 查询用户担任负责人的部门
+func GetHeadedDepartmentIds(ctx context.Context, userId int64) ([]int64, error) {
+	var ids []int64
+	err := getDb(ctx).Model(&Department{}).Where("head_id = ?", userId).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// GetDepartmentUserIds 查询部门中的用户，不包含下级部门
+func GetDepartmentUserIds(ctx context.Context, deptIds []int64) ([]int64, error) {
+	var ids []int64
+	if len(deptIds) == 0 {
+		return ids, nil
+	}
+	err := getDb(ctx).Model(&User{}).Where("department_id IN ?", deptIds).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// departmentSubtreeIds 部门数量不多，一次查出父子关系后逐层展开
+func departmentSubtreeIds(tx *gorm.DB, rootIds []int64) ([]int64, error) {
+	var all []*Department
+	if err := tx.Select("id", "parent_id").Find(&all).Error; err != nil {
+		return nil, err
+	}
+	children := make(map[int64][]int64, len(all))
+	exists := make(map[int64]bool, len(all))
+	for _, d := range all {
+		children[d.ParentId] = append(children[d.ParentId], d.Id)
+		exists[d.Id] = true
+	}
+
+	seen := make(map[int64]bool)
+	ids := make([]int64, 0, len(rootIds))
+	queue := make([]int64, 0, len(rootIds))
+	for _, id := range rootIds {
+		if exists[id] && !seen[id] {
+			seen[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		ids = append(ids, id)
+		for _, child := range children[id] {
+			if !seen[child] {
+				seen[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return ids, nil
+}
@@ -3,5 +3,35 @@ package db
 import "errors"
 
 var (
-	ErrCouponAlreadyTaken = errors.New("coupon already taken")
-)
\ No newline at end of file
+	ErrCouponStateInvalid = errors.New("invalid coupon state transition")
+	ErrCouponStateChanged = errors.New("coupon state changed concurrently")
+	ErrCouponNotHolder    = errors.New("coupon not held by user")
+	ErrCouponOutOfStock   = errors.New("coupon out of stock")
+	ErrQuotaExceeded      = errors.New("coupon quota exceeded")
+	ErrCouponHasHistory   = errors.New("coupon has state changes")
+
+	ErrCouponNotTransferable = errors.New("coupon type not transferable")
+	ErrCouponTransferPending = errors.New("coupon has a pending transfer")
+	ErrTransferStateInvalid  = errors.New("invalid transfer status")
+
+	ErrCouponTypeNameExists = errors.New("coupon type name already exists")
+	ErrCouponTypeInUse      = errors.New("coupon type in use")
+
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+
+	ErrAPITokenInvalid = errors.New("invalid api token")
+	ErrAPITokenExpired = errors.New("api token expired")
+
+	ErrAccountExists        = errors.New("account already exists")
+	ErrRegistrationNotFound = errors.New("registration not found")
+	ErrInviteCodeInvalid    = errors.New("invalid invite code")
+
+	ErrPasswordResetInvalid = errors.New("invalid or expired password reset token")
+
+	ErrRoleNameExists = errors.New("role name already exists")
+
+	ErrDepartmentCycle    = errors.New("department parent cycle")
+	ErrDepartmentNotEmpty = errors.New("department has sub departments or users")
+)
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InviteCode 管理员生成的邀请码，注册时预设权限并跳过审核，只保存哈希
+type InviteCode struct {
+	Id         int64   `gorm:"column:id;primaryKey;autoIncrement"`
+	CodeHash   string  `gorm:"column:code_hash;type:varchar(64);uniqueIndex;not null"`
+	Prefix     string  `gorm:"column:prefix;type:varchar(16)"`  // 邀请码前几位，用于辨认
+	RoleIds    []int64 `gorm:"column:role_ids;serializer:json"` // 注册后的角色
+	LegacyRole int     `gorm:"column:role;default:0"`           // 已废弃的权限位，启动时迁移到 role_ids
+	MaxUses    int     `gorm:"column:max_uses;default:1"`       // 可使用次数
+	UsedCount  int     `gorm:"column:used_count;default:0"`
+	Note       string  `gorm:"column:note;type:varchar(255)"`
+	ExpiresAt  int64   `gorm:"column:expires_at;not null"`
+	CreatedBy  int64   `gorm:"column:created_by;default:0"`
+	RevokedAt  int64   `gorm:"column:revoked_at;default:0"`
+	CreatedAt  int64   `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (InviteCode) TableName() string {
+	return "invite_codes"
+}
+
+// IsActive 是否可用
+func (c InviteCode) IsActive() bool {
+	return c.RevokedAt == 0 && c.UsedCount < c.MaxUses && c.ExpiresAt > time.Now().UnixMilli()
+}
+
+// CreateInviteCode 创建邀请码
+func CreateInviteCode(ctx context.Context, code *InviteCode) error {
+	return getDb(ctx).Create(code).Error
+}
+
+// GetInviteCodes 查询邀请码列表
+func GetInviteCodes(ctx context.Context, offset, limit int) ([]*InviteCode, error) {
+	var list []*InviteCode
+	err := getDb(ctx).Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CountInviteCodes 统计邀请码总数
+func CountInviteCodes(ctx context.Context) (int64, error) {
+	var count int64
+	err := getDb(ctx).Model(&InviteCode{}).Count(&count).Error
+	return count, err
+}
+
+// RevokeInviteCode 作废邀请码
+func RevokeInviteCode(ctx context.Context, id int64) (bool, error) {
+	result := getDb(ctx).Model(&InviteCode{}).Where("id = ? AND revoked_at = 0", id).
+		Update("revoked_at", time.Now().UnixMilli())
+	return result.RowsAffected > 0, result.Error
+}
+
+// inviteWithinCreator 邀请码预设角色的权限是否都在创建人当前的权限内
+func inviteWithinCreator(tx *gorm.DB, code *InviteCode) (bool, error) {
+	want, err := rolesPermissions(tx, code.RoleIds)
+	if err != nil {
+		return false, err
+	}
+	if len(want) == 0 {
+		return true, nil
+	}
+	var creatorRoles []int64
+	if err := tx.Model(&UserRole{}).Where("user_id = ?", code.CreatedBy).Pluck("role_id", &creatorRoles).Error; err != nil {
+		return false, err
+	}
+	have, err := rolesPermissions(tx, creatorRoles)
+	if err != nil {
+		return false, err
+	}
+	return have.Contains(want), nil
+}
+
+// RegisterWithInvite 使用邀请码注册，占用一次使用次数并按邀请码的角色创建用户
+func RegisterWithInvite(ctx context.Context, codeHash string, user *User) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var code InviteCode
+		err := tx.Where("code_hash = ?", codeHash).First(&code).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteCodeInvalid
+			}
+			return err
+		}
+		if !code.IsActive() {
+			return ErrInviteCodeInvalid
+		}
+		// 创建人降权后，超出其当前权限的邀请码随之失效
+		if ok, err := inviteWithinCreator(tx, &code); err != nil {
+			return err
+		} else if !ok {
+			return ErrInviteCodeInvalid
+		}
+		// 条件更新防止并发注册超出次数
+		result := tx.Model(&InviteCode{}).Where("id = ? AND used_count < max_uses", code.Id).
+			UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInviteCodeInvalid
+		}
+
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return addUserRoles(tx, user.Id, code.RoleIds)
+	})
+}
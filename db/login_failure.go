@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	LoginFailureAccount = "account"
+	LoginFailureIp      = "ip"
+)
+
+// 登录失败策略：超过免费次数后按指数退避锁定，长时间无失败记录则清零
+var loginFailurePolicies = map[string]struct {
+	freeAttempts int
+	baseLock     time.Duration
+	maxLock      time.Duration
+}{
+	LoginFailureAccount: {freeAttempts: 5, baseLock: 30 * time.Second, maxLock: time.Hour},
+	LoginFailureIp:      {freeAttempts: 20, baseLock: 30 * time.Second, maxLock: time.Hour},
+}
+
+const loginFailureResetAfter = 24 * time.Hour
+
+// LoginFailure 登录失败记录，按账号和客户端 IP 分别统计
+type LoginFailure struct {
+	Id           int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	Kind         string `gorm:"column:kind;type:varchar(16);uniqueIndex:idx_login_failure_key;not null"`    // account/ip
+	Target       string `gorm:"column:target;type:varchar(128);uniqueIndex:idx_login_failure_key;not null"` // 账号或 IP
+	Failures     int    `gorm:"column:failures;default:0"`
+	LastFailedAt int64  `gorm:"column:last_failed_at"`
+	LockedUntil  int64  `gorm:"column:locked_until;index"`
+	CreatedAt    int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt    int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+}
+
+func (LoginFailure) TableName() string {
+	return "login_failures"
+}
+
+// IsLocked 是否处于锁定中
+func (f LoginFailure) IsLocked() bool {
+	return f.LockedUntil > time.Now().UnixMilli()
+}
+
+// GetLoginLockRemaining 获取账号或 IP 的剩余锁定时间，0 表示未锁定
+func GetLoginLockRemaining(ctx context.Context, kind, target string) (time.Duration, error) {
+	var f LoginFailure
+	err := getDb(ctx).Where("kind = ? AND target = ?", kind, target).First(&f).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !f.IsLocked() {
+		return 0, nil
+	}
+	return time.Until(time.UnixMilli(f.LockedUntil)), nil
+}
+
+// RecordLoginFailure 记录一次登录失败，返回更新后的记录
+func RecordLoginFailure(ctx context.Context, kind, target string) (*LoginFailure, error) {
+	policy := loginFailurePolicies[kind]
+	var f LoginFailure
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("kind = ? AND target = ?", kind, target).First(&f).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		now := time.Now()
+		if f.Id > 0 && now.Sub(time.UnixMilli(f.LastFailedAt)) > loginFailureResetAfter {
+			f.Failures = 0
+		}
+		f.Kind = kind
+		f.Target = target
+		f.Failures++
+		f.LastFailedAt = now.UnixMilli()
+		if over := f.Failures - policy.freeAttempts; over >= 0 {
+			lock := policy.maxLock
+			if over < 20 {
+				lock = min(policy.baseLock<<over, policy.maxLock)
+			}
+			f.LockedUntil = now.Add(lock).UnixMilli()
+		}
+		return tx.Save(&f).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ResetLoginFailure 清除登录失败记录，用于登录成功或管理员解锁
+func ResetLoginFailure(ctx context.Context, kind, target string) error {
+	return getDb(ctx).Where("kind = ? AND target = ?", kind, target).Delete(&LoginFailure{}).Error
+}
+
+// GetLockedLoginFailures 查询锁定中的记录
+func GetLockedLoginFailures(ctx context.Context, kind string, offset, limit int) ([]*LoginFailure, error) {
+	var list []*LoginFailure
+	query := getDb(ctx).Where("locked_until > ?", time.Now().UnixMilli())
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	err := query.Order("locked_until DESC").Offset(offset).Limit(limit).Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CountLockedLoginFailures 统计锁定中的记录数
+func CountLockedLoginFailures(ctx context.Context, kind string) (int64, error) {
+	var count int64
+	query := getDb(ctx).Model(&LoginFailure{}).Where("locked_until > ?", time.Now().UnixMilli())
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	err := query.Count(&count).Error
+	return count, err
+}
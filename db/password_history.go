@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"pionex-administrative-sys/utils"
+
+	"gorm.io/gorm"
+)
+
+// PasswordHistory 用户用过的密码哈希，用于禁止重复使用
+type PasswordHistory struct {
+	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId    int64  `gorm:"column:user_id;index;not null"`
+	PwdHash   string `gorm:"column:pwd_hash;type:varchar(255);not null"`
+	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_histories"
+}
+
+// savePasswordHistory 修改密码前记录当前密码，只保留最近 keep 条
+func savePasswordHistory(tx *gorm.DB, userId int64, keep int) error {
+	var user User
+	if err := tx.Select("pwd_hash").Where("id = ?", userId).First(&user).Error; err != nil {
+		return err
+	}
+	if user.PwdHash == "" {
+		return nil
+	}
+	if err := tx.Create(&PasswordHistory{UserId: userId, PwdHash: user.PwdHash}).Error; err != nil {
+		return err
+	}
+	kept := tx.Model(&PasswordHistory{}).Select("id").Where("user_id = ?", userId).
+		Order("id DESC").Limit(keep)
+	return tx.Where("user_id = ? AND id NOT IN (?)", userId, kept).Delete(&PasswordHistory{}).Error
+}
+
+// IsPasswordReused 密码是否与当前密码或最近 n-1 次历史密码相同
+func IsPasswordReused(ctx context.Context, userId int64, pwd string, n int) (bool, error) {
+	if n <= 0 {
+		return false, nil
+	}
+	user, err := GetUserById(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	hashes := []string{user.PwdHash}
+	if n > 1 {
+		var history []*PasswordHistory
+		err := getDb(ctx).Where("user_id = ?", userId).Order("id DESC").Limit(n - 1).Find(&history).Error
+		if err != nil {
+			return false, err
+		}
+		for _, h := range history {
+			hashes = append(hashes, h.PwdHash)
+		}
+	}
+	for _, h := range hashes {
+		if h == "" {
+			continue
+		}
+		if _, err := utils.VerifyPassword(h, pwd); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordReset 找回密码的一次性 token，只保存哈希
+type PasswordReset struct {
+	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId    int64  `gorm:"column:user_id;index;not null"`
+	TokenHash string `gorm:"column:token_hash;type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt int64  `gorm:"column:expires_at;not null"`
+	UsedAt    int64  `gorm:"column:used_at;default:0"`
+	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (PasswordReset) TableName() string {
+	return "password_resets"
+}
+
+// CreatePasswordReset 创建找回密码 token，同时作废该用户之前未使用的 token
+func CreatePasswordReset(ctx context.Context, reset *PasswordReset) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := invalidatePasswordResets(tx, reset.UserId); err != nil {
+			return err
+		}
+		return tx.Create(reset).Error
+	})
+}
+
+// GetLastPasswordResetAt 用户最近一次申请找回密码的时间，用于限制发送频率
+func GetLastPasswordResetAt(ctx context.Context, userId int64) (int64, error) {
+	var reset PasswordReset
+	err := getDb(ctx).Where("user_id = ?", userId).Order("id DESC").First(&reset).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return reset.CreatedAt, nil
+}
+
+// GetPasswordResetUser 查询未使用且未过期的 token 对应的用户
+func GetPasswordResetUser(ctx context.Context, tokenHash string) (*User, error) {
+	var reset PasswordReset
+	err := getDb(ctx).Where("token_hash = ? AND used_at = 0 AND expires_at > ?", tokenHash, time.Now().UnixMilli()).
+		First(&reset).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPasswordResetInvalid
+		}
+		return nil, err
+	}
+	user, err := GetUserById(ctx, reset.UserId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPasswordResetInvalid
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResetPassword 校验并消费找回密码 token，更新密码并清除强制改密标记，历史密码只保留最近 keepHistory 条
+func ResetPassword(ctx context.Context, tokenHash string, pwdFields map[string]interface{}, keepHistory int) (int64, error) {
+	var userId int64
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var reset PasswordReset
+		err := tx.Where("token_hash = ? AND used_at = 0", tokenHash).First(&reset).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPasswordResetInvalid
+			}
+			return err
+		}
+		now := time.Now().UnixMilli()
+		if reset.ExpiresAt <= now {
+			return ErrPasswordResetInvalid
+		}
+		// 条件更新保证 token 只能使用一次
+		result := tx.Model(&PasswordReset{}).Where("id = ? AND used_at = 0", reset.Id).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPasswordResetInvalid
+		}
+
+		fields := map[string]interface{}{"must_change_pwd": false}
+		for k, v := range pwdFields {
+			fields[k] = v
+		}
+		if err := savePasswordHistory(tx, reset.UserId, keepHistory); err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserId).Updates(fields).Error; err != nil {
+			return err
+		}
+		if err := invalidatePasswordResets(tx, reset.UserId); err != nil {
+			return err
+		}
+		userId = reset.UserId
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	InvalidateUserCache(userId)
+	return userId, nil
+}
+
+// InvalidatePasswordResets 作废用户所有未使用的找回密码 token，用于修改密码后
+func InvalidatePasswordResets(ctx context.Context, userId int64) error {
+	return invalidatePasswordResets(getDb(ctx), userId)
+}
+
+func invalidatePasswordResets(tx *gorm.DB, userId int64) error {
+	return tx.Model(&PasswordReset{}).Where("user_id = ? AND used_at = 0", userId).
+		Update("used_at", time.Now().UnixMilli()).Error
+}
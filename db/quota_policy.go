@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"pionex-administrative-sys/utils/quota"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 配额策略的适用范围，优先级：部门 > 角色 > 默认
+const (
+	QuotaScopeDefault    = "default"
+	QuotaScopeDepartment = "department" // 部门及其下级部门的用户，离用户最近的部门优先
+	QuotaScopeRole       = "role"       // 拥有该角色的用户，多个角色都有策略时按最宽松的计算
+)
+
+// QuotaPolicy 卡券领取配额策略，同一范围内的多条策略需要同时满足
+type QuotaPolicy struct {
+	Id            int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	CouponType    int    `gorm:"column:coupon_type;index;not null"`
+	Scope         string `gorm:"column:scope;type:varchar(16);not null;default:default"`
+	ScopeId       int64  `gorm:"column:scope_id;default:0"`               // 部门或角色 ID
+	Period        string `gorm:"column:period;type:varchar(16);not null"` // rolling/day/week/month/lifetime
+	WindowMinutes int    `gorm:"column:window_minutes;default:0"`         // 滚动窗口长度（分钟）
+	Limit         int    `gorm:"column:limit_count;not null"`             // 周期内最多领取次数
+	Timezone      string `gorm:"column:timezone;type:varchar(64)"`        // 自然日、周、月的时区，为空时使用服务器时区
+	CreatedAt     int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt     int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+}
+
+func (QuotaPolicy) TableName() string {
+	return "quota_policies"
+}
+
+// Rule 转换为配额规则
+func (p QuotaPolicy) Rule() (quota.Rule, error) {
+	r := quota.Rule{
+		Period: p.Period,
+		Window: time.Duration(p.WindowMinutes) * time.Minute,
+		Limit:  p.Limit,
+	}
+	if p.Timezone != "" {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return r, err
+		}
+		r.Location = loc
+	}
+	return r, r.Validate()
+}
+
+// 是否已写入升级前的固定规则，删除后不再重新写入
+const settingQuotaSeeded = "quota_policies_seeded"
+
+// seedQuotaPolicies 首次启动时为健身卡写入原来的固定规则：12 小时内只能领 1 张
+func seedQuotaPolicies() error {
+	seeded, err := GetSetting(context.Background(), settingQuotaSeeded, "")
+	if err != nil || seeded != "" {
+		return err
+	}
+	if _, ok := GetCouponTypeById(couponTypeFitness); ok {
+		var count int64
+		if err := db.Model(&QuotaPolicy{}).Where("coupon_type = ?", couponTypeFitness).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			policy := &QuotaPolicy{
+				CouponType:    couponTypeFitness,
+				Scope:         QuotaScopeDefault,
+				Period:        quota.PeriodRolling,
+				WindowMinutes: 12 * 60,
+				Limit:         1,
+			}
+			if err := db.Create(policy).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return SetSetting(context.Background(), settingQuotaSeeded, "1")
+}
+
+// GetQuotaPolicies 查询配额策略，couponType 为 0 时查询全部
+func GetQuotaPolicies(ctx context.Context, couponType int) ([]*QuotaPolicy, error) {
+	var list []*QuotaPolicy
+	query := getDb(ctx).Order("coupon_type, scope, scope_id, id")
+	if couponType > 0 {
+		query = query.Where("coupon_type = ?", couponType)
+	}
+	if err := query.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetQuotaPolicyById 根据 ID 查询配额策略
+func GetQuotaPolicyById(ctx context.Context, id int64) (*QuotaPolicy, error) {
+	var p QuotaPolicy
+	if err := getDb(ctx).Where("id = ?", id).First(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreateQuotaPolicy 创建配额策略
+func CreateQuotaPolicy(ctx context.Context, p *QuotaPolicy) error {
+	return getDb(ctx).Create(p).Error
+}
+
+// UpdateQuotaPolicy 保存配额策略
+func UpdateQuotaPolicy(ctx context.Context, p *QuotaPolicy) error {
+	return getDb(ctx).Save(p).Error
+}
+
+// DeleteQuotaPolicy 删除配额策略
+func DeleteQuotaPolicy(ctx context.Context, id int64) error {
+	return getDb(ctx).Where("id = ?", id).Delete(&QuotaPolicy{}).Error
+}
+
+// GetUserQuota 计算用户领取指定类型卡券的剩余配额
+func GetUserQuota(ctx context.Context, userId int64, couponType int, now time.Time) (quota.Result, error) {
+	return userQuota(getDb(ctx), userId, couponType, now)
+}
+
+// userQuota 在 tx 中计算配额，领取时和分配卡券在同一事务中执行
+func userQuota(tx *gorm.DB, userId int64, couponType int, now time.Time) (quota.Result, error) {
+	var policies []*QuotaPolicy
+	if err := tx.Where("coupon_type = ?", couponType).Order("scope, scope_id, id").Find(&policies).Error; err != nil {
+		return quota.Result{}, err
+	}
+	if len(policies) == 0 {
+		return quota.Result{Unlimited: true}, nil
+	}
+
+	history := func(since time.Time) ([]time.Time, error) {
+		var claimedAt []int64
+		query := tx.Model(&CouponClaim{}).Where("user_id = ? AND coupon_type = ?", userId, couponType)
+		if !since.IsZero() {
+			query = query.Where("claimed_at >= ?", since.UnixMilli())
+		}
+		if err := query.Order("claimed_at ASC").Pluck("claimed_at", &claimedAt).Error; err != nil {
+			return nil, err
+		}
+		times := make([]time.Time, 0, len(claimedAt))
+		for _, ms := range claimedAt {
+			times = append(times, time.UnixMilli(ms))
+		}
+		return times, nil
+	}
+
+	// 按范围分组
+	var defaults []*QuotaPolicy
+	byDept := make(map[int64][]*QuotaPolicy)
+	byRole := make(map[int64][]*QuotaPolicy)
+	for _, p := range policies {
+		switch p.Scope {
+		case QuotaScopeDepartment:
+			byDept[p.ScopeId] = append(byDept[p.ScopeId], p)
+		case QuotaScopeRole:
+			byRole[p.ScopeId] = append(byRole[p.ScopeId], p)
+		default:
+			defaults = append(defaults, p)
+		}
+	}
+
+	// 部门策略：从用户所在部门向上查找最近的有策略的部门
+	if len(byDept) > 0 {
+		var user User
+		if err := tx.Select("id", "department_id").Where("id = ?", userId).First(&user).Error; err != nil {
+			return quota.Result{}, err
+		}
+		chain, err := departmentAncestorIds(tx, user.DepartmentId)
+		if err != nil {
+			return quota.Result{}, err
+		}
+		for _, id := range chain {
+			if set, ok := byDept[id]; ok {
+				return evaluatePolicies(set, now, history)
+			}
+		}
+	}
+
+	// 角色策略：多个角色都有策略时取剩余次数最多的
+	if len(byRole) > 0 {
+		var roleIds []int64
+		if err := tx.Model(&UserRole{}).Where("user_id = ?", userId).Order("role_id").Pluck("role_id", &roleIds).Error; err != nil {
+			return quota.Result{}, err
+		}
+		var (
+			best  quota.Result
+			found bool
+		)
+		for _, id := range roleIds {
+			set, ok := byRole[id]
+			if !ok {
+				continue
+			}
+			res, err := evaluatePolicies(set, now, history)
+			if err != nil {
+				return quota.Result{}, err
+			}
+			if !found || res.Remaining > best.Remaining {
+				best, found = res, true
+			}
+		}
+		if found {
+			return best, nil
+		}
+	}
+
+	return evaluatePolicies(defaults, now, history)
+}
+
+func evaluatePolicies(policies []*QuotaPolicy, now time.Time, history quota.History) (quota.Result, error) {
+	rules := make([]quota.Rule, 0, len(policies))
+	for _, p := range policies {
+		r, err := p.Rule()
+		if err != nil {
+			return quota.Result{}, fmt.Errorf("invalid quota policy %d: %w", p.Id, err)
+		}
+		rules = append(rules, r)
+	}
+	return quota.Evaluate(rules, now, history)
+}
+
+// departmentAncestorIds 部门及其所有上级部门，从近到远
+func departmentAncestorIds(tx *gorm.DB, deptId int64) ([]int64, error) {
+	var ids []int64
+	if deptId == 0 {
+		return ids, nil
+	}
+	var all []*Department
+	if err := tx.Select("id", "parent_id").Find(&all).Error; err != nil {
+		return nil, err
+	}
+	parent := make(map[int64]int64, len(all))
+	for _, d := range all {
+		parent[d.Id] = d.ParentId
+	}
+	seen := make(map[int64]bool)
+	for id := deptId; id != 0 && !seen[id]; id = parent[id] {
+		if _, ok := parent[id]; !ok {
+			break
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode 两步验证恢复码，每个只能使用一次
+type RecoveryCode struct {
+	Id        int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId    int64  `gorm:"column:user_id;index;not null"`
+	CodeHash  string `gorm:"column:code_hash;type:varchar(64);not null"`
+	UsedAt    int64  `gorm:"column:used_at;default:0"`
+	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// ReplaceRecoveryCodes 重新生成用户的恢复码，旧恢复码全部作废
+func ReplaceRecoveryCodes(ctx context.Context, userId int64, codeHashes []string) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]*RecoveryCode, 0, len(codeHashes))
+		for _, h := range codeHashes {
+			codes = append(codes, &RecoveryCode{UserId: userId, CodeHash: h})
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+// UseRecoveryCode 使用恢复码，成功返回 true
+func UseRecoveryCode(ctx context.Context, userId int64, codeHash string) (bool, error) {
+	result := getDb(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at = 0", userId, codeHash).
+		Update("used_at", time.Now().UnixMilli())
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountUnusedRecoveryCodes 统计剩余可用的恢复码
+func CountUnusedRecoveryCodes(ctx context.Context, userId int64) (int64, error) {
+	var count int64
+	err := getDb(ctx).Model(&RecoveryCode{}).Where("user_id = ? AND used_at = 0", userId).Count(&count).Error
+	return count, err
+}
+
+// DeleteRecoveryCodes 删除用户的所有恢复码
+func DeleteRecoveryCodes(ctx context.Context, userId int64) error {
+	return getDb(ctx).Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 注册申请状态
+const (
+	RegistrationPending  = 0
+	RegistrationApproved = 1
+	RegistrationRejected = 2
+)
+
+// Registration 自助注册申请，管理员审核通过后才创建用户
+type Registration struct {
+	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	Name       string `gorm:"column:name;type:varchar(64);not null"`
+	Account    string `gorm:"column:account;type:varchar(128);index;not null"`
+	Email      string `gorm:"column:email;type:varchar(128)"`
+	PwdHash    string `gorm:"column:pwd_hash;type:varchar(255)"`
+	Status     int    `gorm:"column:status;index;default:0"` // 0=待审核 1=已通过 2=已拒绝
+	UserId     int64  `gorm:"column:user_id;default:0"`      // 审核通过后创建的用户
+	ReviewerId int64  `gorm:"column:reviewer_id;default:0"`
+	Reason     string `gorm:"column:reason;type:varchar(255)"` // 拒绝原因
+	ReviewedAt int64  `gorm:"column:reviewed_at;default:0"`
+	CreatedAt  int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (Registration) TableName() string {
+	return "registrations"
+}
+
+// CreateRegistration 提交注册申请
+func CreateRegistration(ctx context.Context, r *Registration) error {
+	return getDb(ctx).Create(r).Error
+}
+
+// HasPendingRegistration 账号是否已有待审核的申请
+func HasPendingRegistration(ctx context.Context, account string) (bool, error) {
+	var count int64
+	err := getDb(ctx).Model(&Registration{}).
+		Where("account = ? AND status = ?", account, RegistrationPending).Count(&count).Error
+	return count > 0, err
+}
+
+// GetRegistrations 按状态查询注册申请
+func GetRegistrations(ctx context.Context, status, offset, limit int) ([]*Registration, error) {
+	var list []*Registration
+	err := getDb(ctx).Where("status = ?", status).Order("id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CountRegistrations 按状态统计注册申请
+func CountRegistrations(ctx context.Context, status int) (int64, error) {
+	var count int64
+	err := getDb(ctx).Model(&Registration{}).Where("status = ?", status).Count(&count).Error
+	return count, err
+}
+
+// ApproveRegistration 审核通过，按指定角色创建用户
+func ApproveRegistration(ctx context.Context, id int64, roleIds []int64, reviewerId int64) (*User, error) {
+	var user *User
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var r Registration
+		err := tx.Where("id = ? AND status = ?", id, RegistrationPending).First(&r).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRegistrationNotFound
+			}
+			return err
+		}
+		var count int64
+		if err := tx.Model(&User{}).Where("account = ?", r.Account).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAccountExists
+		}
+
+		user = &User{
+			Name:    r.Name,
+			Account: r.Account,
+			Email:   r.Email,
+			PwdHash: r.PwdHash,
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := addUserRoles(tx, user.Id, roleIds); err != nil {
+			return err
+		}
+		return tx.Model(&Registration{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"status":      RegistrationApproved,
+			"user_id":     user.Id,
+			"reviewer_id": reviewerId,
+			"reviewed_at": time.Now().UnixMilli(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RejectRegistration 拒绝注册申请
+func RejectRegistration(ctx context.Context, id int64, reviewerId int64, reason string) (bool, error) {
+	result := getDb(ctx).Model(&Registration{}).Where("id = ? AND status = ?", id, RegistrationPending).
+		Updates(map[string]interface{}{
+			"status":      RegistrationRejected,
+			"reviewer_id": reviewerId,
+			"reason":      reason,
+			"reviewed_at": time.Now().UnixMilli(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
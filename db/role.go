@@ -1,53 +1,640 @@
 package db
 
-type CommonRole struct {
-	Name string
-	Role int
-}
+import (
+	"context"
+	"errors"
+	"pionex-administrative-sys/utils"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
 
-// 权限位定义
+// 权限，接口通过 middleware.RequirePermission 校验
 const (
-	roleAdmin = iota
-	roleLogin
-	roleStock
-	roleAApplyCoupon
+	PermLogin                = "login"
+	PermUserView             = "user.view"
+	PermUserCreate           = "user.create"
+	PermUserUpdate           = "user.update"
+	PermUserDelete           = "user.delete"
+	PermUserSecurity         = "user.security" // 登录锁定、两步验证策略和重置
+	PermRegistrationReview   = "registration.review"
+	PermInviteManage         = "invite.manage"
+	PermRoleManage           = "role.manage"
+	PermDepartmentManage     = "department.manage"
+	PermAuditView            = "audit.view"
+	PermCouponView           = "coupon.view"
+	PermCouponCreate         = "coupon.create"
+	PermCouponImport         = "coupon.import"
+	PermCouponUpdate         = "coupon.update"
+	PermCouponDelete         = "coupon.delete"
+	PermCouponVoid           = "coupon.void"
+	PermCouponDistribute     = "coupon.distribute"
+	PermCouponTransferReview = "coupon.transfer_review" // 审批卡券转让
+	PermCouponTake           = "coupon.take"
+	PermCouponTypeManage     = "coupon_type.manage"
 )
 
-var (
-	RoleAdmin = newForRole(
-		"管理员", roleAdmin,
-	)
-	RoleLogin = newForRole(
-		"登录", roleLogin,
-	)
-	RoleStock = newForRole(
-		"库存管理", roleStock,
-	)
-	RoleApplyCoupon = newForRole(
-		"卡券申请", roleAApplyCoupon,
-	)
+// 旧版本的权限位，只用于迁移历史数据和兼容旧配置
+const (
+	legacyMaskAdmin       = 1 << 0
+	legacyMaskLogin       = 1 << 1
+	legacyMaskStock       = 1 << 2
+	legacyMaskApplyCoupon = 1 << 3
 )
 
-func newForRole(name string, role int) CommonRole {
-	return CommonRole{
-		Name: name,
-		Role: 1 << role,
+// Permission 权限，由代码定义，启动时写入数据库
+type Permission struct {
+	Code string `gorm:"column:code;type:varchar(64);primaryKey"`
+	Name string `gorm:"column:name;type:varchar(64)"`
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+var permissionCatalog = []Permission{
+	{PermLogin, "登录"},
+	{PermUserView, "查看用户"},
+	{PermUserCreate, "添加用户"},
+	{PermUserUpdate, "修改用户"},
+	{PermUserDelete, "删除用户"},
+	{PermUserSecurity, "账号安全管理"},
+	{PermRegistrationReview, "审核注册申请"},
+	{PermInviteManage, "管理邀请码"},
+	{PermRoleManage, "管理角色"},
+	{PermDepartmentManage, "管理部门"},
+	{PermAuditView, "查看审计日志"},
+	{PermCouponView, "查看卡券"},
+	{PermCouponCreate, "添加卡券"},
+	{PermCouponImport, "导入卡券"},
+	{PermCouponUpdate, "修改卡券"},
+	{PermCouponDelete, "删除卡券"},
+	{PermCouponVoid, "作废卡券"},
+	{PermCouponDistribute, "发放卡券"},
+	{PermCouponTransferReview, "审批卡券转让"},
+	{PermCouponTake, "申领卡券"},
+	{PermCouponTypeManage, "管理卡券类型"},
+}
+
+// Role 角色，包含一组权限，用户可以拥有多个角色
+type Role struct {
+	Id          int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	Name        string `gorm:"column:name;type:varchar(64);uniqueIndex;not null"`
+	Description string `gorm:"column:description;type:varchar(255)"`
+	Builtin     bool   `gorm:"column:builtin;default:false"` // 内置角色，不能删除
+	LegacyMask  int    `gorm:"column:legacy_mask;default:0"` // 内置角色对应的旧权限位
+	CreatedAt   int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt   int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// RolePermission 角色包含的权限
+type RolePermission struct {
+	RoleId     int64  `gorm:"column:role_id;primaryKey"`
+	Permission string `gorm:"column:permission;type:varchar(64);primaryKey"`
+}
+
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// UserRole 用户拥有的角色
+type UserRole struct {
+	UserId    int64 `gorm:"column:user_id;primaryKey"`
+	RoleId    int64 `gorm:"column:role_id;primaryKey;index"`
+	CreatedAt int64 `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
+
+// 内置角色与旧权限位一一对应，新增权限时自动加入默认包含它的内置角色
+var builtinRoles = []struct {
+	name  string
+	desc  string
+	mask  int
+	perms []string
+}{
+	{"管理员", "用户、角色管理和审计", legacyMaskAdmin, []string{
+		PermUserView, PermUserCreate, PermUserUpdate, PermUserDelete, PermUserSecurity,
+		PermRegistrationReview, PermInviteManage, PermRoleManage, PermDepartmentManage, PermAuditView,
+		PermCouponTypeManage,
+	}},
+	{"登录", "允许登录系统", legacyMaskLogin, []string{PermLogin}},
+	{"库存管理", "卡券库存的查看、导入和维护", legacyMaskStock, []string{
+		PermCouponView, PermCouponCreate, PermCouponImport, PermCouponUpdate, PermCouponDelete, PermCouponVoid,
+		PermCouponDistribute, PermCouponTransferReview,
+	}},
+	{"卡券申请", "申领卡券", legacyMaskApplyCoupon, []string{PermCouponTake}},
+}
+
+// PermissionSet 权限集合
+type PermissionSet map[string]bool
+
+// Has 是否拥有指定权限
+func (s PermissionSet) Has(perm string) bool {
+	return s[perm]
+}
+
+// Contains 是否包含另一个集合的所有权限
+func (s PermissionSet) Contains(other PermissionSet) bool {
+	for p := range other {
+		if !s[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersect 两个集合的交集
+func (s PermissionSet) Intersect(other PermissionSet) PermissionSet {
+	out := PermissionSet{}
+	for p := range s {
+		if other[p] {
+			out[p] = true
+		}
+	}
+	return out
+}
+
+// List 排序后的权限列表
+func (s PermissionSet) List() []string {
+	list := make([]string, 0, len(s))
+	for p := range s {
+		list = append(list, p)
 	}
+	sort.Strings(list)
+	return list
 }
 
-func AllRoles() []CommonRole {
-	return []CommonRole{
-		RoleAdmin,
-		RoleLogin,
-		RoleStock,
-		RoleApplyCoupon,
+// NewPermissionSet 由权限列表创建集合
+func NewPermissionSet(perms []string) PermissionSet {
+	s := make(PermissionSet, len(perms))
+	for _, p := range perms {
+		s[p] = true
+	}
+	return s
+}
+
+// IsValidPermission 是否为已定义的权限
+func IsValidPermission(perm string) bool {
+	for _, p := range permissionCatalog {
+		if p.Code == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// AllPermissions 所有权限
+func AllPermissions() []Permission {
+	return append([]Permission(nil), permissionCatalog...)
+}
+
+// seedRoles 写入权限和内置角色，新增的权限加入默认包含它的内置角色
+func seedRoles() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing []string
+		if err := tx.Model(&Permission{}).Pluck("code", &existing).Error; err != nil {
+			return err
+		}
+		known := NewPermissionSet(existing)
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "code"}},
+			DoUpdates: clause.AssignmentColumns([]string{"name"}),
+		}).Create(&permissionCatalog).Error; err != nil {
+			return err
+		}
+
+		for _, b := range builtinRoles {
+			var role Role
+			err := tx.Where("legacy_mask = ?", b.mask).First(&role).Error
+			created := false
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				role = Role{Name: b.name, Description: b.desc, Builtin: true, LegacyMask: b.mask}
+				if err := tx.Create(&role).Error; err != nil {
+					return err
+				}
+				created = true
+			} else if err != nil {
+				return err
+			}
+			var add []RolePermission
+			for _, p := range b.perms {
+				if created || !known[p] {
+					add = append(add, RolePermission{RoleId: role.Id, Permission: p})
+				}
+			}
+			if len(add) > 0 {
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&add).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// roleIdsByMask 旧权限位对应的内置角色
+func roleIdsByMask(tx *gorm.DB, mask int) ([]int64, error) {
+	var ids []int64
+	if mask == 0 {
+		return ids, nil
+	}
+	err := tx.Model(&Role{}).Where("legacy_mask != 0 AND legacy_mask & ? != 0", mask).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// migrateLegacyRoles 将用户、邀请码、API token 和两步验证策略中的旧权限位迁移为角色，迁移后清零
+func migrateLegacyRoles() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var users []*User
+		if err := tx.Select("id", "role").Where("role != 0").Find(&users).Error; err != nil {
+			return err
+		}
+		for _, u := range users {
+			ids, err := roleIdsByMask(tx, u.LegacyRole)
+			if err != nil {
+				return err
+			}
+			if err := addUserRoles(tx, u.Id, ids); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&User{}).Where("role != 0").UpdateColumn("role", 0).Error; err != nil {
+			return err
+		}
+
+		var invites []*InviteCode
+		if err := tx.Select("id", "role").Where("role != 0").Find(&invites).Error; err != nil {
+			return err
+		}
+		for _, v := range invites {
+			ids, err := roleIdsByMask(tx, v.LegacyRole)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&InviteCode{}).Where("id = ?", v.Id).
+				Select("role_ids", "role").Updates(&InviteCode{RoleIds: ids, LegacyRole: 0}).Error; err != nil {
+				return err
+			}
+		}
+
+		var tokens []*APIToken
+		if err := tx.Select("id", "role").Where("role != 0").Find(&tokens).Error; err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			ids, err := roleIdsByMask(tx, t.LegacyRole)
+			if err != nil {
+				return err
+			}
+			perms, err := rolesPermissions(tx, ids)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&APIToken{}).Where("id = ?", t.Id).
+				Select("permissions", "role").Updates(&APIToken{Permissions: perms.List(), LegacyRole: 0}).Error; err != nil {
+				return err
+			}
+		}
+
+		return migrateLegacyMFARoles(tx)
+	})
+}
+
+// GetRoles 查询所有角色
+func GetRoles(ctx context.Context) ([]*Role, error) {
+	var roles []*Role
+	err := getDb(ctx).Order("id").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetRoleById 根据ID查询角色
+func GetRoleById(ctx context.Context, id int64) (*Role, error) {
+	var role Role
+	err := getDb(ctx).Where("id = ?", id).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetRolePermissionMap 查询角色包含的权限
+func GetRolePermissionMap(ctx context.Context) (map[int64][]string, error) {
+	var list []RolePermission
+	if err := getDb(ctx).Order("role_id, permission").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	m := make(map[int64][]string)
+	for _, rp := range list {
+		m[rp.RoleId] = append(m[rp.RoleId], rp.Permission)
+	}
+	return m, nil
+}
+
+// CountRoleUsers 统计每个角色的用户数
+func CountRoleUsers(ctx context.Context) (map[int64]int64, error) {
+	var rows []struct {
+		RoleId int64
+		Count  int64
+	}
+	err := getDb(ctx).Model(&UserRole{}).Select("role_id, COUNT(*) AS count").Group("role_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int64]int64, len(rows))
+	for _, r := range rows {
+		m[r.RoleId] = r.Count
+	}
+	return m, nil
+}
+
+// CreateRole 创建角色
+func CreateRole(ctx context.Context, role *Role, perms []string) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkRoleName(tx, role.Name, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		return setRolePermissions(tx, role.Id, perms)
+	})
+}
+
+// UpdateRole 更新角色，perms 为 nil 时不修改权限
+func UpdateRole(ctx context.Context, id int64, fields map[string]interface{}, perms []string) error {
+	defer invalidateAllUserAccess()
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(fields) > 0 {
+			if name, ok := fields["name"].(string); ok {
+				if err := checkRoleName(tx, name, id); err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&Role{}).Where("id = ?", id).Updates(fields).Error; err != nil {
+				return err
+			}
+		}
+		if perms == nil {
+			return nil
+		}
+		return setRolePermissions(tx, id, perms)
+	})
+}
+
+// DeleteRole 删除非内置角色及其分配
+func DeleteRole(ctx context.Context, id int64) (bool, error) {
+	defer invalidateAllUserAccess()
+	var deleted bool
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND builtin = ?", id, false).Delete(&Role{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = true
+		if err := tx.Where("role_id = ?", id).Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("scope = ? AND scope_id = ?", QuotaScopeRole, id).Delete(&QuotaPolicy{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("role_id = ?", id).Delete(&UserRole{}).Error
+	})
+	return deleted, err
+}
+
+// checkRoleName 检查角色名是否已被其他角色使用
+func checkRoleName(tx *gorm.DB, name string, excludeId int64) error {
+	var count int64
+	if err := tx.Model(&Role{}).Where("name = ? AND id != ?", name, excludeId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrRoleNameExists
+	}
+	return nil
+}
+
+func setRolePermissions(tx *gorm.DB, roleId int64, perms []string) error {
+	if err := tx.Where("role_id = ?", roleId).Delete(&RolePermission{}).Error; err != nil {
+		return err
+	}
+	if len(perms) == 0 {
+		return nil
+	}
+	list := make([]RolePermission, 0, len(perms))
+	for p := range NewPermissionSet(perms) {
+		list = append(list, RolePermission{RoleId: roleId, Permission: p})
+	}
+	return tx.Create(&list).Error
+}
+
+// ValidRoleIds 角色是否都存在
+func ValidRoleIds(ctx context.Context, ids []int64) (bool, error) {
+	ids = uniqueIds(ids)
+	if len(ids) == 0 {
+		return true, nil
+	}
+	var count int64
+	err := getDb(ctx).Model(&Role{}).Where("id IN ?", ids).Count(&count).Error
+	return count == int64(len(ids)), err
+}
+
+// DefaultRoleIds 未指定角色时使用的默认角色，即内置的登录角色
+func DefaultRoleIds(ctx context.Context) ([]int64, error) {
+	return roleIdsByMask(getDb(ctx), legacyMaskLogin)
+}
+
+// ResolveRoleNames 将配置中的角色名和旧权限位转换为角色，返回不存在的角色名
+func ResolveRoleNames(ctx context.Context, names utils.RoleNames) ([]int64, []string, error) {
+	ids, err := roleIdsByMask(getDb(ctx), names.LegacyMask)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names.Names) == 0 {
+		return ids, nil, nil
+	}
+	var roles []*Role
+	if err := getDb(ctx).Where("name IN ?", names.Names).Find(&roles).Error; err != nil {
+		return nil, nil, err
+	}
+	found := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		found[r.Name] = true
+		ids = append(ids, r.Id)
+	}
+	var unknown []string
+	for _, n := range names.Names {
+		if !found[n] {
+			unknown = append(unknown, n)
+		}
+	}
+	return uniqueIds(ids), unknown, nil
+}
+
+// RolesPermissions 多个角色的权限合集
+func RolesPermissions(ctx context.Context, roleIds []int64) (PermissionSet, error) {
+	return rolesPermissions(getDb(ctx), roleIds)
+}
+
+func rolesPermissions(tx *gorm.DB, roleIds []int64) (PermissionSet, error) {
+	var perms []string
+	if len(roleIds) == 0 {
+		return PermissionSet{}, nil
+	}
+	err := tx.Model(&RolePermission{}).Where("role_id IN ?", roleIds).Distinct().Pluck("permission", &perms).Error
+	if err != nil {
+		return nil, err
+	}
+	return NewPermissionSet(perms), nil
+}
+
+// GetUserRoleIds 查询用户的角色
+func GetUserRoleIds(ctx context.Context, userId int64) ([]int64, error) {
+	var ids []int64
+	err := getDb(ctx).Model(&UserRole{}).Where("user_id = ?", userId).Order("role_id").Pluck("role_id", &ids).Error
+	return ids, err
+}
+
+// GetUsersRoleIds 批量查询用户的角色
+func GetUsersRoleIds(ctx context.Context, userIds []int64) (map[int64][]int64, error) {
+	m := make(map[int64][]int64)
+	if len(userIds) == 0 {
+		return m, nil
+	}
+	var list []UserRole
+	if err := getDb(ctx).Where("user_id IN ?", userIds).Order("user_id, role_id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	for _, ur := range list {
+		m[ur.UserId] = append(m[ur.UserId], ur.RoleId)
+	}
+	return m, nil
+}
+
+// GetUserRoleNames 查询用户的角色名
+func GetUserRoleNames(ctx context.Context, userId int64) ([]string, error) {
+	var names []string
+	err := getDb(ctx).Model(&Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userId).Order("roles.id").Pluck("roles.name", &names).Error
+	return names, err
+}
+
+// SetUserRoles 替换用户的角色
+func SetUserRoles(ctx context.Context, userId int64, roleIds []int64) error {
+	defer InvalidateUserCache(userId)
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		return setUserRoles(tx, userId, roleIds)
+	})
+}
+
+// AddUserRoles 为用户补充角色，已有的角色不变
+func AddUserRoles(ctx context.Context, userId int64, roleIds []int64) error {
+	defer InvalidateUserCache(userId)
+	return addUserRoles(getDb(ctx), userId, roleIds)
+}
+
+// ReplaceUserRoles 将用户在 managed 范围内的角色替换为 roleIds，范围外的角色不变
+func ReplaceUserRoles(ctx context.Context, userId int64, managed, roleIds []int64) error {
+	defer InvalidateUserCache(userId)
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(managed) > 0 {
+			query := tx.Where("user_id = ? AND role_id IN ?", userId, managed)
+			if len(roleIds) > 0 {
+				query = query.Where("role_id NOT IN ?", roleIds)
+			}
+			if err := query.Delete(&UserRole{}).Error; err != nil {
+				return err
+			}
+		}
+		return addUserRoles(tx, userId, roleIds)
+	})
+}
+
+// CreateUserWithRoles 创建用户并分配角色
+func CreateUserWithRoles(ctx context.Context, user *User, roleIds []int64) error {
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return addUserRoles(tx, user.Id, roleIds)
+	})
+}
+
+func setUserRoles(tx *gorm.DB, userId int64, roleIds []int64) error {
+	if err := tx.Where("user_id = ?", userId).Delete(&UserRole{}).Error; err != nil {
+		return err
+	}
+	return addUserRoles(tx, userId, roleIds)
+}
+
+func addUserRoles(tx *gorm.DB, userId int64, roleIds []int64) error {
+	roleIds = uniqueIds(roleIds)
+	if len(roleIds) == 0 {
+		return nil
+	}
+	list := make([]UserRole, 0, len(roleIds))
+	for _, id := range roleIds {
+		list = append(list, UserRole{UserId: userId, RoleId: id})
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&list).Error
+}
+
+// UserAccess 用户的角色和权限
+type UserAccess struct {
+	RoleIds      []int64
+	Permissions  PermissionSet
+	CouponGrants CouponGrants // 按卡券类型授予的权限
+}
+
+// HasAnyRole 是否拥有其中任一角色
+func (a UserAccess) HasAnyRole(roleIds []int64) bool {
+	for _, id := range a.RoleIds {
+		for _, r := range roleIds {
+			if id == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetUserAccess 查询用户的角色和权限
+func GetUserAccess(ctx context.Context, userId int64) (*UserAccess, error) {
+	ids, err := GetUserRoleIds(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := RolesPermissions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := GetUserCouponGrants(ctx, userId)
+	if err != nil {
+		return nil, err
 	}
+	return &UserAccess{RoleIds: ids, Permissions: perms, CouponGrants: grants}, nil
 }
 
-func MergeRole(rs ...CommonRole) int {
-	var x = 0
-	for _, v := range rs {
-		x = x | v.Role
+func uniqueIds(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
 	}
-	return x
+	return out
 }
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 系统设置项
+const (
+	SettingMFARequiredRoles = "mfa_required_role_ids" // 必须启用两步验证的角色，JSON 数组
+
+	legacySettingMFARequiredRoles = "mfa_required_roles" // 旧版本的权限位
+)
+
+// Setting 系统设置，管理员可在运行时修改
+type Setting struct {
+	Key       string `gorm:"column:key;type:varchar(64);primaryKey"`
+	Value     string `gorm:"column:value;type:text"`
+	UpdatedAt int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+}
+
+func (Setting) TableName() string {
+	return "settings"
+}
+
+var settingCache sync.Map // map[string]string
+
+// GetSetting 获取设置项，不存在时返回默认值
+func GetSetting(ctx context.Context, key, defVal string) (string, error) {
+	if v, ok := settingCache.Load(key); ok {
+		return v.(string), nil
+	}
+	var s Setting
+	err := getDb(ctx).Where("`key` = ?", key).First(&s).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			settingCache.Store(key, defVal)
+			return defVal, nil
+		}
+		return "", err
+	}
+	settingCache.Store(key, s.Value)
+	return s.Value, nil
+}
+
+// SetSetting 保存设置项
+func SetSetting(ctx context.Context, key, value string) error {
+	defer settingCache.Delete(key)
+	return getDb(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&Setting{Key: key, Value: value}).Error
+}
+
+// GetSettingInt 获取整数设置项
+func GetSettingInt(ctx context.Context, key string, defVal int) (int, error) {
+	v, err := GetSetting(ctx, key, strconv.Itoa(defVal))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// SetSettingInt 保存整数设置项
+func SetSettingInt(ctx context.Context, key string, value int) error {
+	return SetSetting(ctx, key, strconv.Itoa(value))
+}
+
+// GetMFARequiredRoles 获取必须启用两步验证的角色
+func GetMFARequiredRoles(ctx context.Context) ([]int64, error) {
+	v, err := GetSetting(ctx, SettingMFARequiredRoles, "[]")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	if err := json.Unmarshal([]byte(v), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SetMFARequiredRoles 设置必须启用两步验证的角色
+func SetMFARequiredRoles(ctx context.Context, roleIds []int64) error {
+	data, err := json.Marshal(uniqueIds(roleIds))
+	if err != nil {
+		return err
+	}
+	return SetSetting(ctx, SettingMFARequiredRoles, string(data))
+}
+
+// UserNeedMFA 按策略判断用户是否必须启用两步验证：拥有其中任一角色
+func UserNeedMFA(ctx context.Context, userId int64) (bool, error) {
+	required, err := GetMFARequiredRoles(ctx)
+	if err != nil || len(required) == 0 {
+		return false, err
+	}
+	access, err := GetCachedUserAccess(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return access.HasAnyRole(required), nil
+}
+
+// migrateLegacyMFARoles 将旧的权限位策略转换为角色
+func migrateLegacyMFARoles(tx *gorm.DB) error {
+	var s Setting
+	err := tx.Where("`key` = ?", legacySettingMFARequiredRoles).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mask, _ := strconv.Atoi(s.Value)
+	ids, err := roleIdsByMask(tx, mask)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Setting{Key: SettingMFARequiredRoles, Value: string(data)}).Error; err != nil {
+		return err
+	}
+	return tx.Where("`key` = ?", legacySettingMFARequiredRoles).Delete(&Setting{}).Error
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RefreshToken 刷新令牌，每次刷新后作废并签发新令牌，同一次登录的令牌属于同一个 Family
+type RefreshToken struct {
+	Id              int64  `gorm:"column:id;primaryKey;autoIncrement"`
+	UserId          int64  `gorm:"column:user_id;index;not null"`
+	Family          string `gorm:"column:family;type:varchar(64);index;not null"`
+	TokenHash       string `gorm:"column:token_hash;type:varchar(64);uniqueIndex;not null"` // 令牌 SHA256
+	AccessJti       string `gorm:"column:access_jti;type:varchar(64);index"`                // 同时签发的 access token ID
+	AccessExpiresAt int64  `gorm:"column:access_expires_at"`
+	ExpiresAt       int64  `gorm:"column:expires_at;not null"`
+	UsedAt          int64  `gorm:"column:used_at;default:0"`
+	RevokedAt       int64  `gorm:"column:revoked_at;default:0"`
+	CreatedAt       int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken 已吊销的 access token
+type RevokedToken struct {
+	Jti       string `gorm:"column:jti;type:varchar(64);primaryKey"`
+	UserId    int64  `gorm:"column:user_id;index"`
+	ExpiresAt int64  `gorm:"column:expires_at;index"` // 过期后可清理
+	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// CreateRefreshToken 创建刷新令牌
+func CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	return getDb(ctx).Create(token).Error
+}
+
+// UseRefreshToken 消费刷新令牌，已使用过的令牌再次出现时视为泄露，吊销整个 Family
+func UseRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	reused := false
+	err := getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRefreshTokenInvalid
+			}
+			return err
+		}
+		now := time.Now().UnixMilli()
+		if token.UsedAt > 0 || token.RevokedAt > 0 {
+			reused = token.UsedAt > 0
+			return ErrRefreshTokenInvalid
+		}
+		if token.ExpiresAt < now {
+			return ErrRefreshTokenExpired
+		}
+		result := tx.Model(&RefreshToken{}).Where("id = ? AND used_at = 0", token.Id).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return ErrRefreshTokenInvalid
+		}
+		return nil
+	})
+	if reused {
+		if err := RevokeTokenFamily(ctx, token.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeTokenByJti 吊销 access token 及其所属的登录会话
+func RevokeTokenByJti(ctx context.Context, jti string) error {
+	var token RefreshToken
+	err := getDb(ctx).Where("access_jti = ?", jti).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return RevokeTokenFamily(ctx, token.Family)
+}
+
+// RevokeTokenFamily 吊销一次登录产生的所有令牌
+func RevokeTokenFamily(ctx context.Context, family string) error {
+	return revokeRefreshTokens(ctx, "family = ?", family)
+}
+
+// RevokeUserTokens 吊销用户的所有令牌，用于删除用户、修改密码等场景
+func RevokeUserTokens(ctx context.Context, userId int64) error {
+	return revokeRefreshTokens(ctx, "user_id = ?", userId)
+}
+
+// revokeRefreshTokens 作废刷新令牌，并将仍在有效期内的 access token 加入吊销列表
+func revokeRefreshTokens(ctx context.Context, cond string, value interface{}) error {
+	now := time.Now().UnixMilli()
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var tokens []*RefreshToken
+		if err := tx.Where(cond, value).Where("access_expires_at > ?", now).Find(&tokens).Error; err != nil {
+			return err
+		}
+		if len(tokens) > 0 {
+			revoked := make([]*RevokedToken, 0, len(tokens))
+			for _, t := range tokens {
+				revoked = append(revoked, &RevokedToken{
+					Jti:       t.AccessJti,
+					UserId:    t.UserId,
+					ExpiresAt: t.AccessExpiresAt,
+				})
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(revoked, 100).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&RefreshToken{}).Where(cond, value).Where("revoked_at = 0").
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		// 顺便清理已过期的吊销记录和刷新令牌
+		if err := tx.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+	})
+}
+
+// IsTokenRevoked 检查 access token 是否已被吊销
+func IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := getDb(ctx).Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
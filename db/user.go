@@ -2,37 +2,216 @@ package db
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"pionex-administrative-sys/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 用户来源
+const (
+	UserSourceLocal = "local"
+	UserSourceLDAP  = "ldap"
+	UserSourceOIDC  = "oidc"
 )
 
 type User struct {
 	Id         int64  `gorm:"column:id;primaryKey;autoIncrement"`
 	Name       string `gorm:"column:name;type:varchar(64);not null"`
 	Account    string `gorm:"column:account;type:varchar(128);uniqueIndex;not null"`
-	Md5Pwd     string `gorm:"column:md5_pwd;type:varchar(32);not null"`
-	Role       int    `gorm:"column:role;default:0"` // 权限位: 1=admin, 2=login
-	PrivateKey string `gorm:"column:private_key;type:varchar(128)"`
-	CreatedAt  int64  `gorm:"column:created_at;autoCreateTime:milli"`
-	UpdatedAt  int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
+	Email      string `gorm:"column:email;type:varchar(128)"`               // 用于找回密码
+	Md5Pwd     string `gorm:"column:md5_pwd;type:varchar(32);not null"`     // 已废弃，历史数据迁移到 PwdHash
+	PwdHash    string `gorm:"column:pwd_hash;type:varchar(255)"`            // 密码哈希，算法和参数编码在哈希串中
+	LegacyRole int    `gorm:"column:role;default:0"`                        // 已废弃的权限位，启动时迁移到 user_roles
+	PrivateKey string `gorm:"column:private_key;type:text"`                 // 加密保存，通过 DecryptPrivateKey 读取
+	Source     string `gorm:"column:source;type:varchar(16);default:local"` // 用户来源: local/ldap/oidc
+	Disabled   bool   `gorm:"column:disabled;default:false"`                // 已停用，不能登录
+	// 所属部门，为 0 表示未分配
+	DepartmentId int64 `gorm:"column:department_id;index;default:0"`
+	// 下次登录必须修改密码，管理员创建用户时可设置
+	MustChangePwd bool `gorm:"column:must_change_pwd;default:false"`
+	// 最近一次修改密码的时间，为 0 时以创建时间为准
+	PwdChangedAt int64 `gorm:"column:pwd_changed_at;default:0"`
+	// 私钥指纹，用于展示
+	PrivateKeyFp string `gorm:"column:private_key_fp;type:varchar(32)"`
+	// 两步验证
+	TotpSecret   string `gorm:"column:totp_secret;type:text"` // 加密保存，通过 DecryptTotpSecret 读取
+	TotpEnabled  bool   `gorm:"column:totp_enabled;default:false"`
+	TotpLastStep int64  `gorm:"column:totp_last_step;default:0"` // 最近一次使用的验证码周期，防止重放
+	CreatedAt    int64  `gorm:"column:created_at;autoCreateTime:milli"`
+	UpdatedAt    int64  `gorm:"column:updated_at;autoUpdateTime:milli"`
 }
 
 func (User) TableName() string {
 	return "users"
 }
 
-// HasRole 检查是否拥有指定权限
-func (u User) HasRole(role CommonRole) bool {
-	return u.Role&role.Role != 0
+// PwdExpired 本地账号的密码是否已超过有效期，maxAge 为 0 表示不过期
+func (u User) PwdExpired(maxAge time.Duration) bool {
+	if maxAge <= 0 || (u.Source != "" && u.Source != UserSourceLocal) {
+		return false
+	}
+	changedAt := u.PwdChangedAt
+	if changedAt == 0 {
+		changedAt = u.CreatedAt
+	}
+	return time.Since(time.UnixMilli(changedAt)) > maxAge
+}
+
+// CheckPwd 校验密码，返回哈希是否需要升级
+func (u User) CheckPwd(pwd string) (needRehash bool, err error) {
+	return utils.VerifyPassword(u.PwdHash, pwd)
 }
 
-func (u User) CheckPwd(pwd string) error {
-	if u.Md5Pwd != utils.MD5(pwd) {
-		return errors.New("wrong password")
+// SetPwd 使用默认算法设置密码哈希
+func (u *User) SetPwd(pwd string) error {
+	h, err := utils.HashPassword(pwd)
+	if err != nil {
+		return err
 	}
+	u.PwdHash = h
+	u.PwdChangedAt = time.Now().UnixMilli()
 	return nil
 }
 
+// PwdFields 生成修改密码所需的字段，通过 UpdateUserPwd 更新时会记录历史密码
+func PwdFields(pwd string) (map[string]interface{}, error) {
+	h, err := utils.HashPassword(pwd)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"pwd_hash":       utils.SensitiveString(h),
+		"md5_pwd":        "",
+		"pwd_changed_at": time.Now().UnixMilli(),
+	}, nil
+}
+
+// RehashPwd 使用默认算法重新计算密码哈希，密码本身未变，不记录历史也不更新修改时间
+func RehashPwd(ctx context.Context, id int64, pwd string) error {
+	h, err := utils.HashPassword(pwd)
+	if err != nil {
+		return err
+	}
+	defer InvalidateUserCache(id)
+	return getDb(ctx).Model(&User{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"pwd_hash": utils.SensitiveString(h),
+		"md5_pwd":  "",
+	}).Error
+}
+
+func privateKeyAAD(id int64) string {
+	return fmt.Sprintf("users.private_key:%d", id)
+}
+
+// PrivateKeyFields 生成设置私钥所需的字段，私钥加密保存，为空时清除
+func PrivateKeyFields(id int64, key string) (map[string]interface{}, error) {
+	if key == "" {
+		return map[string]interface{}{"private_key": "", "private_key_fp": ""}, nil
+	}
+	sealed, err := utils.Seal([]byte(key), privateKeyAAD(id))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"private_key":    utils.SensitiveString(sealed),
+		"private_key_fp": utils.Fingerprint(key),
+	}, nil
+}
+
+// DecryptPrivateKey 解密私钥
+func (u User) DecryptPrivateKey() (string, error) {
+	if u.PrivateKey == "" {
+		return "", nil
+	}
+	key, err := utils.Open(u.PrivateKey, privateKeyAAD(u.Id))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+func totpSecretAAD(id int64) string {
+	return fmt.Sprintf("users.totp_secret:%d", id)
+}
+
+// TotpSecretFields 生成设置 TOTP 密钥所需的字段，密钥加密保存，为空时清除
+func TotpSecretFields(id int64, secret string) (map[string]interface{}, error) {
+	if secret == "" {
+		return map[string]interface{}{"totp_secret": ""}, nil
+	}
+	sealed, err := utils.Seal([]byte(secret), totpSecretAAD(id))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"totp_secret": utils.SensitiveString(sealed)}, nil
+}
+
+// DecryptTotpSecret 解密 TOTP 密钥
+func (u User) DecryptTotpSecret() (string, error) {
+	if u.TotpSecret == "" {
+		return "", nil
+	}
+	secret, err := utils.Open(u.TotpSecret, totpSecretAAD(u.Id))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// EncryptPrivateKeys 加密历史明文私钥，主密钥轮换后使用新主密钥重新加密数据密钥，返回更新的行数
+func EncryptPrivateKeys(ctx context.Context) (int, error) {
+	return sealUserColumn(ctx, "private_key", func(u *User) string { return u.PrivateKey }, PrivateKeyFields)
+}
+
+// EncryptTotpSecrets 加密历史明文 TOTP 密钥，主密钥轮换后重新加密数据密钥，返回更新的行数
+func EncryptTotpSecrets(ctx context.Context) (int, error) {
+	return sealUserColumn(ctx, "totp_secret", func(u *User) string { return u.TotpSecret }, TotpSecretFields)
+}
+
+// sealUserColumn 加密用户表中的明文敏感字段，已加密的使用当前主密钥重新加密数据密钥
+func sealUserColumn(ctx context.Context, column string, get func(u *User) string,
+	fieldsOf func(id int64, plain string) (map[string]interface{}, error)) (int, error) {
+	var users []*User
+	err := getDb(ctx).Select("id", column).Where(column + " != ''").Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, u := range users {
+		var fields map[string]interface{}
+		if value := get(u); utils.IsSealed(value) {
+			sealed, changed, err := utils.Rewrap(value)
+			if err != nil {
+				return updated, fmt.Errorf("rewrap %s of user %d: %w", column, u.Id, err)
+			}
+			if !changed {
+				continue
+			}
+			fields = map[string]interface{}{column: utils.SensitiveString(sealed)}
+		} else if fields, err = fieldsOf(u.Id, value); err != nil {
+			return updated, err
+		}
+		if err := getDb(ctx).Model(&User{}).Where("id = ?", u.Id).UpdateColumns(fields).Error; err != nil {
+			return updated, err
+		}
+		InvalidateUserCache(u.Id)
+		updated++
+	}
+	return updated, nil
+}
+
+// migrateLegacyPwd 将历史 MD5 密码迁移到 pwd_hash，登录时再升级为新算法
+func migrateLegacyPwd() error {
+	return db.Model(&User{}).
+		Where("(pwd_hash IS NULL OR pwd_hash = '') AND md5_pwd != ''").
+		UpdateColumns(map[string]interface{}{
+			"pwd_hash": gorm.Expr("md5_pwd"),
+			"md5_pwd":  "",
+		}).Error
+}
+
 // CreateUser 创建用户
 func CreateUser(ctx context.Context, user *User) error {
 	return getDb(ctx).Create(user).Error
@@ -58,6 +237,19 @@ func GetUserByAccount(ctx context.Context, account string) (*User, error) {
 	return &user, nil
 }
 
+// GetUsersByAccounts 根据账号批量查询用户
+func GetUsersByAccounts(ctx context.Context, accounts []string) ([]*User, error) {
+	if len(accounts) == 0 {
+		return nil, nil
+	}
+	var users []*User
+	err := getDb(ctx).Where("account IN ?", accounts).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // GetUserList 查询用户列表
 func GetUserList(ctx context.Context, offset, limit int) ([]*User, error) {
 	var users []*User
@@ -68,19 +260,105 @@ func GetUserList(ctx context.Context, offset, limit int) ([]*User, error) {
 	return users, nil
 }
 
+// UserFilter 用户筛选条件
+type UserFilter struct {
+	DepartmentIds []int64 // 所属部门，为 nil 时不限
+}
+
+// applyFilter 应用筛选条件
+func (f UserFilter) applyFilter(db *gorm.DB) *gorm.DB {
+	if f.DepartmentIds != nil {
+		db = db.Where("department_id IN ?", f.DepartmentIds)
+	}
+	return db
+}
+
+// GetUserListWithFilter 带筛选条件的用户列表
+func GetUserListWithFilter(ctx context.Context, filter UserFilter, offset, limit int) ([]*User, error) {
+	var users []*User
+	err := filter.applyFilter(getDb(ctx)).Offset(offset).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CountUsersWithFilter 带筛选条件的用户数量
+func CountUsersWithFilter(ctx context.Context, filter UserFilter) (int64, error) {
+	var count int64
+	err := filter.applyFilter(getDb(ctx)).Model(&User{}).Count(&count).Error
+	return count, err
+}
+
 // UpdateUser 更新用户
 func UpdateUser(ctx context.Context, user *User) error {
+	defer InvalidateUserCache(user.Id)
 	return getDb(ctx).Save(user).Error
 }
 
-// UpdateUserFields 更新用户指定字段
+// UpdateUserFields 更新用户指定字段，修改密码使用 UpdateUserPwd
 func UpdateUserFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	defer InvalidateUserCache(id)
 	return getDb(ctx).Model(&User{}).Where("id = ?", id).Updates(fields).Error
 }
 
-// DeleteUser 删除用户
+// UpdateUserPwd 更新包含新密码的字段，并把旧密码记入历史，历史只保留最近 keepHistory 条
+func UpdateUserPwd(ctx context.Context, id int64, fields map[string]interface{}, keepHistory int) error {
+	defer InvalidateUserCache(id)
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := savePasswordHistory(tx, id, keepHistory); err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", id).Updates(fields).Error
+	})
+}
+
+// UseTotpStep 记录已使用的验证码周期，周期不大于上次记录时返回 false，防止验证码重放
+func UseTotpStep(ctx context.Context, id int64, step int64) (bool, error) {
+	defer InvalidateUserCache(id)
+	result := getDb(ctx).Model(&User{}).Where("id = ? AND totp_last_step < ?", id, step).
+		UpdateColumn("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetActiveUsersBySource 查询指定来源的未停用用户
+func GetActiveUsersBySource(ctx context.Context, source string) ([]*User, error) {
+	var users []*User
+	err := getDb(ctx).Where("source = ? AND disabled = ?", source, false).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DisableUsers 批量停用用户
+func DisableUsers(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	defer InvalidateUserCache(ids...)
+	return getDb(ctx).Model(&User{}).Where("id IN ?", ids).Update("disabled", true).Error
+}
+
+// DeleteUser 删除用户及其历史密码和角色
 func DeleteUser(ctx context.Context, id int64) error {
-	return getDb(ctx).Where("id = ?", id).Delete(&User{}).Error
+	defer InvalidateUserCache(id)
+	return getDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&PasswordHistory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&CouponGrant{}).Error; err != nil {
+			return err
+		}
+		// 被删除的用户不再担任部门负责人
+		if err := tx.Model(&Department{}).Where("head_id = ?", id).Update("head_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&User{}).Error
+	})
 }
 
 // CountUsers 统计用户总数
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 用户缓存有效期，用户变更时会主动失效，过期只是兜底
+const userCacheTTL = time.Minute
+
+type userCacheEntry struct {
+	user     User
+	expireAt time.Time
+}
+
+type accessCacheEntry struct {
+	access   UserAccess
+	expireAt time.Time
+}
+
+var (
+	userCache   sync.Map // map[int64]*userCacheEntry
+	accessCache sync.Map // map[int64]*accessCacheEntry
+)
+
+// GetCachedUser 获取用户的当前状态，优先读取缓存，用于鉴权
+func GetCachedUser(ctx context.Context, id int64) (*User, error) {
+	if v, ok := userCache.Load(id); ok {
+		entry := v.(*userCacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			u := entry.user
+			return &u, nil
+		}
+	}
+	user, err := GetUserById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	userCache.Store(id, &userCacheEntry{
+		user:     *user,
+		expireAt: time.Now().Add(userCacheTTL),
+	})
+	return user, nil
+}
+
+// GetCachedUserAccess 获取用户当前的角色和权限，优先读取缓存，用于鉴权；返回值不能修改
+func GetCachedUserAccess(ctx context.Context, id int64) (*UserAccess, error) {
+	if v, ok := accessCache.Load(id); ok {
+		entry := v.(*accessCacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			a := entry.access
+			return &a, nil
+		}
+	}
+	access, err := GetUserAccess(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	accessCache.Store(id, &accessCacheEntry{
+		access:   *access,
+		expireAt: time.Now().Add(userCacheTTL),
+	})
+	return access, nil
+}
+
+// InvalidateUserCache 使用户缓存失效
+func InvalidateUserCache(ids ...int64) {
+	for _, id := range ids {
+		userCache.Delete(id)
+		accessCache.Delete(id)
+	}
+}
+
+// invalidateAllUserAccess 角色变更后使所有用户的权限缓存失效
+func invalidateAllUserAccess() {
+	accessCache.Range(func(key, _ interface{}) bool {
+		accessCache.Delete(key)
+		return true
+	})
+}
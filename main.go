@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"pionex-administrative-sys/server"
+	"pionex-administrative-sys/utils"
 	"pionex-administrative-sys/utils/app"
 	"pionex-administrative-sys/utils/app/daemon"
 	"pionex-administrative-sys/utils/logger"
@@ -21,6 +22,15 @@ func main() {
 		fmt.Println(logger.Sync())
 	}()
 	app.Parse()
+	if app.RotateKey() {
+		conf := app.Conf().JWT
+		kid, err := utils.RotateJWTKeyFile(conf.KeyFilePath(app.Home()), conf.Alg)
+		if err != nil {
+			logger.Fatal("Failed to rotate jwt key", zap.Error(err))
+		}
+		logger.Info("jwt key rotated, restart server to take effect", zap.String("kid", kid))
+		return
+	}
 	if app.Daemon() {
 		d, err := daemon.Daemon()
 		if err != nil {
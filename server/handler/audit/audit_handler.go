@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Register 注册路由
+func Register(r gin.IRouter) {
+	g := r.Group("/audit")
+
+	// 需要查看审计日志权限
+	g.Use(middleware.Auth(), middleware.RequirePermission(db.PermAuditView))
+
+	g.GET("/events", listHandler)
+	g.GET("/verify", verifyHandler)
+}
+
+// EventItem 审计日志列表项
+type EventItem struct {
+	Id         int64  `json:"id"`
+	ActorId    int64  `json:"actor_id"`
+	Actor      string `json:"actor"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetId   string `json:"target_id"`
+	Before     string `json:"before"` // 变更前有变化的字段，JSON
+	After      string `json:"after"`  // 变更后有变化的字段，JSON
+	IP         string `json:"ip"`
+	RequestId  string `json:"request_id"`
+	Hash       string `json:"hash"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// listHandler 审计日志列表，支持按操作人、动作、对象和时间范围（毫秒，start 含 end 不含）筛选
+func listHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	filter := db.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetId:   c.Query("target_id"),
+	}
+	if s := c.Query("actor_id"); s != "" {
+		actorId, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的 actor_id"}).Fail(c)
+			return
+		}
+		filter.ActorId = &actorId
+	}
+	for _, t := range []struct {
+		key string
+		dst *int64
+	}{{"start", &filter.Start}, {"end", &filter.End}} {
+		if s := c.Query(t.key); s != "" {
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				utils.Resp(400, "参数错误", gin.H{"error": "无效的 " + t.key}).Fail(c)
+				return
+			}
+			*t.dst = v
+		}
+	}
+
+	ctx := c.Request.Context()
+	events, err := db.GetAuditEvents(ctx, filter, (page-1)*size, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	total, err := db.CountAuditEvents(ctx, filter)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	list := make([]EventItem, 0, len(events))
+	for _, e := range events {
+		list = append(list, EventItem{
+			Id:         e.Id,
+			ActorId:    e.ActorId,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			TargetType: e.TargetType,
+			TargetId:   e.TargetId,
+			Before:     e.Before,
+			After:      e.After,
+			IP:         e.IP,
+			RequestId:  e.RequestId,
+			Hash:       e.Hash,
+			CreatedAt:  e.CreatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":  list,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// verifyHandler 校验审计日志哈希链是否完整
+func verifyHandler(c *gin.Context) {
+	res, err := db.VerifyAuditChain(c.Request.Context())
+	if err != nil {
+		utils.Resp(500, "校验失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	utils.Resp(0, "success", res).Success(c)
+}
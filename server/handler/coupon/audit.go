@@ -0,0 +1,32 @@
+package coupon
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils"
+)
+
+// couponSnapshot 审计日志中的卡券快照，卡券码只记录指纹
+type couponSnapshot struct {
+	CouponFp   string `json:"coupon_fp"`
+	Type       int    `json:"type"`
+	Creator    int64  `json:"creator"`
+	Taker      int64  `json:"taker"`
+	State      string `json:"state"`
+	ValidFrom  int64  `json:"valid_from,omitempty"`
+	ValidUntil int64  `json:"valid_until,omitempty"`
+}
+
+func snapshotCoupon(c *db.Coupon) *couponSnapshot {
+	if c == nil {
+		return nil
+	}
+	return &couponSnapshot{
+		CouponFp:   utils.Fingerprint(c.Coupon),
+		Type:       c.Type,
+		Creator:    c.Creator,
+		Taker:      c.Taker,
+		State:      c.State,
+		ValidFrom:  c.ValidFrom,
+		ValidUntil: c.ValidUntil,
+	}
+}
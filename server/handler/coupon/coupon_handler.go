@@ -1,11 +1,14 @@
 package coupon
 
 import (
+	"errors"
 	"pionex-administrative-sys/db"
 	"pionex-administrative-sys/server/middleware"
 	"pionex-administrative-sys/utils"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,60 +20,104 @@ func Register(r gin.IRouter) {
 	// 需要登录权限
 	g.Use(middleware.Auth())
 
-	// 获取卡券类型列表（不需要特殊权限）
+	// 获取启用的卡券类型列表（不需要特殊权限）
 	g.GET("/types", typesHandler)
 
-	// 需要库存管理权限
-	g.Use(middleware.RequireRole(db.RoleStock))
-
-	g.POST("/add", addHandler)
-	g.POST("/import", importHandler)
-	g.GET("/list", listHandler)
-	g.GET("/detail/:id", detailHandler)
-	g.PUT("/update", updateHandler)
-	g.DELETE("/delete/:id", deleteHandler)
+	// 管理卡券类型
+	typePerm := middleware.RequirePermission(db.PermCouponTypeManage)
+	g.GET("/types/list", typePerm, typeListHandler)
+	g.POST("/types/add", typePerm, addTypeHandler)
+	g.PUT("/types/update", typePerm, updateTypeHandler)
+	g.DELETE("/types/delete/:type", typePerm, deleteTypeHandler)
+
+	// 管理领取配额策略
+	g.GET("/quota/list", typePerm, quotaListHandler)
+	g.POST("/quota/add", typePerm, addQuotaHandler)
+	g.PUT("/quota/update", typePerm, updateQuotaHandler)
+	g.DELETE("/quota/delete/:id", typePerm, deleteQuotaHandler)
+
+	// 按操作校验卡券管理权限，只有部分类型授权时在接口中校验具体类型
+	perm := middleware.RequireCouponPermission
+	g.POST("/add", perm(db.PermCouponCreate), addHandler)
+	g.POST("/import", perm(db.PermCouponImport), importHandler)
+	g.GET("/list", perm(db.PermCouponView), listHandler)
+	g.GET("/detail/:id", perm(db.PermCouponView), detailHandler)
+	g.PUT("/update", perm(db.PermCouponUpdate), updateHandler)
+	g.DELETE("/delete/:id", perm(db.PermCouponDelete), deleteHandler)
+	g.POST("/void", perm(db.PermCouponVoid), voidHandler)
+	g.GET("/events/:id", perm(db.PermCouponView), eventsHandler)
+
+	// 发放任务：按用户、部门或角色批量发放卡券
+	g.POST("/distribution/add", perm(db.PermCouponDistribute), addDistributionHandler)
+	g.GET("/distribution/list", perm(db.PermCouponDistribute), distributionListHandler)
+	g.GET("/distribution/detail/:id", perm(db.PermCouponDistribute), distributionDetailHandler)
+
+	// 转让审批
+	g.GET("/transfer/list", perm(db.PermCouponTransferReview), transferListHandler)
+	g.POST("/transfer/approve", perm(db.PermCouponTransferReview), approveTransferHandler)
+	g.POST("/transfer/reject", perm(db.PermCouponTransferReview), rejectTransferHandler)
 }
 
+const noTypePermMsg = "无权操作该类型卡券"
+
 // CouponItem 卡券列表项
 type CouponItem struct {
-	Id        int64  `json:"id"`
-	Coupon    string `json:"coupon"`
-	Type      int    `json:"type"`
-	TypeName  string `json:"type_name"`
-	Creator   int64  `json:"creator"`
-	Taker     int64  `json:"taker"`
-	TakerName string `json:"taker_name"` // 领取者用户名
-	IsTaken   bool   `json:"is_taken"`
-	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	Id         int64  `json:"id"`
+	Coupon     string `json:"coupon"`
+	Type       int    `json:"type"`
+	TypeName   string `json:"type_name"`
+	Creator    int64  `json:"creator"`
+	Taker      int64  `json:"taker"`
+	TakerName  string `json:"taker_name"` // 领取者用户名
+	State      string `json:"state"`      // issued/taken/used/returned/voided
+	IsTaken    bool   `json:"is_taken"`
+	ValidFrom  int64  `json:"valid_from"`  // 生效时间，为 0 表示不限制
+	ValidUntil int64  `json:"valid_until"` // 过期时间，为 0 表示长期有效
+	IsExpired  bool   `json:"is_expired"`
+	TakenAt    int64  `json:"taken_at"`
+	UsedAt     int64  `json:"used_at"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
 }
 
 func toCouponItem(c *db.Coupon, takerName string) CouponItem {
 	return CouponItem{
-		Id:        c.Id,
-		Coupon:    c.Coupon,
-		Type:      c.Type,
-		TypeName:  db.GetCouponTypeName(c.Type),
-		Creator:   c.Creator,
-		Taker:     c.Taker,
-		TakerName: takerName,
-		IsTaken:   c.IsTaken(),
-		CreatedAt: c.CreatedAt,
-		UpdatedAt: c.UpdatedAt,
+		Id:         c.Id,
+		Coupon:     c.Coupon,
+		Type:       c.Type,
+		TypeName:   db.GetCouponTypeName(c.Type),
+		Creator:    c.Creator,
+		Taker:      c.Taker,
+		TakerName:  takerName,
+		State:      c.State,
+		IsTaken:    c.IsTaken(),
+		ValidFrom:  c.ValidFrom,
+		ValidUntil: c.ValidUntil,
+		IsExpired:  c.IsExpired(time.Now().UnixMilli()),
+		TakenAt:    c.TakenAt,
+		UsedAt:     c.UsedAt,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
 	}
 }
 
-// typesHandler 获取卡券类型列表
-func typesHandler(c *gin.Context) {
-	utils.Resp(0, "success", gin.H{
-		"list": db.AllCouponTypes(),
-	}).Success(c)
+// checkValidity 校验有效期，返回错误提示
+func checkValidity(validFrom, validUntil int64) string {
+	if validFrom < 0 || validUntil < 0 {
+		return "无效的有效期"
+	}
+	if validFrom > 0 && validUntil > 0 && validUntil <= validFrom {
+		return "过期时间必须晚于生效时间"
+	}
+	return ""
 }
 
 // AddReq 添加卡券请求
 type AddReq struct {
-	Coupon string `json:"coupon" binding:"required"`
-	Type   int    `json:"type" binding:"required"`
+	Coupon     string `json:"coupon" binding:"required"`
+	Type       int    `json:"type" binding:"required"`
+	ValidFrom  int64  `json:"valid_from"`  // 生效时间（毫秒），为 0 表示不限制
+	ValidUntil int64  `json:"valid_until"` // 过期时间（毫秒），为 0 表示长期有效
 }
 
 // addHandler 添加卡券
@@ -86,6 +133,14 @@ func addHandler(c *gin.Context) {
 		utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
 		return
 	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponCreate, req.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+	if msg := checkValidity(req.ValidFrom, req.ValidUntil); msg != "" {
+		utils.Resp(400, msg, gin.H{}).Fail(c)
+		return
+	}
 
 	userId := middleware.GetCurrentClaims(c).UserId
 
@@ -96,14 +151,17 @@ func addHandler(c *gin.Context) {
 	}
 
 	coupon := &db.Coupon{
-		Coupon:  req.Coupon,
-		Type:    req.Type,
-		Creator: userId,
+		Coupon:     req.Coupon,
+		Type:       req.Type,
+		Creator:    userId,
+		ValidFrom:  req.ValidFrom,
+		ValidUntil: req.ValidUntil,
 	}
 	if err := db.CreateCoupon(c.Request.Context(), coupon); err != nil {
 		utils.Resp(500, "创建卡券失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	middleware.Audit(c, "coupon.add", db.AuditTargetCoupon, coupon.Id, nil, snapshotCoupon(coupon))
 
 	utils.Resp(0, "success", gin.H{
 		"id":     coupon.Id,
@@ -114,8 +172,10 @@ func addHandler(c *gin.Context) {
 
 // ImportReq 导入卡券请求
 type ImportReq struct {
-	Coupons string `json:"coupons" binding:"required"` // 多个卡券用换行符分隔
-	Type    int    `json:"type" binding:"required"`    // 卡券类型
+	Coupons    string `json:"coupons" binding:"required"` // 多个卡券用换行符分隔
+	Type       int    `json:"type" binding:"required"`    // 卡券类型
+	ValidFrom  int64  `json:"valid_from"`                 // 本批卡券的生效时间（毫秒）
+	ValidUntil int64  `json:"valid_until"`                // 本批卡券的过期时间（毫秒）
 }
 
 // ImportResp 导入卡券响应
@@ -139,6 +199,14 @@ func importHandler(c *gin.Context) {
 		utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
 		return
 	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponImport, req.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+	if msg := checkValidity(req.ValidFrom, req.ValidUntil); msg != "" {
+		utils.Resp(400, msg, gin.H{}).Fail(c)
+		return
+	}
 
 	userId := middleware.GetCurrentClaims(c).UserId
 
@@ -177,6 +245,7 @@ func importHandler(c *gin.Context) {
 
 	var successCount int
 	var duplicates []string
+	var createdIds []int64
 
 	// 逐个创建（检查重复）
 	for _, code := range uniqueCodes {
@@ -187,15 +256,29 @@ func importHandler(c *gin.Context) {
 		}
 
 		coupon := &db.Coupon{
-			Coupon:  code,
-			Type:    req.Type,
-			Creator: userId,
+			Coupon:     code,
+			Type:       req.Type,
+			Creator:    userId,
+			ValidFrom:  req.ValidFrom,
+			ValidUntil: req.ValidUntil,
 		}
 		if err := db.CreateCoupon(c.Request.Context(), coupon); err == nil {
 			successCount++
+			createdIds = append(createdIds, coupon.Id)
 		}
 	}
 
+	// 批量导入只记录一条审计日志
+	if successCount > 0 {
+		middleware.Audit(c, "coupon.import", db.AuditTargetCoupon, "", nil, gin.H{
+			"type":        req.Type,
+			"count":       successCount,
+			"ids":         createdIds,
+			"valid_from":  req.ValidFrom,
+			"valid_until": req.ValidUntil,
+		})
+	}
+
 	utils.Resp(0, "success", ImportResp{
 		Total:      len(uniqueCodes),
 		Success:    successCount,
@@ -226,6 +309,25 @@ func listHandler(c *gin.Context) {
 		taken := takenStr == "1"
 		filter.Taken = &taken
 	}
+	if state := c.Query("state"); state != "" {
+		if !db.IsValidCouponState(state) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的卡券状态"}).Fail(c)
+			return
+		}
+		filter.States = []string{state}
+	}
+	if expiredStr := c.Query("expired"); expiredStr != "" {
+		expired := expiredStr == "1"
+		filter.Expired = &expired
+	}
+	// 只有部分类型授权时只能查看被授权的类型
+	if types, all := middleware.AllowedCouponTypes(c, db.PermCouponView); !all {
+		if filter.Type != nil && !slices.Contains(types, *filter.Type) {
+			utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+			return
+		}
+		filter.Types = types
+	}
 
 	offset := (page - 1) * size
 	coupons, err := db.GetCouponListWithFilter(c.Request.Context(), filter, offset, size)
@@ -239,7 +341,7 @@ func listHandler(c *gin.Context) {
 	// 收集已领取卡券的领取者ID
 	var takerIds []int64
 	for _, cp := range coupons {
-		if cp.IsTaken() {
+		if cp.Taker > 0 {
 			takerIds = append(takerIds, cp.Taker)
 		}
 	}
@@ -256,7 +358,7 @@ func listHandler(c *gin.Context) {
 	list := make([]CouponItem, 0, len(coupons))
 	for _, cp := range coupons {
 		takerName := ""
-		if cp.IsTaken() {
+		if cp.Taker > 0 {
 			takerName = takerMap[cp.Taker]
 		}
 		list = append(list, toCouponItem(cp, takerName))
@@ -284,10 +386,14 @@ func detailHandler(c *gin.Context) {
 		utils.Resp(404, "卡券不存在", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponView, coupon.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
 
 	// 查询领取者信息
 	takerName := ""
-	if coupon.IsTaken() {
+	if coupon.Taker > 0 {
 		if taker, err := db.GetUserById(c.Request.Context(), coupon.Taker); err == nil {
 			takerName = taker.Name
 		}
@@ -298,9 +404,11 @@ func detailHandler(c *gin.Context) {
 
 // UpdateReq 更新卡券请求
 type UpdateReq struct {
-	Id     int64   `json:"id" binding:"required"`
-	Coupon *string `json:"coupon"`
-	Type   *int    `json:"type"`
+	Id         int64   `json:"id" binding:"required"`
+	Coupon     *string `json:"coupon"`
+	Type       *int    `json:"type"`
+	ValidFrom  *int64  `json:"valid_from"`
+	ValidUntil *int64  `json:"valid_until"`
 }
 
 // updateHandler 更新卡券
@@ -317,10 +425,14 @@ func updateHandler(c *gin.Context) {
 		utils.Resp(404, "卡券不存在", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponUpdate, existing.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
 
-	// 已被领取的卡券不能修改
-	if existing.IsTaken() {
-		utils.Resp(400, "已被领取的卡券不能修改", gin.H{}).Fail(c)
+	// 只能修改库存中的卡券
+	if !existing.InStock() {
+		utils.Resp(400, "已被领取或作废的卡券不能修改", gin.H{}).Fail(c)
 		return
 	}
 
@@ -339,8 +451,30 @@ func updateHandler(c *gin.Context) {
 			utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
 			return
 		}
+		// 修改类型需要同时拥有目标类型的权限
+		if !middleware.CanAccessCouponType(c, db.PermCouponUpdate, *req.Type) {
+			utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+			return
+		}
 		fields["type"] = *req.Type
 	}
+	if req.ValidFrom != nil || req.ValidUntil != nil {
+		validFrom, validUntil := existing.ValidFrom, existing.ValidUntil
+		if req.ValidFrom != nil {
+			validFrom = *req.ValidFrom
+		}
+		if req.ValidUntil != nil {
+			validUntil = *req.ValidUntil
+		}
+		if msg := checkValidity(validFrom, validUntil); msg != "" {
+			utils.Resp(400, msg, gin.H{}).Fail(c)
+			return
+		}
+		fields["valid_from"] = validFrom
+		fields["valid_until"] = validUntil
+		// 修改有效期后由过期任务重新判断
+		fields["expired_at"] = 0
+	}
 
 	if len(fields) == 0 {
 		utils.Resp(400, "没有要更新的字段", gin.H{}).Fail(c)
@@ -351,6 +485,8 @@ func updateHandler(c *gin.Context) {
 		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	after, _ := db.GetCouponById(c.Request.Context(), req.Id)
+	middleware.Audit(c, "coupon.update", db.AuditTargetCoupon, req.Id, snapshotCoupon(existing), snapshotCoupon(after))
 
 	utils.Resp(0, "success", gin.H{}).Success(c)
 }
@@ -370,17 +506,129 @@ func deleteHandler(c *gin.Context) {
 		utils.Resp(404, "卡券不存在", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
-
-	// 已被领取的卡券不能删除
-	if existing.IsTaken() {
-		utils.Resp(400, "已被领取的卡券不能删除", gin.H{}).Fail(c)
+	if !middleware.CanAccessCouponType(c, db.PermCouponDelete, existing.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
 		return
 	}
 
+	// 被领取或作废过的卡券需要保留记录，不能删除
 	if err := db.DeleteCoupon(c.Request.Context(), id); err != nil {
+		if errors.Is(err, db.ErrCouponHasHistory) {
+			utils.Resp(400, "已被领取或作废过的卡券不能删除", gin.H{}).Fail(c)
+			return
+		}
 		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	middleware.Audit(c, "coupon.delete", db.AuditTargetCoupon, id, snapshotCoupon(existing), nil)
 
 	utils.Resp(0, "success", gin.H{}).Success(c)
 }
+
+// VoidReq 作废卡券请求
+type VoidReq struct {
+	Id     int64  `json:"id" binding:"required"`
+	Reason string `json:"reason" binding:"max=255"` // 作废原因
+}
+
+// voidHandler 作废卡券，未使用的卡券都可以作废
+func voidHandler(c *gin.Context) {
+	var req VoidReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	existing, err := db.GetCouponById(c.Request.Context(), req.Id)
+	if err != nil {
+		utils.Resp(404, "卡券不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponVoid, existing.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+
+	userId := middleware.GetCurrentClaims(c).UserId
+	if err := db.VoidCoupon(c.Request.Context(), req.Id, userId, strings.TrimSpace(req.Reason)); err != nil {
+		if errors.Is(err, db.ErrCouponStateInvalid) {
+			utils.Resp(400, "已使用或已作废的卡券不能作废", gin.H{}).Fail(c)
+		} else if errors.Is(err, db.ErrCouponStateChanged) {
+			utils.Resp(409, "卡券状态已变化，请刷新后重试", gin.H{}).Fail(c)
+		} else {
+			utils.Resp(500, "作废失败", gin.H{"error": err.Error()}).Fail(c)
+		}
+		return
+	}
+	after, _ := db.GetCouponById(c.Request.Context(), req.Id)
+	middleware.Audit(c, "coupon.void", db.AuditTargetCoupon, req.Id, snapshotCoupon(existing), snapshotCoupon(after))
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// CouponEventItem 卡券状态变更记录
+type CouponEventItem struct {
+	Id           int64  `json:"id"`
+	FromState    string `json:"from_state"` // 入库时为空
+	ToState      string `json:"to_state"`
+	Operator     int64  `json:"operator"`
+	OperatorName string `json:"operator_name"`
+	Note         string `json:"note"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// eventsHandler 卡券状态变更记录
+func eventsHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的卡券ID"}).Fail(c)
+		return
+	}
+	coupon, err := db.GetCouponById(c.Request.Context(), id)
+	if err != nil {
+		utils.Resp(404, "卡券不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponView, coupon.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+
+	events, err := db.GetCouponEvents(c.Request.Context(), id)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	// 批量查询操作人
+	var operatorIds []int64
+	for _, e := range events {
+		if e.Operator > 0 {
+			operatorIds = append(operatorIds, e.Operator)
+		}
+	}
+	operatorMap := make(map[int64]string)
+	if len(operatorIds) > 0 {
+		users, _ := db.GetUsersByIds(c.Request.Context(), operatorIds)
+		for _, u := range users {
+			operatorMap[u.Id] = u.Name
+		}
+	}
+
+	list := make([]CouponEventItem, 0, len(events))
+	for _, e := range events {
+		list = append(list, CouponEventItem{
+			Id:           e.Id,
+			FromState:    e.FromState,
+			ToState:      e.ToState,
+			Operator:     e.Operator,
+			OperatorName: operatorMap[e.Operator],
+			Note:         e.Note,
+			CreatedAt:    e.CreatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
@@ -0,0 +1,196 @@
+package coupon
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// typesHandler 获取启用的卡券类型列表
+func typesHandler(c *gin.Context) {
+	utils.Resp(0, "success", gin.H{
+		"list": db.AllCouponTypes(),
+	}).Success(c)
+}
+
+// typeListHandler 获取所有卡券类型，包含已停用的
+func typeListHandler(c *gin.Context) {
+	utils.Resp(0, "success", gin.H{
+		"list": db.GetCouponTypes(),
+	}).Success(c)
+}
+
+// typeSnapshot 审计日志中的卡券类型快照
+func typeSnapshot(ct *db.CouponType) gin.H {
+	if ct == nil {
+		return nil
+	}
+	return gin.H{
+		"name":        ct.Name,
+		"description": ct.Description,
+		"icon":        ct.Icon,
+		"enabled":     ct.Enabled,
+		"sort_order":  ct.SortOrder,
+
+		"transferable":          ct.Transferable,
+		"transfer_approval":     ct.TransferApproval,
+		"transfer_counts_quota": ct.TransferCountsQuota,
+	}
+}
+
+// AddTypeReq 添加卡券类型请求
+type AddTypeReq struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+	Enabled     *bool  `json:"enabled"` // 不传默认启用
+	SortOrder   int    `json:"sort_order"`
+
+	Transferable        bool `json:"transferable"`          // 领取者可以转让给其他用户
+	TransferApproval    bool `json:"transfer_approval"`     // 接收人确认后还需要审批
+	TransferCountsQuota bool `json:"transfer_counts_quota"` // 接收的卡券计入接收人的领取配额
+}
+
+// addTypeHandler 添加卡券类型
+func addTypeHandler(c *gin.Context) {
+	var req AddTypeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.Resp(400, "参数错误", gin.H{"error": "类型名称不能为空"}).Fail(c)
+		return
+	}
+
+	ct := &db.CouponType{
+		Name:        req.Name,
+		Description: req.Description,
+		Icon:        req.Icon,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		SortOrder:   req.SortOrder,
+
+		Transferable:        req.Transferable,
+		TransferApproval:    req.TransferApproval,
+		TransferCountsQuota: req.TransferCountsQuota,
+	}
+	if err := db.CreateCouponType(c.Request.Context(), ct); err != nil {
+		if errors.Is(err, db.ErrCouponTypeNameExists) {
+			utils.Resp(400, "类型名称已存在", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "创建卡券类型失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "coupon_type.add", db.AuditTargetCouponType, ct.Type, nil, typeSnapshot(ct))
+
+	utils.Resp(0, "success", gin.H{
+		"type": ct.Type,
+	}).Success(c)
+}
+
+// UpdateTypeReq 更新卡券类型请求
+type UpdateTypeReq struct {
+	Type        int     `json:"type" binding:"required"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Icon        *string `json:"icon"`
+	Enabled     *bool   `json:"enabled"` // 停用后不能添加、导入和申领，已有卡券不受影响
+	SortOrder   *int    `json:"sort_order"`
+
+	Transferable        *bool `json:"transferable"`
+	TransferApproval    *bool `json:"transfer_approval"` // 修改后对尚未确认的转让生效
+	TransferCountsQuota *bool `json:"transfer_counts_quota"`
+}
+
+// updateTypeHandler 更新卡券类型
+func updateTypeHandler(c *gin.Context) {
+	var req UpdateTypeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	existing, ok := db.GetCouponTypeById(req.Type)
+	if !ok {
+		utils.Resp(404, "卡券类型不存在", gin.H{}).Fail(c)
+		return
+	}
+
+	fields := make(map[string]interface{})
+	if req.Name != nil && strings.TrimSpace(*req.Name) != "" {
+		fields["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		fields["description"] = *req.Description
+	}
+	if req.Icon != nil {
+		fields["icon"] = *req.Icon
+	}
+	if req.Enabled != nil {
+		fields["enabled"] = *req.Enabled
+	}
+	if req.SortOrder != nil {
+		fields["sort_order"] = *req.SortOrder
+	}
+	if req.Transferable != nil {
+		fields["transferable"] = *req.Transferable
+	}
+	if req.TransferApproval != nil {
+		fields["transfer_approval"] = *req.TransferApproval
+	}
+	if req.TransferCountsQuota != nil {
+		fields["transfer_counts_quota"] = *req.TransferCountsQuota
+	}
+
+	if len(fields) == 0 {
+		utils.Resp(400, "没有要更新的字段", gin.H{}).Fail(c)
+		return
+	}
+
+	if err := db.UpdateCouponTypeFields(c.Request.Context(), req.Type, fields); err != nil {
+		if errors.Is(err, db.ErrCouponTypeNameExists) {
+			utils.Resp(400, "类型名称已存在", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	after, _ := db.GetCouponTypeById(req.Type)
+	middleware.Audit(c, "coupon_type.update", db.AuditTargetCouponType, req.Type, typeSnapshot(&existing), typeSnapshot(&after))
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// deleteTypeHandler 删除卡券类型，已有卡券的类型只能停用
+func deleteTypeHandler(c *gin.Context) {
+	t, err := strconv.Atoi(c.Param("type"))
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的卡券类型"}).Fail(c)
+		return
+	}
+
+	existing, ok := db.GetCouponTypeById(t)
+	if !ok {
+		utils.Resp(404, "卡券类型不存在", gin.H{}).Fail(c)
+		return
+	}
+
+	if err := db.DeleteCouponType(c.Request.Context(), t); err != nil {
+		if errors.Is(err, db.ErrCouponTypeInUse) {
+			utils.Resp(400, "该类型已有卡券或授权，不能删除，可以停用", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "coupon_type.delete", db.AuditTargetCouponType, t, typeSnapshot(&existing), nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
@@ -0,0 +1,41 @@
+package coupon
+
+import (
+	"context"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils/logger"
+
+	"go.uber.org/zap"
+)
+
+// distributionNotify 创建发放任务后唤醒后台任务
+var distributionNotify = make(chan struct{}, 1)
+
+// StartDistributionJob 按创建顺序执行未完成的发放任务，包括重启前中断的任务，ctx 取消时退出
+func StartDistributionJob(ctx context.Context) {
+	go func() {
+		for {
+			ids, err := db.GetRunningCouponDistributionIds(ctx)
+			if err != nil {
+				logger.Error("query coupon distributions failed", zap.Error(err))
+			}
+			for _, id := range ids {
+				if err := db.RunCouponDistribution(ctx, id); err != nil && ctx.Err() == nil {
+					logger.Error("coupon distribution failed", zap.Int64("id", id), zap.Error(err))
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-distributionNotify:
+			}
+		}
+	}()
+}
+
+func notifyDistribution() {
+	select {
+	case distributionNotify <- struct{}{}:
+	default:
+	}
+}
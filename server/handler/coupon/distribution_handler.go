@@ -0,0 +1,370 @@
+package coupon
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 每人最多发放数量
+const maxDistributionPerUser = 100
+
+// DistributionReq 创建发放任务请求
+type DistributionReq struct {
+	Type       int      `json:"type" binding:"required"`
+	TargetType string   `json:"target_type" binding:"required"` // users/department/role
+	TargetId   int64    `json:"target_id"`                      // 部门或角色 ID
+	UserIds    []int64  `json:"user_ids"`                       // target_type 为 users 时和 accounts 合并，按顺序发放
+	Accounts   []string `json:"accounts"`                       // 上传的账号列表
+	PerUser    int      `json:"per_user"`                       // 每人发放数量，默认 1
+	Note       string   `json:"note"`
+	DryRun     bool     `json:"dry_run"` // 只预估发放结果，不创建任务
+}
+
+// DistributionItem 发放任务列表项
+type DistributionItem struct {
+	Id           int64  `json:"id"`
+	Type         int    `json:"type"`
+	TypeName     string `json:"type_name"`
+	TargetType   string `json:"target_type"`
+	TargetId     int64  `json:"target_id"`
+	PerUser      int    `json:"per_user"`
+	Note         string `json:"note"`
+	Status       string `json:"status"`
+	UserCount    int    `json:"user_count"`
+	Processed    int    `json:"processed"` // 已处理的用户数
+	Assigned     int    `json:"assigned"`
+	Shortfall    int    `json:"shortfall"` // 库存不足未发放的数量
+	Error        string `json:"error"`
+	Operator     int64  `json:"operator"`
+	OperatorName string `json:"operator_name"`
+	CreatedAt    int64  `json:"created_at"`
+	FinishedAt   int64  `json:"finished_at"`
+}
+
+func toDistributionItem(d *db.CouponDistribution, operatorName string) DistributionItem {
+	return DistributionItem{
+		Id:           d.Id,
+		Type:         d.CouponType,
+		TypeName:     db.GetCouponTypeName(d.CouponType),
+		TargetType:   d.TargetType,
+		TargetId:     d.TargetId,
+		PerUser:      d.PerUser,
+		Note:         d.Note,
+		Status:       d.Status,
+		UserCount:    d.UserCount,
+		Processed:    d.Processed,
+		Assigned:     d.Assigned,
+		Shortfall:    d.Shortfall,
+		Error:        d.Error,
+		Operator:     d.Operator,
+		OperatorName: operatorName,
+		CreatedAt:    d.CreatedAt,
+		FinishedAt:   d.FinishedAt,
+	}
+}
+
+// DistributionUserItem 发放任务中每个用户的发放结果
+type DistributionUserItem struct {
+	UserId    int64   `json:"user_id"`
+	Name      string  `json:"name"`
+	Account   string  `json:"account"`
+	Requested int     `json:"requested"`
+	Assigned  int     `json:"assigned"`
+	CouponIds []int64 `json:"coupon_ids"`
+	Done      bool    `json:"done"`
+}
+
+// addDistributionHandler 创建发放任务，dry_run 时只返回按当前库存预估的结果
+func addDistributionHandler(c *gin.Context) {
+	var req DistributionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !db.IsValidCouponType(req.Type) {
+		utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
+		return
+	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponDistribute, req.Type) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+	if req.PerUser == 0 {
+		req.PerUser = 1
+	}
+	if req.PerUser < 0 || req.PerUser > maxDistributionPerUser {
+		utils.Resp(400, "参数错误", gin.H{"error": "每人发放数量应在 1-" + strconv.Itoa(maxDistributionPerUser) + " 之间"}).Fail(c)
+		return
+	}
+
+	userIds, disabled, ok := resolveDistributionUsers(c, &req)
+	if !ok {
+		return
+	}
+	if len(userIds) == 0 {
+		utils.Resp(400, "没有可发放的用户", gin.H{"disabled": disabled}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	p, err := db.PreviewCouponDistribution(ctx, req.Type, userIds, req.PerUser)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	preview := gin.H{
+		"users":          p.Users,
+		"disabled":       disabled, // 已停用不发放的用户数
+		"per_user":       req.PerUser,
+		"requested":      p.Requested,
+		"stock":          p.Stock,
+		"shortfall":      p.Shortfall,
+		"short_user_ids": p.ShortUserIds,
+	}
+	if req.DryRun {
+		utils.Resp(0, "success", gin.H{
+			"preview": preview,
+		}).Success(c)
+		return
+	}
+
+	d := &db.CouponDistribution{
+		CouponType: req.Type,
+		TargetType: req.TargetType,
+		TargetId:   req.TargetId,
+		PerUser:    req.PerUser,
+		Note:       req.Note,
+		Operator:   middleware.GetCurrentClaims(c).UserId,
+	}
+	if err := db.CreateCouponDistribution(ctx, d, userIds); err != nil {
+		utils.Resp(500, "创建发放任务失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "coupon.distribute", db.AuditTargetDistribution, d.Id, nil, gin.H{
+		"type":        d.CouponType,
+		"target_type": d.TargetType,
+		"target_id":   d.TargetId,
+		"per_user":    d.PerUser,
+		"user_count":  d.UserCount,
+		"note":        d.Note,
+	})
+	notifyDistribution()
+
+	utils.Resp(0, "success", gin.H{
+		"id":      d.Id,
+		"preview": preview,
+	}).Success(c)
+}
+
+// resolveDistributionUsers 解析发放对象，跳过已停用的用户，返回用户 ID 和跳过的数量
+func resolveDistributionUsers(c *gin.Context, req *DistributionReq) ([]int64, int, bool) {
+	ctx := c.Request.Context()
+	var users []*db.User
+	switch req.TargetType {
+	case db.DistributionTargetUsers:
+		req.TargetId = 0
+		ids := append([]int64(nil), req.UserIds...)
+		var accounts []string
+		for _, a := range req.Accounts {
+			if a = strings.TrimSpace(a); a != "" {
+				accounts = append(accounts, a)
+			}
+		}
+		if len(accounts) > 0 {
+			found, err := db.GetUsersByAccounts(ctx, accounts)
+			if err != nil {
+				utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+				return nil, 0, false
+			}
+			byAccount := make(map[string]int64, len(found))
+			for _, u := range found {
+				byAccount[u.Account] = u.Id
+			}
+			var missing []string
+			for _, a := range accounts {
+				if id, ok := byAccount[a]; ok {
+					ids = append(ids, id)
+				} else {
+					missing = append(missing, a)
+				}
+			}
+			if len(missing) > 0 {
+				utils.Resp(400, "账号不存在", gin.H{"accounts": missing}).Fail(c)
+				return nil, 0, false
+			}
+		}
+
+		// 去重并保持顺序，库存不足时先发给靠前的用户
+		seen := make(map[int64]bool, len(ids))
+		unique := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				unique = append(unique, id)
+			}
+		}
+		found, err := db.GetUsersByIds(ctx, unique)
+		if err != nil {
+			utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+			return nil, 0, false
+		}
+		byId := make(map[int64]*db.User, len(found))
+		for _, u := range found {
+			byId[u.Id] = u
+		}
+		var missing []int64
+		for _, id := range unique {
+			if u, ok := byId[id]; ok {
+				users = append(users, u)
+			} else {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			utils.Resp(400, "用户不存在", gin.H{"user_ids": missing}).Fail(c)
+			return nil, 0, false
+		}
+	case db.DistributionTargetDepartment, db.DistributionTargetRole:
+		if req.TargetType == db.DistributionTargetDepartment {
+			if _, err := db.GetDepartmentById(ctx, req.TargetId); err != nil {
+				utils.Resp(400, "部门不存在", gin.H{}).Fail(c)
+				return nil, 0, false
+			}
+		} else if _, err := db.GetRoleById(ctx, req.TargetId); err != nil {
+			utils.Resp(400, "角色不存在", gin.H{}).Fail(c)
+			return nil, 0, false
+		}
+		var err error
+		users, err = db.GetDistributionTargetUsers(ctx, req.TargetType, req.TargetId)
+		if err != nil {
+			utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+			return nil, 0, false
+		}
+	default:
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的发放对象: " + req.TargetType}).Fail(c)
+		return nil, 0, false
+	}
+
+	userIds := make([]int64, 0, len(users))
+	disabled := 0
+	for _, u := range users {
+		if u.Disabled {
+			disabled++
+			continue
+		}
+		userIds = append(userIds, u.Id)
+	}
+	return userIds, disabled, true
+}
+
+// distributionListHandler 发放任务列表，只返回有发放权限的类型
+func distributionListHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+	var types []int
+	if allowed, all := middleware.AllowedCouponTypes(c, db.PermCouponDistribute); !all {
+		types = allowed
+	}
+
+	list, total, err := db.GetCouponDistributions(c.Request.Context(), types, (page-1)*size, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	// 批量查询操作人
+	var operatorIds []int64
+	for _, d := range list {
+		operatorIds = append(operatorIds, d.Operator)
+	}
+	operatorMap := make(map[int64]string)
+	if len(operatorIds) > 0 {
+		users, _ := db.GetUsersByIds(c.Request.Context(), operatorIds)
+		for _, u := range users {
+			operatorMap[u.Id] = u.Name
+		}
+	}
+
+	items := make([]DistributionItem, 0, len(list))
+	for _, d := range list {
+		items = append(items, toDistributionItem(d, operatorMap[d.Operator]))
+	}
+	utils.Resp(0, "success", gin.H{
+		"list":  items,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// distributionDetailHandler 发放任务详情，包含每个用户的发放结果
+func distributionDetailHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的任务ID"}).Fail(c)
+		return
+	}
+	ctx := c.Request.Context()
+	d, err := db.GetCouponDistributionById(ctx, id)
+	if err != nil {
+		utils.Resp(404, "发放任务不存在", gin.H{}).Fail(c)
+		return
+	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponDistribute, d.CouponType) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+
+	records, err := db.GetCouponDistributionItems(ctx, id)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	userIds := []int64{d.Operator}
+	for _, r := range records {
+		userIds = append(userIds, r.UserId)
+	}
+	users, _ := db.GetUsersByIds(ctx, userIds)
+	userMap := make(map[int64]*db.User, len(users))
+	for _, u := range users {
+		userMap[u.Id] = u
+	}
+
+	items := make([]DistributionUserItem, 0, len(records))
+	for _, r := range records {
+		item := DistributionUserItem{
+			UserId:    r.UserId,
+			Requested: r.Requested,
+			Assigned:  r.Assigned,
+			CouponIds: r.CouponIds,
+			Done:      r.Done,
+		}
+		if item.CouponIds == nil {
+			item.CouponIds = []int64{}
+		}
+		if u, ok := userMap[r.UserId]; ok {
+			item.Name, item.Account = u.Name, u.Account
+		}
+		items = append(items, item)
+	}
+
+	operatorName := ""
+	if u, ok := userMap[d.Operator]; ok {
+		operatorName = u.Name
+	}
+	utils.Resp(0, "success", gin.H{
+		"distribution": toDistributionItem(d, operatorName),
+		"items":        items,
+	}).Success(c)
+}
@@ -0,0 +1,33 @@
+package coupon
+
+import (
+	"context"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils/app"
+	"pionex-administrative-sys/utils/logger"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StartExpiryJob 周期标记已过期但未领取的卡券，ctx 取消时退出
+func StartExpiryJob(ctx context.Context) {
+	interval := app.Conf().Coupon.ExpiryCheckInterval()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := db.ExpireCoupons(ctx, time.Now().UnixMilli())
+			if err != nil {
+				logger.Error("expire coupons failed", zap.Error(err))
+			} else if n > 0 {
+				logger.Info("coupons expired", zap.Int64("count", n))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
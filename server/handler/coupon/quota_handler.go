@@ -0,0 +1,196 @@
+package coupon
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaItem 配额策略列表项
+type QuotaItem struct {
+	Id            int64  `json:"id"`
+	CouponType    int    `json:"coupon_type"`
+	TypeName      string `json:"type_name"`
+	Scope         string `json:"scope"`
+	ScopeId       int64  `json:"scope_id"`
+	Period        string `json:"period"`
+	WindowMinutes int    `json:"window_minutes"`
+	Limit         int    `json:"limit"`
+	Timezone      string `json:"timezone"`
+	Description   string `json:"description"` // 规则的可读描述
+	UpdatedAt     int64  `json:"updated_at"`
+}
+
+func toQuotaItem(p *db.QuotaPolicy) QuotaItem {
+	item := QuotaItem{
+		Id:            p.Id,
+		CouponType:    p.CouponType,
+		TypeName:      db.GetCouponTypeName(p.CouponType),
+		Scope:         p.Scope,
+		ScopeId:       p.ScopeId,
+		Period:        p.Period,
+		WindowMinutes: p.WindowMinutes,
+		Limit:         p.Limit,
+		Timezone:      p.Timezone,
+		UpdatedAt:     p.UpdatedAt,
+	}
+	if r, err := p.Rule(); err == nil {
+		item.Description = r.Describe()
+	}
+	return item
+}
+
+// quotaSnapshot 审计日志中的配额策略快照
+func quotaSnapshot(p *db.QuotaPolicy) gin.H {
+	if p == nil {
+		return nil
+	}
+	return gin.H{
+		"coupon_type":    p.CouponType,
+		"scope":          p.Scope,
+		"scope_id":       p.ScopeId,
+		"period":         p.Period,
+		"window_minutes": p.WindowMinutes,
+		"limit":          p.Limit,
+		"timezone":       p.Timezone,
+	}
+}
+
+// quotaListHandler 配额策略列表，可按 type 筛选
+func quotaListHandler(c *gin.Context) {
+	couponType, _ := strconv.Atoi(c.Query("type"))
+	policies, err := db.GetQuotaPolicies(c.Request.Context(), couponType)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	list := make([]QuotaItem, 0, len(policies))
+	for _, p := range policies {
+		list = append(list, toQuotaItem(p))
+	}
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
+
+// QuotaReq 添加、更新配额策略请求
+type QuotaReq struct {
+	Id            int64  `json:"id"` // 更新时必填
+	CouponType    int    `json:"coupon_type" binding:"required"`
+	Scope         string `json:"scope"`    // default/department/role，默认为 default
+	ScopeId       int64  `json:"scope_id"` // 部门或角色 ID
+	Period        string `json:"period" binding:"required"`
+	WindowMinutes int    `json:"window_minutes"` // 滚动窗口长度（分钟），period 为 rolling 时必填
+	Limit         int    `json:"limit"`
+	Timezone      string `json:"timezone"` // 如 Asia/Shanghai，为空时使用服务器时区
+}
+
+// bindQuotaPolicy 校验请求并填充配额策略
+func bindQuotaPolicy(c *gin.Context, req *QuotaReq, p *db.QuotaPolicy) bool {
+	if _, ok := db.GetCouponTypeById(req.CouponType); !ok {
+		utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
+		return false
+	}
+	ctx := c.Request.Context()
+	switch req.Scope {
+	case "", db.QuotaScopeDefault:
+		req.Scope, req.ScopeId = db.QuotaScopeDefault, 0
+	case db.QuotaScopeDepartment:
+		if _, err := db.GetDepartmentById(ctx, req.ScopeId); err != nil {
+			utils.Resp(400, "部门不存在", gin.H{}).Fail(c)
+			return false
+		}
+	case db.QuotaScopeRole:
+		if _, err := db.GetRoleById(ctx, req.ScopeId); err != nil {
+			utils.Resp(400, "角色不存在", gin.H{}).Fail(c)
+			return false
+		}
+	default:
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的适用范围: " + req.Scope}).Fail(c)
+		return false
+	}
+
+	p.CouponType = req.CouponType
+	p.Scope = req.Scope
+	p.ScopeId = req.ScopeId
+	p.Period = req.Period
+	p.WindowMinutes = req.WindowMinutes
+	p.Limit = req.Limit
+	p.Timezone = req.Timezone
+	if _, err := p.Rule(); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return false
+	}
+	return true
+}
+
+// addQuotaHandler 添加配额策略
+func addQuotaHandler(c *gin.Context) {
+	var req QuotaReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	p := &db.QuotaPolicy{}
+	if !bindQuotaPolicy(c, &req, p) {
+		return
+	}
+	if err := db.CreateQuotaPolicy(c.Request.Context(), p); err != nil {
+		utils.Resp(500, "创建配额策略失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "quota.add", db.AuditTargetQuotaPolicy, p.Id, nil, quotaSnapshot(p))
+
+	utils.Resp(0, "success", gin.H{
+		"id": p.Id,
+	}).Success(c)
+}
+
+// updateQuotaHandler 更新配额策略，立即生效
+func updateQuotaHandler(c *gin.Context) {
+	var req QuotaReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	existing, err := db.GetQuotaPolicyById(c.Request.Context(), req.Id)
+	if err != nil {
+		utils.Resp(404, "配额策略不存在", gin.H{}).Fail(c)
+		return
+	}
+	before := quotaSnapshot(existing)
+	if !bindQuotaPolicy(c, &req, existing) {
+		return
+	}
+	if err := db.UpdateQuotaPolicy(c.Request.Context(), existing); err != nil {
+		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "quota.update", db.AuditTargetQuotaPolicy, existing.Id, before, quotaSnapshot(existing))
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// deleteQuotaHandler 删除配额策略
+func deleteQuotaHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的策略ID"}).Fail(c)
+		return
+	}
+	existing, err := db.GetQuotaPolicyById(c.Request.Context(), id)
+	if err != nil {
+		utils.Resp(404, "配额策略不存在", gin.H{}).Fail(c)
+		return
+	}
+	if err := db.DeleteQuotaPolicy(c.Request.Context(), id); err != nil {
+		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "quota.delete", db.AuditTargetQuotaPolicy, id, quotaSnapshot(existing), nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
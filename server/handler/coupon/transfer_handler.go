@@ -0,0 +1,182 @@
+package coupon
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/quota"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TransferItem 转让审批列表项
+type TransferItem struct {
+	Id           int64  `json:"id"`
+	CouponId     int64  `json:"coupon_id"`
+	Type         int    `json:"type"`
+	TypeName     string `json:"type_name"`
+	FromUser     int64  `json:"from_user"`
+	FromName     string `json:"from_name"`
+	ToUser       int64  `json:"to_user"`
+	ToName       string `json:"to_name"`
+	Status       string `json:"status"`
+	Note         string `json:"note"`
+	Reviewer     int64  `json:"reviewer"`
+	ReviewerName string `json:"reviewer_name"`
+	ReviewNote   string `json:"review_note"`
+	CreatedAt    int64  `json:"created_at"`
+	FinishedAt   int64  `json:"finished_at"`
+}
+
+// transferListHandler 转让记录，可按 status、type 筛选，只返回有审批权限的类型
+func transferListHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+
+	filter := db.TransferFilter{}
+	if status := c.Query("status"); status != "" {
+		if !db.IsValidTransferStatus(status) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的转让状态"}).Fail(c)
+			return
+		}
+		filter.Statuses = []string{status}
+	}
+	if typeStr := c.Query("type"); typeStr != "" {
+		if t, err := strconv.Atoi(typeStr); err == nil {
+			filter.Types = []int{t}
+		}
+	}
+	if types, all := middleware.AllowedCouponTypes(c, db.PermCouponTransferReview); !all {
+		if filter.Types != nil && !slices.Contains(types, filter.Types[0]) {
+			utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+			return
+		}
+		if filter.Types == nil {
+			filter.Types = types
+		}
+	}
+
+	transfers, total, err := db.GetCouponTransfers(c.Request.Context(), filter, (page-1)*size, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	// 批量查询转让人、接收人和审批人
+	var userIds []int64
+	for _, t := range transfers {
+		userIds = append(userIds, t.FromUser, t.ToUser)
+		if t.Reviewer > 0 {
+			userIds = append(userIds, t.Reviewer)
+		}
+	}
+	nameMap := make(map[int64]string)
+	if len(userIds) > 0 {
+		users, _ := db.GetUsersByIds(c.Request.Context(), userIds)
+		for _, u := range users {
+			nameMap[u.Id] = u.Name
+		}
+	}
+
+	list := make([]TransferItem, 0, len(transfers))
+	for _, t := range transfers {
+		list = append(list, TransferItem{
+			Id:           t.Id,
+			CouponId:     t.CouponId,
+			Type:         t.CouponType,
+			TypeName:     db.GetCouponTypeName(t.CouponType),
+			FromUser:     t.FromUser,
+			FromName:     nameMap[t.FromUser],
+			ToUser:       t.ToUser,
+			ToName:       nameMap[t.ToUser],
+			Status:       t.Status,
+			Note:         t.Note,
+			Reviewer:     t.Reviewer,
+			ReviewerName: nameMap[t.Reviewer],
+			ReviewNote:   t.ReviewNote,
+			CreatedAt:    t.CreatedAt,
+			FinishedAt:   t.FinishedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":  list,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// ReviewTransferReq 审批转让请求
+type ReviewTransferReq struct {
+	Id   int64  `json:"id" binding:"required"`
+	Note string `json:"note"`
+}
+
+// approveTransferHandler 审批通过，卡券立即转给接收人
+func approveTransferHandler(c *gin.Context) {
+	reviewTransfer(c, true)
+}
+
+// rejectTransferHandler 审批不通过，卡券保留在转让人手中
+func rejectTransferHandler(c *gin.Context) {
+	reviewTransfer(c, false)
+}
+
+func reviewTransfer(c *gin.Context, approve bool) {
+	var req ReviewTransferReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	ctx := c.Request.Context()
+	t, err := db.GetCouponTransferById(ctx, req.Id)
+	if err != nil {
+		utils.Resp(404, "转让不存在", gin.H{}).Fail(c)
+		return
+	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponTransferReview, t.CouponType) {
+		utils.Resp(403, noTypePermMsg, gin.H{}).Fail(c)
+		return
+	}
+
+	reviewer := middleware.GetCurrentClaims(c).UserId
+	note := strings.TrimSpace(req.Note)
+	action, status := "coupon.transfer.reject", db.TransferStatusRejected
+	if approve {
+		action, status = "coupon.transfer.approve", db.TransferStatusCompleted
+		var q quota.Result
+		q, err = db.ApproveCouponTransfer(ctx, t.Id, reviewer, note)
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			utils.Resp(400, "接收人的领取配额不足："+q.Rule.Describe(), gin.H{}).Fail(c)
+			return
+		}
+	} else {
+		err = db.RejectCouponTransfer(ctx, t.Id, reviewer, note)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrTransferStateInvalid):
+			utils.Resp(400, "只能审批等待审批的转让", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrCouponStateChanged):
+			utils.Resp(409, "卡券已被使用、退回或作废，请拒绝该转让", gin.H{}).Fail(c)
+		default:
+			utils.Resp(500, "操作失败", gin.H{"error": err.Error()}).Fail(c)
+		}
+		return
+	}
+	middleware.Audit(c, action, db.AuditTargetTransfer, t.Id,
+		gin.H{"status": t.Status}, gin.H{"status": status, "review_note": note})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
@@ -0,0 +1,398 @@
+package department
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Register 注册路由
+func Register(r gin.IRouter) {
+	g := r.Group("/department")
+
+	// 需要登录权限
+	g.Use(middleware.Auth())
+
+	// 部门列表用于选择部门，登录用户都可以查看
+	g.GET("/list", listHandler)
+
+	// 部门负责人查看本部门及下级部门的卡券使用情况
+	g.GET("/team/coupons", teamCouponsHandler)
+
+	perm := middleware.RequirePermission(db.PermDepartmentManage)
+	g.POST("/add", perm, addHandler)
+	g.PUT("/update", perm, updateHandler)
+	g.DELETE("/delete/:id", perm, deleteHandler)
+}
+
+// DepartmentItem 部门列表项
+type DepartmentItem struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	ParentId  int64  `json:"parent_id"`
+	HeadId    int64  `json:"head_id"`
+	HeadName  string `json:"head_name"`
+	UserCount int64  `json:"user_count"` // 直属用户数，不包含下级部门
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// listHandler 部门列表，按 parent_id 组成树
+func listHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	depts, err := db.GetDepartments(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	counts, err := db.CountDepartmentUsers(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	// 批量查询负责人
+	var headIds []int64
+	for _, d := range depts {
+		if d.HeadId > 0 {
+			headIds = append(headIds, d.HeadId)
+		}
+	}
+	headMap := make(map[int64]string)
+	users, _ := db.GetUsersByIds(ctx, headIds)
+	for _, u := range users {
+		headMap[u.Id] = u.Name
+	}
+
+	list := make([]DepartmentItem, 0, len(depts))
+	for _, d := range depts {
+		list = append(list, DepartmentItem{
+			Id:        d.Id,
+			Name:      d.Name,
+			ParentId:  d.ParentId,
+			HeadId:    d.HeadId,
+			HeadName:  headMap[d.HeadId],
+			UserCount: counts[d.Id],
+			CreatedAt: d.CreatedAt,
+			UpdatedAt: d.UpdatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
+
+// deptSnapshot 审计日志中的部门快照
+func deptSnapshot(d *db.Department) gin.H {
+	if d == nil {
+		return nil
+	}
+	return gin.H{
+		"name":      d.Name,
+		"parent_id": d.ParentId,
+		"head_id":   d.HeadId,
+	}
+}
+
+// checkParent 校验上级部门存在
+func checkParent(c *gin.Context, parentId int64) bool {
+	if parentId == 0 {
+		return true
+	}
+	if _, err := db.GetDepartmentById(c.Request.Context(), parentId); err != nil {
+		utils.Resp(400, "上级部门不存在", gin.H{}).Fail(c)
+		return false
+	}
+	return true
+}
+
+// checkHead 校验负责人存在
+func checkHead(c *gin.Context, headId int64) bool {
+	if headId == 0 {
+		return true
+	}
+	if _, err := db.GetUserById(c.Request.Context(), headId); err != nil {
+		utils.Resp(400, "负责人不存在", gin.H{}).Fail(c)
+		return false
+	}
+	return true
+}
+
+// AddReq 添加部门请求
+type AddReq struct {
+	Name     string `json:"name" binding:"required"`
+	ParentId int64  `json:"parent_id"` // 上级部门，不传为顶级部门
+	HeadId   int64  `json:"head_id"`   // 负责人
+}
+
+// addHandler 添加部门
+func addHandler(c *gin.Context) {
+	var req AddReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.Resp(400, "参数错误", gin.H{"error": "部门名不能为空"}).Fail(c)
+		return
+	}
+	if !checkParent(c, req.ParentId) || !checkHead(c, req.HeadId) {
+		return
+	}
+
+	dept := &db.Department{
+		Name:     req.Name,
+		ParentId: req.ParentId,
+		HeadId:   req.HeadId,
+	}
+	if err := db.CreateDepartment(c.Request.Context(), dept); err != nil {
+		utils.Resp(500, "创建部门失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "department.add", db.AuditTargetDepartment, dept.Id, nil, deptSnapshot(dept))
+
+	utils.Resp(0, "success", gin.H{
+		"id": dept.Id,
+	}).Success(c)
+}
+
+// UpdateReq 更新部门请求
+type UpdateReq struct {
+	Id       int64   `json:"id" binding:"required"`
+	Name     *string `json:"name"`
+	ParentId *int64  `json:"parent_id"` // 为 0 时改为顶级部门
+	HeadId   *int64  `json:"head_id"`   // 为 0 时取消负责人
+}
+
+// updateHandler 更新部门
+func updateHandler(c *gin.Context) {
+	var req UpdateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := db.GetDepartmentById(ctx, req.Id)
+	if err != nil {
+		utils.Resp(404, "部门不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	fields := make(map[string]interface{})
+	if req.Name != nil && strings.TrimSpace(*req.Name) != "" {
+		fields["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.ParentId != nil {
+		if !checkParent(c, *req.ParentId) {
+			return
+		}
+		fields["parent_id"] = *req.ParentId
+	}
+	if req.HeadId != nil {
+		if !checkHead(c, *req.HeadId) {
+			return
+		}
+		fields["head_id"] = *req.HeadId
+	}
+
+	if len(fields) == 0 {
+		utils.Resp(400, "没有要更新的字段", gin.H{}).Fail(c)
+		return
+	}
+
+	if err := db.UpdateDepartmentFields(ctx, req.Id, fields); err != nil {
+		if errors.Is(err, db.ErrDepartmentCycle) {
+			utils.Resp(400, "上级部门不能是自身或下级部门", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	after, _ := db.GetDepartmentById(ctx, req.Id)
+	middleware.Audit(c, "department.update", db.AuditTargetDepartment, req.Id, deptSnapshot(existing), deptSnapshot(after))
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// deleteHandler 删除部门，存在下级部门或用户时不能删除
+func deleteHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的部门ID"}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := db.GetDepartmentById(ctx, id)
+	if err != nil {
+		utils.Resp(404, "部门不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	if err := db.DeleteDepartment(ctx, id); err != nil {
+		if errors.Is(err, db.ErrDepartmentNotEmpty) {
+			utils.Resp(400, "部门下还有下级部门或用户，不能删除", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "department.delete", db.AuditTargetDepartment, id, deptSnapshot(existing), nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// TeamCouponItem 团队卡券领取记录，不包含卡券码
+type TeamCouponItem struct {
+	Id             int64  `json:"id"`
+	Type           int    `json:"type"`
+	TypeName       string `json:"type_name"`
+	Taker          int64  `json:"taker"`
+	TakerName      string `json:"taker_name"`
+	DepartmentId   int64  `json:"department_id"`
+	DepartmentName string `json:"department_name"`
+	State          string `json:"state"` // taken/used/voided
+	TakenAt        int64  `json:"taken_at"`
+}
+
+// teamDepartmentIds 当前用户可以查看的部门，包含下级部门；指定部门时校验是否在可查看范围内
+func teamDepartmentIds(c *gin.Context, deptId int64) ([]int64, bool) {
+	ctx := c.Request.Context()
+	manage := middleware.GetCurrentPermissions(c).Has(db.PermDepartmentManage)
+
+	var roots []int64
+	if deptId > 0 && manage {
+		roots = []int64{deptId}
+	} else {
+		headed, err := db.GetHeadedDepartmentIds(ctx, middleware.GetCurrentClaims(c).UserId)
+		if err != nil {
+			utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+			return nil, false
+		}
+		if len(headed) == 0 {
+			utils.Resp(403, "不是部门负责人", gin.H{}).Fail(c)
+			return nil, false
+		}
+		roots = headed
+	}
+
+	ids, err := db.GetDepartmentSubtreeIds(ctx, roots...)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return nil, false
+	}
+	if deptId > 0 && !manage {
+		if !slices.Contains(ids, deptId) {
+			utils.Resp(403, "无权查看该部门", gin.H{}).Fail(c)
+			return nil, false
+		}
+		if ids, err = db.GetDepartmentSubtreeIds(ctx, deptId); err != nil {
+			utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+			return nil, false
+		}
+	}
+	if len(ids) == 0 {
+		utils.Resp(404, "部门不存在", gin.H{}).Fail(c)
+		return nil, false
+	}
+	return ids, true
+}
+
+// teamCouponsHandler 团队卡券领取记录，可按 department_id、type 筛选
+func teamCouponsHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+	deptId, _ := strconv.ParseInt(c.Query("department_id"), 10, 64)
+
+	deptIds, ok := teamDepartmentIds(c, deptId)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	memberIds, err := db.GetDepartmentUserIds(ctx, deptIds)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	taken := true
+	filter := db.CouponFilter{Taken: &taken, Takers: memberIds}
+	if state := c.Query("state"); state != "" {
+		if !db.IsValidCouponState(state) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的卡券状态"}).Fail(c)
+			return
+		}
+		filter.States = []string{state}
+	}
+	if typeStr := c.Query("type"); typeStr != "" {
+		if t, err := strconv.Atoi(typeStr); err == nil {
+			filter.Type = &t
+		}
+	}
+
+	offset := (page - 1) * size
+	coupons, err := db.GetCouponListWithFilter(ctx, filter, offset, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	total, _ := db.CountCouponsWithFilter(ctx, filter)
+
+	// 批量查询领取者和部门名称
+	var takerIds []int64
+	for _, cp := range coupons {
+		takerIds = append(takerIds, cp.Taker)
+	}
+	takerMap := make(map[int64]*db.User)
+	users, _ := db.GetUsersByIds(ctx, takerIds)
+	for _, u := range users {
+		takerMap[u.Id] = u
+	}
+	deptMap := make(map[int64]string)
+	depts, _ := db.GetDepartments(ctx)
+	for _, d := range depts {
+		deptMap[d.Id] = d.Name
+	}
+
+	list := make([]TeamCouponItem, 0, len(coupons))
+	for _, cp := range coupons {
+		item := TeamCouponItem{
+			Id:       cp.Id,
+			Type:     cp.Type,
+			TypeName: db.GetCouponTypeName(cp.Type),
+			Taker:    cp.Taker,
+			State:    cp.State,
+			TakenAt:  cp.TakenAt,
+		}
+		if u := takerMap[cp.Taker]; u != nil {
+			item.TakerName = u.Name
+			item.DepartmentId = u.DepartmentId
+			item.DepartmentName = deptMap[u.DepartmentId]
+		}
+		list = append(list, item)
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":    list,
+		"total":   total,
+		"members": len(memberIds),
+		"page":    page,
+		"size":    size,
+	}).Success(c)
+}
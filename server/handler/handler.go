@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"pionex-administrative-sys/server/handler/audit"
 	"pionex-administrative-sys/server/handler/coupon"
+	"pionex-administrative-sys/server/handler/department"
 	my_coupon "pionex-administrative-sys/server/handler/my_coupon"
+	"pionex-administrative-sys/server/handler/role"
 	"pionex-administrative-sys/server/handler/user"
 	"pionex-administrative-sys/server/middleware"
 	"pionex-administrative-sys/utils"
@@ -12,12 +15,16 @@ import (
 
 func Register(r gin.IRouter) {
 	r.GET("/health", healthHandler)
-	r.Use(middleware.Logger(), middleware.Recovery())
+	r.GET("/.well-known/jwks.json", jwksHandler)
+	r.Use(middleware.RequestID(), middleware.Logger(), middleware.Recovery())
 	api := r.Group("/api/v1")
 	{
 		user.Register(api)
 		coupon.Register(api)
 		my_coupon.Register(api)
+		audit.Register(api)
+		role.Register(api)
+		department.Register(api)
 	}
 }
 
@@ -27,3 +34,10 @@ func healthHandler(c *gin.Context) {
 		"status": "ok",
 	}).Success(c)
 }
+
+// jwksHandler 公开非对称签名公钥，供其他服务校验 token
+func jwksHandler(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"keys": utils.JWKS(),
+	})
+}
@@ -1,15 +1,17 @@
 package my_coupon
 
 import (
+	"context"
 	"errors"
 	"pionex-administrative-sys/db"
 	"pionex-administrative-sys/server/middleware"
 	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/app"
+	"pionex-administrative-sys/utils/quota"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 // Register 注册路由
@@ -23,47 +25,89 @@ func Register(r gin.IRouter) {
 	g.GET("/detail/:id", detailHandler)
 	g.GET("/stock", stockHandler)
 
-	// 申领卡券需要 RoleApplyCoupon 权限
-	g.POST("/take", middleware.RequireRole(db.RoleApplyCoupon), takeHandler)
+	// 领取者标记已使用或退回未使用的卡券
+	g.POST("/use/:id", useHandler)
+	g.POST("/return/:id", returnHandler)
+
+	// 转让给其他用户，接收人确认后生效
+	g.POST("/transfer", transferHandler)
+	g.GET("/transfers", transferListHandler)
+	g.POST("/transfer/accept/:id", acceptTransferHandler)
+	g.POST("/transfer/decline/:id", declineTransferHandler)
+	g.POST("/transfer/cancel/:id", cancelTransferHandler)
+
+	// 申领卡券需要 coupon.take 权限，可以只授权部分卡券类型
+	g.POST("/take", middleware.RequireCouponPermission(db.PermCouponTake), takeHandler)
 }
 
 // MyCouponItem 我的卡券列表项
 type MyCouponItem struct {
-	Id        int64  `json:"id"`
-	Type      int    `json:"type"`
-	TypeName  string `json:"type_name"`
-	TakenAt   int64  `json:"taken_at"` // 领取时间（使用 updated_at）
-	CreatedAt int64  `json:"created_at"`
+	Id           int64  `json:"id"`
+	Type         int    `json:"type"`
+	TypeName     string `json:"type_name"`
+	State        string `json:"state"` // taken/used/voided
+	TakenAt      int64  `json:"taken_at"`
+	UsedAt       int64  `json:"used_at"`
+	CreatedAt    int64  `json:"created_at"`
+	ValidFrom    int64  `json:"valid_from"`  // 生效时间，为 0 表示不限制
+	ValidUntil   int64  `json:"valid_until"` // 过期时间，为 0 表示长期有效
+	IsExpired    bool   `json:"is_expired"`
+	ExpiringSoon bool   `json:"expiring_soon"` // 即将过期
+	Transferable bool   `json:"transferable"`  // 可以转让给其他用户
+	TransferId   int64  `json:"transfer_id"`   // 未完成的转让，为 0 表示没有
 }
 
 // MyCouponDetail 我的卡券详情
 type MyCouponDetail struct {
-	Id        int64  `json:"id"`
-	Coupon    string `json:"coupon"` // 卡券码
-	Type      int    `json:"type"`
-	TypeName  string `json:"type_name"`
-	TakenAt   int64  `json:"taken_at"`
-	CreatedAt int64  `json:"created_at"`
+	Id         int64  `json:"id"`
+	Coupon     string `json:"coupon"` // 卡券码
+	Type       int    `json:"type"`
+	TypeName   string `json:"type_name"`
+	State      string `json:"state"`
+	TakenAt    int64  `json:"taken_at"`
+	UsedAt     int64  `json:"used_at"`
+	CreatedAt  int64  `json:"created_at"`
+	ValidFrom  int64  `json:"valid_from"`
+	ValidUntil int64  `json:"valid_until"`
+	IsExpired  bool   `json:"is_expired"`
 }
 
-func toMyCouponItem(c *db.Coupon) MyCouponItem {
+func toMyCouponItem(c *db.Coupon, now, warnBefore int64) MyCouponItem {
 	return MyCouponItem{
-		Id:        c.Id,
-		Type:      c.Type,
-		TypeName:  db.GetCouponTypeName(c.Type),
-		TakenAt:   c.UpdatedAt, // 领取时间用 updated_at
-		CreatedAt: c.CreatedAt,
+		Id:           c.Id,
+		Type:         c.Type,
+		TypeName:     db.GetCouponTypeName(c.Type),
+		State:        c.State,
+		TakenAt:      c.TakenAt,
+		UsedAt:       c.UsedAt,
+		CreatedAt:    c.CreatedAt,
+		ValidFrom:    c.ValidFrom,
+		ValidUntil:   c.ValidUntil,
+		IsExpired:    c.IsExpired(now),
+		ExpiringSoon: c.State == db.CouponStateTaken && !c.IsExpired(now) && c.ValidUntil > 0 && c.ValidUntil <= warnBefore,
 	}
 }
 
+// ExpiryWarning 即将过期提醒
+type ExpiryWarning struct {
+	Id         int64  `json:"id"`
+	TypeName   string `json:"type_name"`
+	ValidUntil int64  `json:"valid_until"`
+}
+
 func toMyCouponDetail(c *db.Coupon) MyCouponDetail {
 	return MyCouponDetail{
-		Id:        c.Id,
-		Coupon:    c.Coupon,
-		Type:      c.Type,
-		TypeName:  db.GetCouponTypeName(c.Type),
-		TakenAt:   c.UpdatedAt,
-		CreatedAt: c.CreatedAt,
+		Id:         c.Id,
+		Coupon:     c.Coupon,
+		Type:       c.Type,
+		TypeName:   db.GetCouponTypeName(c.Type),
+		State:      c.State,
+		TakenAt:    c.TakenAt,
+		UsedAt:     c.UsedAt,
+		CreatedAt:  c.CreatedAt,
+		ValidFrom:  c.ValidFrom,
+		ValidUntil: c.ValidUntil,
+		IsExpired:  c.IsExpired(time.Now().UnixMilli()),
 	}
 }
 
@@ -88,25 +132,66 @@ func listHandler(c *gin.Context) {
 		}
 	}
 
+	// 筛选状态
+	var states []string
+	if state := c.Query("state"); state != "" {
+		if !db.IsValidCouponState(state) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的卡券状态"}).Fail(c)
+			return
+		}
+		states = []string{state}
+	}
+
 	offset := (page - 1) * size
-	coupons, err := db.GetCouponsByTaker(c.Request.Context(), userId, typeFilter, offset, size)
+	coupons, err := db.GetCouponsByTaker(c.Request.Context(), userId, typeFilter, states, offset, size)
 	if err != nil {
 		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
 
-	total, _ := db.CountCouponsByTaker(c.Request.Context(), userId, typeFilter)
+	total, _ := db.CountCouponsByTaker(c.Request.Context(), userId, typeFilter, states)
 
+	now := time.Now()
+	warnBefore := now.Add(app.Conf().Coupon.ExpiryWarnWindow()).UnixMilli()
 	list := make([]MyCouponItem, 0, len(coupons))
+	couponIds := make([]int64, 0, len(coupons))
 	for _, cp := range coupons {
-		list = append(list, toMyCouponItem(cp))
+		couponIds = append(couponIds, cp.Id)
+	}
+	transferIds, err := db.GetActiveTransferIds(c.Request.Context(), couponIds)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	for _, cp := range coupons {
+		item := toMyCouponItem(cp, now.UnixMilli(), warnBefore)
+		ct, _ := db.GetCouponTypeById(cp.Type)
+		item.Transferable = ct.Transferable && cp.State == db.CouponStateTaken
+		item.TransferId = transferIds[cp.Id]
+		list = append(list, item)
+	}
+
+	// 不分页，提醒所有即将过期的卡券
+	expiring, err := db.GetExpiringCouponsByTaker(c.Request.Context(), userId, now.UnixMilli(), warnBefore)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	warnings := make([]ExpiryWarning, 0, len(expiring))
+	for _, cp := range expiring {
+		warnings = append(warnings, ExpiryWarning{
+			Id:         cp.Id,
+			TypeName:   db.GetCouponTypeName(cp.Type),
+			ValidUntil: cp.ValidUntil,
+		})
 	}
 
 	utils.Resp(0, "success", gin.H{
-		"list":  list,
-		"total": total,
-		"page":  page,
-		"size":  size,
+		"list":     list,
+		"total":    total,
+		"page":     page,
+		"size":     size,
+		"warnings": warnings,
 	}).Success(c)
 }
 
@@ -136,6 +221,32 @@ func detailHandler(c *gin.Context) {
 	utils.Resp(0, "success", toMyCouponDetail(coupon)).Success(c)
 }
 
+// QuotaInfo 当前用户的领取配额
+type QuotaInfo struct {
+	Unlimited   bool   `json:"unlimited"` // 没有配置配额策略
+	Limit       int    `json:"limit"`
+	Used        int    `json:"used"`
+	Remaining   int    `json:"remaining"`
+	ResetAt     int64  `json:"reset_at"`    // 配额用完时恢复领取的时间，为 0 表示不会恢复
+	Description string `json:"description"` // 最严格规则的描述
+}
+
+func toQuotaInfo(q quota.Result) QuotaInfo {
+	info := QuotaInfo{
+		Unlimited: q.Unlimited,
+		Limit:     q.Limit,
+		Used:      q.Used,
+		Remaining: q.Remaining,
+	}
+	if !q.Unlimited {
+		info.Description = q.Rule.Describe()
+	}
+	if !q.ResetAt.IsZero() {
+		info.ResetAt = q.ResetAt.UnixMilli()
+	}
+	return info
+}
+
 // stockHandler 查询指定类型卡券库存
 func stockHandler(c *gin.Context) {
 	typeStr := c.Query("type")
@@ -162,10 +273,18 @@ func stockHandler(c *gin.Context) {
 		return
 	}
 
+	// 当前用户的剩余配额
+	q, err := db.GetUserQuota(c.Request.Context(), middleware.GetCurrentClaims(c).UserId, couponType, time.Now())
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
 	utils.Resp(0, "success", gin.H{
 		"type":     couponType,
 		"typeName": db.GetCouponTypeName(couponType),
 		"stock":    count,
+		"quota":    toQuotaInfo(q),
 	}).Success(c)
 }
 
@@ -187,56 +306,106 @@ func takeHandler(c *gin.Context) {
 		utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
 		return
 	}
+	if !middleware.CanAccessCouponType(c, db.PermCouponTake, req.Type) {
+		utils.Resp(403, "无权申领该类型卡券", gin.H{}).Fail(c)
+		return
+	}
 
 	userId := middleware.GetCurrentClaims(c).UserId
 
-	// 校验12小时内是否已领取过该类型卡券
-	lastCoupon, err := db.GetLastTakenCouponByTakerAndType(c.Request.Context(), userId, req.Type)
-	if err == nil {
-		// 找到了上次领取记录，检查时间间隔
-		lastTakenTime := time.UnixMilli(lastCoupon.UpdatedAt)
-		elapsed := time.Since(lastTakenTime)
-		if elapsed < 12*time.Hour {
-			remaining := 12*time.Hour - elapsed
-			hours := int(remaining.Hours())
-			minutes := int(remaining.Minutes()) % 60
-			utils.Resp(400, "每天只能领1张哦", gin.H{
-				"message":           "每天只能领1张哦",
-				"remaining_hours":   hours,
-				"remaining_minutes": minutes,
-			}).Fail(c)
-			return
-		}
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-		// 查询出错（非"记录不存在"错误）
-		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+	// 在同一事务中校验配额并分配卡券，并发领取时在服务内部排队和重试
+	res, err := db.ClaimCoupon(c.Request.Context(), userId, req.Type, time.Now())
+	switch {
+	case errors.Is(err, db.ErrQuotaExceeded):
+		quotaExceeded(c, res.Quota)
+		return
+	case errors.Is(err, db.ErrCouponOutOfStock):
+		utils.Resp(400, "该类型卡券库存不足", gin.H{}).Fail(c)
+		return
+	case errors.Is(err, db.ErrCouponStateChanged):
+		utils.Resp(409, "领取人数较多，请稍后重试", gin.H{}).Fail(c)
+		return
+	case err != nil:
+		utils.Resp(500, "领取失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	takenCoupon := res.Coupon
+	middleware.Audit(c, "coupon.take", db.AuditTargetCoupon, takenCoupon.Id,
+		gin.H{"state": res.FromState, "taker": 0}, gin.H{"state": takenCoupon.State, "taker": userId})
+
+	utils.Resp(0, "success", gin.H{
+		"id":          takenCoupon.Id,
+		"type":        takenCoupon.Type,
+		"type_name":   db.GetCouponTypeName(takenCoupon.Type),
+		"coupon":      takenCoupon.Coupon,
+		"valid_until": takenCoupon.ValidUntil,
+	}).Success(c)
+}
+
+// quotaExceeded 配额已用完，返回限制说明和恢复时间
+func quotaExceeded(c *gin.Context, q quota.Result) {
+	msg := q.Rule.Describe() + "哦"
+	data := gin.H{
+		"message": msg,
+		"limit":   q.Limit,
+	}
+	if !q.ResetAt.IsZero() {
+		remaining := time.Until(q.ResetAt)
+		data["reset_at"] = q.ResetAt.UnixMilli()
+		data["remaining_hours"] = int(remaining.Hours())
+		data["remaining_minutes"] = int(remaining.Minutes()) % 60
+	}
+	utils.Resp(400, msg, data).Fail(c)
+}
 
-	// 获取一个可用的卡券
-	coupon, err := db.GetOneAvailableCouponByType(c.Request.Context(), req.Type)
+// useHandler 标记卡券已使用
+func useHandler(c *gin.Context) {
+	changeMyCouponState(c, "coupon.use", db.UseCoupon)
+}
+
+// returnHandler 退回未使用的卡券，退回后重新进入库存
+func returnHandler(c *gin.Context) {
+	changeMyCouponState(c, "coupon.return", db.ReturnCoupon)
+}
+
+// changeMyCouponState 领取者变更自己卡券的状态
+func changeMyCouponState(c *gin.Context, action string, change func(ctx context.Context, id, userId int64) error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		utils.Resp(400, "该类型卡券库存不足", gin.H{}).Fail(c)
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的卡券ID"}).Fail(c)
 		return
 	}
+	userId := middleware.GetCurrentClaims(c).UserId
 
-	// 领取卡券
-	if err := db.TakeCoupon(c.Request.Context(), coupon.Id, userId); err != nil {
-		if err == db.ErrCouponAlreadyTaken {
-			utils.Resp(400, "卡券已被他人领取，请重试", gin.H{}).Fail(c)
-		} else {
-			utils.Resp(500, "领取失败", gin.H{"error": err.Error()}).Fail(c)
-		}
+	coupon, err := db.GetCouponById(c.Request.Context(), id)
+	if err != nil || coupon.Taker != userId {
+		utils.Resp(404, "卡券不存在", gin.H{}).Fail(c)
 		return
 	}
 
-	// 重新查询已领取的卡券信息
-	takenCoupon, _ := db.GetCouponById(c.Request.Context(), coupon.Id)
+	if err := change(c.Request.Context(), id, userId); err != nil {
+		switch {
+		case errors.Is(err, db.ErrCouponStateInvalid):
+			utils.Resp(400, "只能操作已领取且未使用的卡券", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrCouponTransferPending):
+			utils.Resp(400, "卡券正在转让中，请先撤回转让", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrCouponStateChanged), errors.Is(err, db.ErrCouponNotHolder):
+			utils.Resp(409, "卡券状态已变化，请刷新后重试", gin.H{}).Fail(c)
+		default:
+			utils.Resp(500, "操作失败", gin.H{"error": err.Error()}).Fail(c)
+		}
+		return
+	}
+	after, err := db.GetCouponById(c.Request.Context(), id)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, action, db.AuditTargetCoupon, id,
+		gin.H{"state": coupon.State, "taker": coupon.Taker}, gin.H{"state": after.State, "taker": after.Taker})
 
 	utils.Resp(0, "success", gin.H{
-		"id":        takenCoupon.Id,
-		"type":      takenCoupon.Type,
-		"type_name": db.GetCouponTypeName(takenCoupon.Type),
-		"coupon":    takenCoupon.Coupon,
+		"id":    id,
+		"state": after.State,
 	}).Success(c)
 }
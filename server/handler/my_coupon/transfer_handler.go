@@ -0,0 +1,251 @@
+package my_coupon
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TransferItem 转让记录列表项，不包含卡券码
+type TransferItem struct {
+	Id         int64  `json:"id"`
+	CouponId   int64  `json:"coupon_id"`
+	Type       int    `json:"type"`
+	TypeName   string `json:"type_name"`
+	FromUser   int64  `json:"from_user"`
+	FromName   string `json:"from_name"`
+	ToUser     int64  `json:"to_user"`
+	ToName     string `json:"to_name"`
+	Status     string `json:"status"` // pending/approving/completed/declined/cancelled/rejected
+	Note       string `json:"note"`
+	ReviewNote string `json:"review_note"`
+	CreatedAt  int64  `json:"created_at"`
+	FinishedAt int64  `json:"finished_at"`
+}
+
+// TransferReq 发起转让请求
+type TransferReq struct {
+	Id        int64  `json:"id" binding:"required"`         // 卡券 ID
+	ToAccount string `json:"to_account" binding:"required"` // 接收人账号
+	Note      string `json:"note"`
+}
+
+// transferHandler 把已领取未使用的卡券转让给其他用户，需要接收人确认
+func transferHandler(c *gin.Context) {
+	var req TransferReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	userId := middleware.GetCurrentClaims(c).UserId
+	ctx := c.Request.Context()
+
+	coupon, err := db.GetCouponById(ctx, req.Id)
+	if err != nil || coupon.Taker != userId {
+		utils.Resp(404, "卡券不存在", gin.H{}).Fail(c)
+		return
+	}
+	to, err := db.GetUserByAccount(ctx, strings.TrimSpace(req.ToAccount))
+	if err != nil || to.Disabled {
+		utils.Resp(400, "接收人不存在", gin.H{}).Fail(c)
+		return
+	}
+	if to.Id == userId {
+		utils.Resp(400, "不能转让给自己", gin.H{}).Fail(c)
+		return
+	}
+
+	t := &db.CouponTransfer{
+		CouponId: req.Id,
+		FromUser: userId,
+		ToUser:   to.Id,
+		Note:     strings.TrimSpace(req.Note),
+	}
+	if err := db.CreateCouponTransfer(ctx, t); err != nil {
+		switch {
+		case errors.Is(err, db.ErrCouponStateInvalid):
+			utils.Resp(400, "只能转让已领取且未使用的卡券", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrCouponNotTransferable):
+			utils.Resp(400, "该类型卡券不允许转让", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrCouponTransferPending):
+			utils.Resp(400, "该卡券已有未完成的转让", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrCouponNotHolder):
+			utils.Resp(409, "卡券状态已变化，请刷新后重试", gin.H{}).Fail(c)
+		default:
+			utils.Resp(500, "转让失败", gin.H{"error": err.Error()}).Fail(c)
+		}
+		return
+	}
+	middleware.Audit(c, "coupon.transfer", db.AuditTargetTransfer, t.Id, nil, gin.H{
+		"coupon_id": t.CouponId,
+		"to_user":   t.ToUser,
+		"status":    t.Status,
+	})
+
+	utils.Resp(0, "success", gin.H{
+		"id": t.Id,
+	}).Success(c)
+}
+
+// transferListHandler 我的转让记录，box=in 为转给我的，默认为我发起的
+func transferListHandler(c *gin.Context) {
+	userId := middleware.GetCurrentClaims(c).UserId
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+
+	filter := db.TransferFilter{FromUser: userId}
+	if c.Query("box") == "in" {
+		filter = db.TransferFilter{ToUser: userId}
+	}
+	if status := c.Query("status"); status != "" {
+		if !db.IsValidTransferStatus(status) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的转让状态"}).Fail(c)
+			return
+		}
+		filter.Statuses = []string{status}
+	}
+
+	transfers, total, err := db.GetCouponTransfers(c.Request.Context(), filter, (page-1)*size, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	// 批量查询转让人和接收人
+	var userIds []int64
+	for _, t := range transfers {
+		userIds = append(userIds, t.FromUser, t.ToUser)
+	}
+	nameMap := make(map[int64]string)
+	if len(userIds) > 0 {
+		users, _ := db.GetUsersByIds(c.Request.Context(), userIds)
+		for _, u := range users {
+			nameMap[u.Id] = u.Name
+		}
+	}
+
+	list := make([]TransferItem, 0, len(transfers))
+	for _, t := range transfers {
+		list = append(list, TransferItem{
+			Id:         t.Id,
+			CouponId:   t.CouponId,
+			Type:       t.CouponType,
+			TypeName:   db.GetCouponTypeName(t.CouponType),
+			FromUser:   t.FromUser,
+			FromName:   nameMap[t.FromUser],
+			ToUser:     t.ToUser,
+			ToName:     nameMap[t.ToUser],
+			Status:     t.Status,
+			Note:       t.Note,
+			ReviewNote: t.ReviewNote,
+			CreatedAt:  t.CreatedAt,
+			FinishedAt: t.FinishedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":  list,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// acceptTransferHandler 接收人确认转让，需要审批的类型在审批通过后生效
+func acceptTransferHandler(c *gin.Context) {
+	t, ok := myTransfer(c, func(t *db.CouponTransfer, userId int64) bool { return t.ToUser == userId })
+	if !ok {
+		return
+	}
+	q, err := db.AcceptCouponTransfer(c.Request.Context(), t.Id, t.ToUser)
+	switch {
+	case errors.Is(err, db.ErrQuotaExceeded):
+		quotaExceeded(c, q)
+		return
+	case err != nil:
+		transferFailed(c, err)
+		return
+	}
+	after, err := db.GetCouponTransferById(c.Request.Context(), t.Id)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "coupon.transfer.accept", db.AuditTargetTransfer, t.Id,
+		gin.H{"status": t.Status}, gin.H{"status": after.Status})
+
+	utils.Resp(0, "success", gin.H{
+		"id":     t.Id,
+		"status": after.Status,
+	}).Success(c)
+}
+
+// declineTransferHandler 接收人拒绝转让
+func declineTransferHandler(c *gin.Context) {
+	t, ok := myTransfer(c, func(t *db.CouponTransfer, userId int64) bool { return t.ToUser == userId })
+	if !ok {
+		return
+	}
+	if err := db.DeclineCouponTransfer(c.Request.Context(), t.Id, t.ToUser); err != nil {
+		transferFailed(c, err)
+		return
+	}
+	middleware.Audit(c, "coupon.transfer.decline", db.AuditTargetTransfer, t.Id,
+		gin.H{"status": t.Status}, gin.H{"status": db.TransferStatusDeclined})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// cancelTransferHandler 转让人撤回未完成的转让
+func cancelTransferHandler(c *gin.Context) {
+	t, ok := myTransfer(c, func(t *db.CouponTransfer, userId int64) bool { return t.FromUser == userId })
+	if !ok {
+		return
+	}
+	if err := db.CancelCouponTransfer(c.Request.Context(), t.Id, t.FromUser); err != nil {
+		transferFailed(c, err)
+		return
+	}
+	middleware.Audit(c, "coupon.transfer.cancel", db.AuditTargetTransfer, t.Id,
+		gin.H{"status": t.Status}, gin.H{"status": db.TransferStatusCancelled})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// myTransfer 查询当前用户参与的转让记录，owns 校验是否为转让人或接收人
+func myTransfer(c *gin.Context, owns func(t *db.CouponTransfer, userId int64) bool) (*db.CouponTransfer, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的转让ID"}).Fail(c)
+		return nil, false
+	}
+	t, err := db.GetCouponTransferById(c.Request.Context(), id)
+	if err != nil || !owns(t, middleware.GetCurrentClaims(c).UserId) {
+		utils.Resp(404, "转让不存在", gin.H{}).Fail(c)
+		return nil, false
+	}
+	return t, true
+}
+
+func transferFailed(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, db.ErrTransferStateInvalid):
+		utils.Resp(400, "转让已处理，请刷新后重试", gin.H{}).Fail(c)
+	case errors.Is(err, db.ErrCouponStateChanged):
+		utils.Resp(409, "卡券已被使用、退回或作废，转让已失效", gin.H{}).Fail(c)
+	default:
+		utils.Resp(500, "操作失败", gin.H{"error": err.Error()}).Fail(c)
+	}
+}
@@ -0,0 +1,143 @@
+package role
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CouponGrantItem 卡券类型授权列表项
+type CouponGrantItem struct {
+	Id         int64  `json:"id"`
+	UserId     int64  `json:"user_id"`
+	UserName   string `json:"user_name"`
+	CouponType int    `json:"coupon_type"`
+	TypeName   string `json:"type_name"`
+	Permission string `json:"permission"`
+	CreatedBy  int64  `json:"created_by"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// couponGrantListHandler 卡券类型授权列表，可按 user_id 筛选
+func couponGrantListHandler(c *gin.Context) {
+	userId, _ := strconv.ParseInt(c.Query("user_id"), 10, 64)
+
+	ctx := c.Request.Context()
+	grants, err := db.GetCouponGrantList(ctx, userId)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	// 批量查询用户名
+	var userIds []int64
+	for _, g := range grants {
+		userIds = append(userIds, g.UserId)
+	}
+	nameMap := make(map[int64]string)
+	users, _ := db.GetUsersByIds(ctx, userIds)
+	for _, u := range users {
+		nameMap[u.Id] = u.Name
+	}
+
+	list := make([]CouponGrantItem, 0, len(grants))
+	for _, g := range grants {
+		list = append(list, CouponGrantItem{
+			Id:         g.Id,
+			UserId:     g.UserId,
+			UserName:   nameMap[g.UserId],
+			CouponType: g.CouponType,
+			TypeName:   db.GetCouponTypeName(g.CouponType),
+			Permission: g.Permission,
+			CreatedBy:  g.CreatedBy,
+			CreatedAt:  g.CreatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
+
+// AddCouponGrantReq 添加卡券类型授权请求
+type AddCouponGrantReq struct {
+	UserId      int64    `json:"user_id" binding:"required"`
+	CouponType  int      `json:"coupon_type" binding:"required"`
+	Permissions []string `json:"permissions" binding:"required"` // 如 coupon.view、coupon.take
+}
+
+// addCouponGrantHandler 为用户授予指定卡券类型的权限
+func addCouponGrantHandler(c *gin.Context) {
+	var req AddCouponGrantReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !db.IsValidCouponType(req.CouponType) {
+		utils.Resp(400, "无效的卡券类型", gin.H{}).Fail(c)
+		return
+	}
+	for _, p := range req.Permissions {
+		if !db.IsCouponScopedPermission(p) {
+			utils.Resp(400, "参数错误", gin.H{"error": "不能按卡券类型授予的权限: " + p}).Fail(c)
+			return
+		}
+	}
+	if !checkPermissions(c, req.Permissions) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := db.GetUserById(ctx, req.UserId); err != nil {
+		utils.Resp(404, "用户不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	operatorId := middleware.GetCurrentClaims(c).UserId
+	if err := db.AddCouponGrants(ctx, req.UserId, req.CouponType, req.Permissions, operatorId); err != nil {
+		utils.Resp(500, "授权失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "coupon_grant.add", db.AuditTargetUser, req.UserId, nil, gin.H{
+		"user_id":     req.UserId,
+		"coupon_type": req.CouponType,
+		"permissions": db.NewPermissionSet(req.Permissions).List(),
+	})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// deleteCouponGrantHandler 删除卡券类型授权，立即生效
+func deleteCouponGrantHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的授权ID"}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	grant, err := db.GetCouponGrantById(ctx, id)
+	if err != nil {
+		utils.Resp(404, "授权不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	// 收回权限同样不能超出操作人自身的权限
+	if !checkPermissions(c, []string{grant.Permission}) {
+		return
+	}
+
+	if err := db.DeleteCouponGrant(ctx, grant); err != nil {
+		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "coupon_grant.delete", db.AuditTargetUser, grant.UserId, gin.H{
+		"user_id":     grant.UserId,
+		"coupon_type": grant.CouponType,
+		"permissions": []string{grant.Permission},
+	}, nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
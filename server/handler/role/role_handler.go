@@ -0,0 +1,301 @@
+package role
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Register 注册路由
+func Register(r gin.IRouter) {
+	g := r.Group("/role")
+
+	// 需要管理角色权限
+	g.Use(middleware.Auth(), middleware.RequirePermission(db.PermRoleManage))
+
+	g.GET("/permissions", permissionsHandler)
+	g.GET("/list", listHandler)
+	g.POST("/add", addHandler)
+	g.PUT("/update", updateHandler)
+	g.DELETE("/delete/:id", deleteHandler)
+
+	// 按卡券类型授权
+	g.GET("/coupon-grant/list", couponGrantListHandler)
+	g.POST("/coupon-grant/add", addCouponGrantHandler)
+	g.DELETE("/coupon-grant/delete/:id", deleteCouponGrantHandler)
+}
+
+// PermissionItem 权限列表项
+type PermissionItem struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// permissionsHandler 所有可分配的权限
+func permissionsHandler(c *gin.Context) {
+	perms := db.AllPermissions()
+	list := make([]PermissionItem, 0, len(perms))
+	for _, p := range perms {
+		list = append(list, PermissionItem{Code: p.Code, Name: p.Name})
+	}
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
+
+// RoleItem 角色列表项
+type RoleItem struct {
+	Id          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Builtin     bool     `json:"builtin"`
+	Permissions []string `json:"permissions"`
+	UserCount   int64    `json:"user_count"`
+	CreatedAt   int64    `json:"created_at"`
+	UpdatedAt   int64    `json:"updated_at"`
+}
+
+// listHandler 角色列表，包含权限和用户数
+func listHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	roles, err := db.GetRoles(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	permMap, err := db.GetRolePermissionMap(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	counts, err := db.CountRoleUsers(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	list := make([]RoleItem, 0, len(roles))
+	for _, r := range roles {
+		perms := permMap[r.Id]
+		if perms == nil {
+			perms = []string{}
+		}
+		list = append(list, RoleItem{
+			Id:          r.Id,
+			Name:        r.Name,
+			Description: r.Description,
+			Builtin:     r.Builtin,
+			Permissions: perms,
+			UserCount:   counts[r.Id],
+			CreatedAt:   r.CreatedAt,
+			UpdatedAt:   r.UpdatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
+
+// checkPermissions 校验权限已定义，且不超出操作人自身的权限，防止越权
+func checkPermissions(c *gin.Context, perms []string) bool {
+	for _, p := range perms {
+		if !db.IsValidPermission(p) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的权限: " + p}).Fail(c)
+			return false
+		}
+	}
+	if !middleware.GetCurrentPermissions(c).Contains(db.NewPermissionSet(perms)) {
+		utils.Resp(403, "不能授予超出自身权限的权限", gin.H{}).Fail(c)
+		return false
+	}
+	return true
+}
+
+// roleSnapshot 审计日志中的角色快照
+func roleSnapshot(name, description string, perms []string) gin.H {
+	return gin.H{
+		"name":        name,
+		"description": description,
+		"permissions": db.NewPermissionSet(perms).List(),
+	}
+}
+
+// AddReq 添加角色请求
+type AddReq struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// addHandler 添加角色
+func addHandler(c *gin.Context) {
+	var req AddReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.Resp(400, "参数错误", gin.H{"error": "角色名不能为空"}).Fail(c)
+		return
+	}
+	if !checkPermissions(c, req.Permissions) {
+		return
+	}
+
+	role := &db.Role{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := db.CreateRole(c.Request.Context(), role, req.Permissions); err != nil {
+		if errors.Is(err, db.ErrRoleNameExists) {
+			utils.Resp(400, "角色名已存在", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "创建角色失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "role.add", db.AuditTargetRole, role.Id, nil,
+		roleSnapshot(role.Name, role.Description, req.Permissions))
+
+	utils.Resp(0, "success", gin.H{
+		"id": role.Id,
+	}).Success(c)
+}
+
+// UpdateReq 更新角色请求
+type UpdateReq struct {
+	Id          int64     `json:"id" binding:"required"`
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Permissions *[]string `json:"permissions"` // 替换角色的权限
+}
+
+// updateHandler 更新角色，修改后拥有该角色的用户立即生效
+func updateHandler(c *gin.Context) {
+	var req UpdateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	role, err := db.GetRoleById(ctx, req.Id)
+	if err != nil {
+		utils.Resp(404, "角色不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	permMap, err := db.GetRolePermissionMap(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	oldPerms := permMap[role.Id]
+
+	fields := make(map[string]interface{})
+	if req.Name != nil && strings.TrimSpace(*req.Name) != "" {
+		fields["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		fields["description"] = *req.Description
+	}
+	var perms []string
+	if req.Permissions != nil {
+		perms = *req.Permissions
+		if perms == nil {
+			perms = []string{}
+		}
+		// 新增和移除的权限都不能超出操作人自身的权限
+		oldSet, newSet := db.NewPermissionSet(oldPerms), db.NewPermissionSet(perms)
+		var changed []string
+		for p := range newSet {
+			if !oldSet[p] {
+				changed = append(changed, p)
+			}
+		}
+		for p := range oldSet {
+			if !newSet[p] {
+				changed = append(changed, p)
+			}
+		}
+		if !checkPermissions(c, changed) {
+			return
+		}
+		// 内置管理员角色必须保留管理角色的权限，避免无人能管理角色
+		if role.Builtin && oldSet.Has(db.PermRoleManage) && !newSet.Has(db.PermRoleManage) {
+			utils.Resp(400, "内置角色不能移除管理角色权限", gin.H{}).Fail(c)
+			return
+		}
+	}
+
+	if len(fields) == 0 && perms == nil {
+		utils.Resp(400, "没有要更新的字段", gin.H{}).Fail(c)
+		return
+	}
+
+	if err := db.UpdateRole(ctx, req.Id, fields, perms); err != nil {
+		if errors.Is(err, db.ErrRoleNameExists) {
+			utils.Resp(400, "角色名已存在", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	after, _ := db.GetRoleById(ctx, req.Id)
+	newPerms := oldPerms
+	if perms != nil {
+		newPerms = perms
+	}
+	if after != nil {
+		middleware.Audit(c, "role.update", db.AuditTargetRole, req.Id,
+			roleSnapshot(role.Name, role.Description, oldPerms),
+			roleSnapshot(after.Name, after.Description, newPerms))
+	}
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// deleteHandler 删除角色，同时移除用户的该角色，内置角色不能删除
+func deleteHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的角色ID"}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	role, err := db.GetRoleById(ctx, id)
+	if err != nil {
+		utils.Resp(404, "角色不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if role.Builtin {
+		utils.Resp(400, "内置角色不能删除", gin.H{}).Fail(c)
+		return
+	}
+	permMap, err := db.GetRolePermissionMap(ctx)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	// 删除角色会移除用户的权限，同样不能超出操作人自身的权限
+	if !checkPermissions(c, permMap[id]) {
+		return
+	}
+
+	if _, err := db.DeleteRole(ctx, id); err != nil {
+		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "role.delete", db.AuditTargetRole, id,
+		roleSnapshot(role.Name, role.Description, permMap[id]), nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
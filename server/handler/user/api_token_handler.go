@@ -0,0 +1,148 @@
+package user
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiTokenDefaultDays = 90
+	apiTokenMaxDays     = 365
+)
+
+// APITokenItem API token 列表项
+type APITokenItem struct {
+	Id          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Prefix      string   `json:"prefix"`
+	Permissions []string `json:"permissions"`
+	ExpiresAt   int64    `json:"expires_at"`
+	LastUsedAt  int64    `json:"last_used_at"`
+	LastUsedIp  string   `json:"last_used_ip"`
+	CreatedAt   int64    `json:"created_at"`
+}
+
+// apiTokenListHandler 当前用户的 API token 列表
+func apiTokenListHandler(c *gin.Context) {
+	userId := middleware.GetCurrentClaims(c).UserId
+
+	tokens, err := db.GetAPITokensByUser(c.Request.Context(), userId)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	list := make([]APITokenItem, 0, len(tokens))
+	for _, t := range tokens {
+		list = append(list, APITokenItem{
+			Id:          t.Id,
+			Name:        t.Name,
+			Prefix:      t.Prefix,
+			Permissions: t.Permissions,
+			ExpiresAt:   t.ExpiresAt,
+			LastUsedAt:  t.LastUsedAt,
+			LastUsedIp:  t.LastUsedIp,
+			CreatedAt:   t.CreatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list": list,
+	}).Success(c)
+}
+
+// CreateAPITokenReq 创建 API token 请求
+type CreateAPITokenReq struct {
+	Name          string   `json:"name" binding:"required"`
+	Permissions   []string `json:"permissions" binding:"required"` // 权限范围，必须是当前用户权限的子集
+	ExpiresInDays int      `json:"expires_in_days"`                // 有效天数，默认 90，最长 365
+}
+
+// createAPITokenHandler 创建 API token，明文只在创建时返回一次
+func createAPITokenHandler(c *gin.Context) {
+	var req CreateAPITokenReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+	for _, p := range req.Permissions {
+		if !db.IsValidPermission(p) {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的权限: " + p}).Fail(c)
+			return
+		}
+	}
+	perms := db.NewPermissionSet(req.Permissions)
+	if !middleware.GetCurrentPermissions(c).Contains(perms) {
+		utils.Resp(400, "权限范围超出当前用户权限", gin.H{}).Fail(c)
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = apiTokenDefaultDays
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > apiTokenMaxDays {
+		utils.Resp(400, "参数错误", gin.H{"error": "有效天数必须在 1-365 之间"}).Fail(c)
+		return
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		utils.Resp(500, "创建失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	raw := db.APITokenPrefix + secret
+	token := &db.APIToken{
+		UserId:      user.Id,
+		Name:        req.Name,
+		Prefix:      raw[:len(db.APITokenPrefix)+6],
+		TokenHash:   utils.SHA256(raw),
+		Permissions: perms.List(),
+		ExpiresAt:   time.Now().AddDate(0, 0, req.ExpiresInDays).UnixMilli(),
+	}
+	if err := db.CreateAPIToken(c.Request.Context(), token); err != nil {
+		utils.Resp(500, "创建失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "api_token.create", db.AuditTargetAPIToken, token.Id, nil, gin.H{
+		"name":        token.Name,
+		"prefix":      token.Prefix,
+		"permissions": token.Permissions,
+		"expires_at":  token.ExpiresAt,
+	})
+
+	utils.Resp(0, "success", gin.H{
+		"id":          token.Id,
+		"token":       raw,
+		"permissions": token.Permissions,
+		"expires_at":  token.ExpiresAt,
+	}).Success(c)
+}
+
+// revokeAPITokenHandler 吊销 API token
+func revokeAPITokenHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的 token ID"}).Fail(c)
+		return
+	}
+
+	userId := middleware.GetCurrentClaims(c).UserId
+	ok, err := db.RevokeAPIToken(c.Request.Context(), userId, id)
+	if err != nil {
+		utils.Resp(500, "吊销失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !ok {
+		utils.Resp(404, "token 不存在", gin.H{}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "api_token.revoke", db.AuditTargetAPIToken, id, nil, nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
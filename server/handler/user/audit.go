@@ -0,0 +1,51 @@
+package user
+
+import (
+	"context"
+	"pionex-administrative-sys/db"
+)
+
+// userSnapshot 审计日志中的用户快照，不包含密码哈希、两步验证密钥和私钥
+type userSnapshot struct {
+	Name          string   `json:"name"`
+	Account       string   `json:"account"`
+	Email         string   `json:"email"`
+	Roles         []string `json:"roles"`
+	DepartmentId  int64    `json:"department_id"`
+	Source        string   `json:"source"`
+	Disabled      bool     `json:"disabled"`
+	MustChangePwd bool     `json:"must_change_pwd"`
+	TotpEnabled   bool     `json:"totp_enabled"`
+	PrivateKeyFp  string   `json:"private_key_fp"`
+	PwdChangedAt  int64    `json:"pwd_changed_at"`
+}
+
+// snapshotUser 用户快照，包含当前的角色名
+func snapshotUser(ctx context.Context, u *db.User) *userSnapshot {
+	if u == nil {
+		return nil
+	}
+	roles, _ := db.GetUserRoleNames(ctx, u.Id)
+	return &userSnapshot{
+		Name:          u.Name,
+		Account:       u.Account,
+		Email:         u.Email,
+		Roles:         roles,
+		DepartmentId:  u.DepartmentId,
+		Source:        u.Source,
+		Disabled:      u.Disabled,
+		MustChangePwd: u.MustChangePwd,
+		TotpEnabled:   u.TotpEnabled,
+		PrivateKeyFp:  u.PrivateKeyFp,
+		PwdChangedAt:  u.PwdChangedAt,
+	}
+}
+
+// loadUserSnapshot 查询用户快照，查询失败时返回 nil
+func loadUserSnapshot(ctx context.Context, id int64) *userSnapshot {
+	u, err := db.GetUserById(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return snapshotUser(ctx, u)
+}
@@ -0,0 +1,167 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/ldap"
+	"pionex-administrative-sys/utils/logger"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// errAuthFailed 账号不存在或密码错误，可继续尝试下一种认证方式
+	errAuthFailed = errors.New("authentication failed")
+	// errSourceConflict 外部身份与来源不同的账号同名，且不允许关联
+	errSourceConflict = errors.New("account belongs to another source")
+)
+
+// Authenticator 账号密码认证方式
+type Authenticator interface {
+	Name() string
+	// Authenticate 认证成功返回本地用户，凭证错误返回 errAuthFailed，其他错误表示认证服务异常
+	Authenticate(ctx context.Context, account, password string) (*db.User, error)
+}
+
+// authenticators 按顺序尝试的认证方式
+func authenticators() []Authenticator {
+	list := []Authenticator{localAuthenticator{}}
+	if ldap.Enabled() {
+		list = append(list, ldapAuthenticator{})
+	}
+	return list
+}
+
+// authenticate 依次尝试各认证方式，全部为凭证错误时返回 errAuthFailed
+func authenticate(ctx context.Context, account, password string) (*db.User, error) {
+	var lastErr error
+	for _, a := range authenticators() {
+		user, err := a.Authenticate(ctx, account, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errAuthFailed) {
+			logger.Warn("authenticator error", zap.String("authenticator", a.Name()), zap.Error(err))
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, errAuthFailed
+}
+
+// localAuthenticator 本地密码认证
+type localAuthenticator struct{}
+
+func (localAuthenticator) Name() string {
+	return db.UserSourceLocal
+}
+
+func (localAuthenticator) Authenticate(ctx context.Context, account, password string) (*db.User, error) {
+	user, err := db.GetUserByAccount(ctx, account)
+	if err != nil {
+		dummyCheckPwd(password)
+		return nil, errAuthFailed
+	}
+	needRehash, err := user.CheckPwd(password)
+	if err != nil {
+		return nil, errAuthFailed
+	}
+
+	// 旧算法或旧参数的密码哈希，登录成功后透明升级
+	if needRehash {
+		if err := db.RehashPwd(ctx, user.Id, password); err != nil {
+			logger.Warn("upgrade password hash failed", zap.Int64("user_id", user.Id), zap.Error(err))
+		}
+	}
+	return user, nil
+}
+
+// ldapAuthenticator LDAP/AD 绑定认证
+type ldapAuthenticator struct{}
+
+func (ldapAuthenticator) Name() string {
+	return db.UserSourceLDAP
+}
+
+func (ldapAuthenticator) Authenticate(ctx context.Context, account, password string) (*db.User, error) {
+	entry, err := ldap.Authenticate(account, password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, errAuthFailed
+		}
+		return nil, err
+	}
+	user, err := provisionUser(ctx, entry.Account, entry.Name, db.UserSourceLDAP, nil,
+		ldap.Conf().DefaultRole, ldap.GroupRole(entry.Groups), utils.MappedRoleNames(ldap.Conf().GroupRoles))
+	if errors.Is(err, errSourceConflict) {
+		logger.Warn("ldap account conflicts with existing user", zap.String("account", entry.Account))
+		return nil, errAuthFailed
+	}
+	return user, err
+}
+
+// provisionUser 按账号关联同一来源的本地用户，不存在时按默认角色和组映射创建；
+// 已存在时按所在的组同步 mappedRoles 中的角色，默认角色和其他角色不变。
+// 同名账号属于其他来源且 linkable 为 nil 或返回 false 时返回 errSourceConflict，避免外部身份接管本地账号
+func provisionUser(ctx context.Context, account, name, source string, linkable func(*db.User) bool,
+	defaultRole, groupRole, mappedRoles utils.RoleNames) (*db.User, error) {
+	groupRoleIds, err := resolveConfigRoles(ctx, groupRole)
+	if err != nil {
+		return nil, err
+	}
+	var defaultRoleIds []int64
+	if defaultRole.IsEmpty() {
+		defaultRoleIds, err = db.DefaultRoleIds(ctx)
+	} else {
+		defaultRoleIds, err = resolveConfigRoles(ctx, defaultRole)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := db.GetUserByAccount(ctx, account)
+	if err == nil {
+		if user.Source != source && (linkable == nil || !linkable(user)) {
+			return nil, errSourceConflict
+		}
+		mappedRoleIds, err := resolveConfigRoles(ctx, mappedRoles)
+		if err != nil {
+			return nil, err
+		}
+		managed := slices.DeleteFunc(mappedRoleIds, func(id int64) bool {
+			return slices.Contains(defaultRoleIds, id)
+		})
+		if err := db.ReplaceUserRoles(ctx, user.Id, managed, groupRoleIds); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	user = &db.User{
+		Name:    name,
+		Account: account,
+		Source:  source,
+	}
+	if err := db.CreateUserWithRoles(ctx, user, append(defaultRoleIds, groupRoleIds...)); err != nil {
+		return nil, err
+	}
+	logger.Info("external user provisioned", zap.Int64("user_id", user.Id),
+		zap.String("account", user.Account), zap.String("source", source))
+	return user, nil
+}
+
+// resolveConfigRoles 解析配置中的角色名，不存在的角色只记录警告
+func resolveConfigRoles(ctx context.Context, names utils.RoleNames) ([]int64, error) {
+	ids, unknown, err := db.ResolveRoleNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		logger.Warn("unknown roles in config", zap.Strings("roles", unknown))
+	}
+	return ids, nil
+}
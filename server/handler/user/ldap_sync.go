@@ -0,0 +1,74 @@
+package user
+
+import (
+	"context"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils/ldap"
+	"pionex-administrative-sys/utils/logger"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StartLDAPSync 按配置周期同步目录，停用目录中已不存在的 LDAP 用户，ctx 取消时退出
+func StartLDAPSync(ctx context.Context) {
+	interval := ldap.Conf().SyncInterval
+	if !ldap.Enabled() || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			if err := syncLDAPUsers(ctx); err != nil {
+				logger.Error("ldap sync failed", zap.Error(err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// syncLDAPUsers 停用目录中已不存在的 LDAP 用户并吊销其会话
+func syncLDAPUsers(ctx context.Context) error {
+	accounts, err := ldap.ListAccounts()
+	if err != nil {
+		return err
+	}
+	// 目录返回空结果多半是配置或权限问题，不据此停用所有用户
+	if len(accounts) == 0 {
+		logger.Warn("ldap sync returned no entries, skipped")
+		return nil
+	}
+
+	users, err := db.GetActiveUsersBySource(ctx, db.UserSourceLDAP)
+	if err != nil {
+		return err
+	}
+	var gone []int64
+	for _, u := range users {
+		if !accounts[u.Account] {
+			gone = append(gone, u.Id)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+
+	if err := db.DisableUsers(ctx, gone); err != nil {
+		return err
+	}
+	for _, id := range gone {
+		if err := db.RevokeUserTokens(ctx, id); err != nil {
+			logger.Warn("revoke tokens of disabled user failed", zap.Int64("user_id", id), zap.Error(err))
+		}
+		if err := db.RevokeUserAPITokens(ctx, id); err != nil {
+			logger.Warn("revoke api tokens of disabled user failed", zap.Int64("user_id", id), zap.Error(err))
+		}
+	}
+	logger.Info("ldap sync disabled users", zap.Int64s("user_ids", gone))
+	return nil
+}
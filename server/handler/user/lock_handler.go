@@ -0,0 +1,164 @@
+package user
+
+import (
+	"fmt"
+	"math"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/logger"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var (
+	dummyPwdHash     string
+	dummyPwdHashOnce sync.Once
+)
+
+// dummyCheckPwd 账号不存在时也计算一次密码哈希，使响应时间与密码错误一致
+func dummyCheckPwd(pwd string) {
+	dummyPwdHashOnce.Do(func() {
+		dummyPwdHash, _ = utils.HashPassword("pas-dummy-password")
+	})
+	_, _ = utils.VerifyPassword(dummyPwdHash, pwd)
+}
+
+// checkLoginLock 检查账号和客户端 IP 是否被锁定，锁定时直接返回错误
+func checkLoginLock(c *gin.Context, account string) bool {
+	ctx := c.Request.Context()
+	var remaining time.Duration
+	for _, t := range []struct{ kind, target string }{
+		{db.LoginFailureIp, c.ClientIP()},
+		{db.LoginFailureAccount, account},
+	} {
+		d, err := db.GetLoginLockRemaining(ctx, t.kind, t.target)
+		if err != nil {
+			utils.Resp(500, "登录失败", gin.H{"error": err.Error()}).Fail(c)
+			return true
+		}
+		remaining = max(remaining, d)
+	}
+	if remaining <= 0 {
+		return false
+	}
+	seconds := int64(math.Ceil(remaining.Seconds()))
+	utils.Resp(429, fmt.Sprintf("登录尝试过于频繁，请 %d 秒后重试", seconds), gin.H{
+		"retry_after": seconds,
+	}).Fail(c)
+	return true
+}
+
+// loginFailed 记录登录失败并返回错误信息
+func loginFailed(c *gin.Context, account, msg string) {
+	ctx := c.Request.Context()
+	for _, t := range []struct{ kind, target string }{
+		{db.LoginFailureIp, c.ClientIP()},
+		{db.LoginFailureAccount, account},
+	} {
+		f, err := db.RecordLoginFailure(ctx, t.kind, t.target)
+		if err != nil {
+			logger.Error("record login failure failed", zap.String("kind", t.kind), zap.String("target", t.target), zap.Error(err))
+			continue
+		}
+		if f.IsLocked() {
+			logger.Warn("login locked",
+				zap.String("kind", t.kind),
+				zap.String("target", t.target),
+				zap.Int("failures", f.Failures),
+				zap.Int64("locked_until", f.LockedUntil),
+			)
+		}
+	}
+	utils.Resp(401, msg, gin.H{}).Fail(c)
+}
+
+// loginSucceeded 登录成功后清除账号的失败记录，IP 记录保留到自然过期
+func loginSucceeded(c *gin.Context, account string) {
+	if err := db.ResetLoginFailure(c.Request.Context(), db.LoginFailureAccount, account); err != nil {
+		logger.Warn("reset login failure failed", zap.String("account", account), zap.Error(err))
+	}
+}
+
+// LockItem 锁定记录
+type LockItem struct {
+	Kind         string `json:"kind"` // account/ip
+	Target       string `json:"target"`
+	Failures     int    `json:"failures"`
+	LastFailedAt int64  `json:"last_failed_at"`
+	LockedUntil  int64  `json:"locked_until"`
+}
+
+// lockListHandler 锁定中的账号和 IP 列表
+func lockListHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+	kind := c.DefaultQuery("kind", db.LoginFailureAccount)
+
+	offset := (page - 1) * size
+	locks, err := db.GetLockedLoginFailures(c.Request.Context(), kind, offset, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	total, _ := db.CountLockedLoginFailures(c.Request.Context(), kind)
+
+	list := make([]LockItem, 0, len(locks))
+	for _, l := range locks {
+		list = append(list, LockItem{
+			Kind:         l.Kind,
+			Target:       l.Target,
+			Failures:     l.Failures,
+			LastFailedAt: l.LastFailedAt,
+			LockedUntil:  l.LockedUntil,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":  list,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// UnlockReq 解锁请求
+type UnlockReq struct {
+	Kind   string `json:"kind"` // account/ip，默认 account
+	Target string `json:"target" binding:"required"`
+}
+
+// unlockHandler 解锁账号或 IP
+func unlockHandler(c *gin.Context) {
+	var req UnlockReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = db.LoginFailureAccount
+	}
+	if req.Kind != db.LoginFailureAccount && req.Kind != db.LoginFailureIp {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的类型"}).Fail(c)
+		return
+	}
+
+	if err := db.ResetLoginFailure(c.Request.Context(), req.Kind, req.Target); err != nil {
+		utils.Resp(500, "解锁失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "login_lock.unlock", db.AuditTargetLoginLock, req.Kind+":"+req.Target, nil, nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
@@ -0,0 +1,455 @@
+package user
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	totpIssuer         = "PAS"
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaMaxAttempts     = 5
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// mfaChallenge 密码校验通过后等待第二步验证的登录
+type mfaChallenge struct {
+	userId   int64
+	account  string
+	expireAt time.Time
+	attempts int
+}
+
+var (
+	mfaChallenges   = make(map[string]*mfaChallenge)
+	mfaChallengesMu sync.Mutex
+)
+
+// newMFAChallenge 创建第二步验证凭证
+func newMFAChallenge(user *db.User) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	mfaChallengesMu.Lock()
+	defer mfaChallengesMu.Unlock()
+	now := time.Now()
+	for k, v := range mfaChallenges {
+		if now.After(v.expireAt) {
+			delete(mfaChallenges, k)
+		}
+	}
+	mfaChallenges[token] = &mfaChallenge{
+		userId:   user.Id,
+		account:  user.Account,
+		expireAt: now.Add(mfaChallengeTTL),
+	}
+	return token, nil
+}
+
+// takeMFAChallenge 取出第二步验证凭证，每次尝试计数，超过次数作废
+func takeMFAChallenge(token string) *mfaChallenge {
+	mfaChallengesMu.Lock()
+	defer mfaChallengesMu.Unlock()
+	ch, ok := mfaChallenges[token]
+	if !ok {
+		return nil
+	}
+	ch.attempts++
+	if time.Now().After(ch.expireAt) || ch.attempts > mfaMaxAttempts {
+		delete(mfaChallenges, token)
+		return nil
+	}
+	return ch
+}
+
+func finishMFAChallenge(token string) {
+	mfaChallengesMu.Lock()
+	defer mfaChallengesMu.Unlock()
+	delete(mfaChallenges, token)
+}
+
+// verifySecondFactor 校验 TOTP 验证码或恢复码
+func verifySecondFactor(c *gin.Context, user *db.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	secret, err := user.DecryptTotpSecret()
+	if err != nil || secret == "" {
+		return false, err
+	}
+	if step, ok := utils.VerifyTOTP(secret, code, time.Now()); ok {
+		return db.UseTotpStep(c.Request.Context(), user.Id, step)
+	}
+	return db.UseRecoveryCode(c.Request.Context(), user.Id, hashRecoveryCode(code))
+}
+
+// generateRecoveryCodes 生成一组新的恢复码并保存哈希
+func generateRecoveryCodes(c *gin.Context, userId int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(secret[:recoveryCodeLength])
+		code := raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	if err := db.ReplaceRecoveryCodes(c.Request.Context(), userId, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	return utils.SHA256(strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", "")))
+}
+
+// LoginMFAReq 登录第二步请求
+type LoginMFAReq struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP 验证码或恢复码
+}
+
+// loginMFAHandler 登录第二步，校验验证码后签发 token
+func loginMFAHandler(c *gin.Context) {
+	var req LoginMFAReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	ch := takeMFAChallenge(req.MFAToken)
+	if ch == nil {
+		utils.Resp(401, "验证已过期，请重新登录", gin.H{}).Fail(c)
+		return
+	}
+	// 验证码错误同样计入失败次数，锁定期间不再校验
+	if checkLoginLock(c, ch.account) {
+		return
+	}
+
+	user, err := db.GetUserById(c.Request.Context(), ch.userId)
+	if err != nil {
+		finishMFAChallenge(req.MFAToken)
+		utils.Resp(401, "验证已过期，请重新登录", gin.H{}).Fail(c)
+		return
+	}
+
+	ok, err := verifySecondFactor(c, user, req.Code)
+	if err != nil {
+		utils.Resp(500, "验证失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !ok {
+		loginFailed(c, ch.account, "验证码错误")
+		return
+	}
+	finishMFAChallenge(req.MFAToken)
+	loginSucceeded(c, ch.account)
+
+	resp, err := issueTokens(c, user, "")
+	if err != nil {
+		utils.Resp(500, "token生成失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", resp).Success(c)
+}
+
+// MFAStatusResp 两步验证状态
+type MFAStatusResp struct {
+	Enabled            bool  `json:"enabled"`
+	Required           bool  `json:"required"` // 按策略是否必须启用
+	RecoveryCodesCount int64 `json:"recovery_codes_count"`
+}
+
+// mfaStatusHandler 当前用户的两步验证状态
+func mfaStatusHandler(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	required, err := db.UserNeedMFA(c.Request.Context(), user.Id)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	count, _ := db.CountUnusedRecoveryCodes(c.Request.Context(), user.Id)
+
+	utils.Resp(0, "success", MFAStatusResp{
+		Enabled:            user.TotpEnabled,
+		Required:           required,
+		RecoveryCodesCount: count,
+	}).Success(c)
+}
+
+// mfaSetupHandler 生成 TOTP 密钥，验证通过后才会启用
+func mfaSetupHandler(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user.TotpEnabled {
+		utils.Resp(400, "两步验证已启用", gin.H{}).Fail(c)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.Resp(500, "生成密钥失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	fields, err := db.TotpSecretFields(user.Id, secret)
+	if err != nil {
+		utils.Resp(500, "生成密钥失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	fields["totp_last_step"] = 0
+	if err := db.UpdateUserFields(c.Request.Context(), user.Id, fields); err != nil {
+		utils.Resp(500, "生成密钥失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(totpIssuer, user.Account, secret),
+	}).Success(c)
+}
+
+// MFACodeReq 验证码请求
+type MFACodeReq struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// mfaEnableHandler 校验认证器中的验证码并启用两步验证，返回恢复码
+func mfaEnableHandler(c *gin.Context) {
+	var req MFACodeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+	if user.TotpEnabled {
+		utils.Resp(400, "两步验证已启用", gin.H{}).Fail(c)
+		return
+	}
+	secret, err := user.DecryptTotpSecret()
+	if err != nil {
+		utils.Resp(500, "读取密钥失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if secret == "" {
+		utils.Resp(400, "请先生成密钥", gin.H{}).Fail(c)
+		return
+	}
+
+	step, ok := utils.VerifyTOTP(secret, req.Code, time.Now())
+	if !ok {
+		utils.Resp(400, "验证码错误", gin.H{}).Fail(c)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(c, user.Id)
+	if err != nil {
+		utils.Resp(500, "启用失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if err := db.UpdateUserFields(c.Request.Context(), user.Id, map[string]interface{}{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}); err != nil {
+		utils.Resp(500, "启用失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "user.2fa_enable", db.AuditTargetUser, user.Id, gin.H{"totp_enabled": false}, gin.H{"totp_enabled": true})
+
+	utils.Resp(0, "success", gin.H{
+		"recovery_codes": codes,
+	}).Success(c)
+}
+
+// MFADisableReq 关闭两步验证请求
+type MFADisableReq struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// mfaDisableHandler 关闭两步验证，需要同时校验密码和验证码
+func mfaDisableHandler(c *gin.Context) {
+	var req MFADisableReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+	if !user.TotpEnabled {
+		utils.Resp(400, "两步验证未启用", gin.H{}).Fail(c)
+		return
+	}
+
+	required, err := db.UserNeedMFA(c.Request.Context(), user.Id)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if required {
+		utils.Resp(403, "当前角色要求必须启用两步验证", gin.H{}).Fail(c)
+		return
+	}
+
+	if _, err := user.CheckPwd(req.Password); err != nil {
+		utils.Resp(400, "密码错误", gin.H{}).Fail(c)
+		return
+	}
+	ok, err := verifySecondFactor(c, user, req.Code)
+	if err != nil {
+		utils.Resp(500, "验证失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !ok {
+		utils.Resp(400, "验证码错误", gin.H{}).Fail(c)
+		return
+	}
+
+	if err := disableMFA(c, user.Id); err != nil {
+		utils.Resp(500, "关闭失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "user.2fa_disable", db.AuditTargetUser, user.Id, gin.H{"totp_enabled": true}, gin.H{"totp_enabled": false})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// mfaRecoveryCodesHandler 重新生成恢复码
+func mfaRecoveryCodesHandler(c *gin.Context) {
+	var req MFACodeReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+	if !user.TotpEnabled {
+		utils.Resp(400, "两步验证未启用", gin.H{}).Fail(c)
+		return
+	}
+
+	ok, err := verifySecondFactor(c, user, req.Code)
+	if err != nil {
+		utils.Resp(500, "验证失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !ok {
+		utils.Resp(400, "验证码错误", gin.H{}).Fail(c)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(c, user.Id)
+	if err != nil {
+		utils.Resp(500, "生成失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "user.2fa_recovery_codes", db.AuditTargetUser, user.Id, nil, nil)
+
+	utils.Resp(0, "success", gin.H{
+		"recovery_codes": codes,
+	}).Success(c)
+}
+
+func disableMFA(c *gin.Context, userId int64) error {
+	if err := db.UpdateUserFields(c.Request.Context(), userId, map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}); err != nil {
+		return err
+	}
+	return db.DeleteRecoveryCodes(c.Request.Context(), userId)
+}
+
+// mfaPolicyHandler 获取两步验证策略
+func mfaPolicyHandler(c *gin.Context) {
+	roles, err := db.GetMFARequiredRoles(c.Request.Context())
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"required_role_ids": roles,
+	}).Success(c)
+}
+
+// MFAPolicyReq 两步验证策略
+type MFAPolicyReq struct {
+	RequiredRoleIds *[]int64 `json:"required_role_ids" binding:"required"` // 拥有其中任一角色的用户必须启用两步验证
+}
+
+// updateMFAPolicyHandler 设置必须启用两步验证的角色
+func updateMFAPolicyHandler(c *gin.Context) {
+	var req MFAPolicyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if ok, err := db.ValidRoleIds(c.Request.Context(), *req.RequiredRoleIds); err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	} else if !ok {
+		utils.Resp(400, "参数错误", gin.H{"error": "角色不存在"}).Fail(c)
+		return
+	}
+
+	before, err := db.GetMFARequiredRoles(c.Request.Context())
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if err := db.SetMFARequiredRoles(c.Request.Context(), *req.RequiredRoleIds); err != nil {
+		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "setting.2fa_policy_update", db.AuditTargetSetting, db.SettingMFARequiredRoles,
+		gin.H{"required_role_ids": before}, gin.H{"required_role_ids": *req.RequiredRoleIds})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// MFAResetReq 重置两步验证请求
+type MFAResetReq struct {
+	Id int64 `json:"id" binding:"required"`
+}
+
+// resetMFAHandler 管理员为丢失认证器的用户重置两步验证
+func resetMFAHandler(c *gin.Context) {
+	var req MFAResetReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	target, err := db.GetUserById(c.Request.Context(), req.Id)
+	if err != nil {
+		utils.Resp(404, "用户不存在", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	if err := disableMFA(c, req.Id); err != nil {
+		utils.Resp(500, "重置失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if err := db.RevokeUserTokens(c.Request.Context(), req.Id); err != nil {
+		utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "user.2fa_reset", db.AuditTargetUser, req.Id,
+		gin.H{"totp_enabled": target.TotpEnabled}, gin.H{"totp_enabled": false})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
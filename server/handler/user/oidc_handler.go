@@ -0,0 +1,159 @@
+package user
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/logger"
+	"pionex-administrative-sys/utils/oidc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// oidcState 跳转到 IdP 前生成的授权上下文，回调时按 state 取回
+type oidcState struct {
+	verifier string
+	nonce    string
+	expireAt time.Time
+}
+
+var (
+	oidcStates   = make(map[string]*oidcState)
+	oidcStatesMu sync.Mutex
+)
+
+func newOIDCState() (string, *oidcState, error) {
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := utils.RandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+	st := &oidcState{
+		verifier: verifier,
+		nonce:    nonce,
+		expireAt: time.Now().Add(oidcStateTTL),
+	}
+	oidcStatesMu.Lock()
+	defer oidcStatesMu.Unlock()
+	now := time.Now()
+	for k, v := range oidcStates {
+		if now.After(v.expireAt) {
+			delete(oidcStates, k)
+		}
+	}
+	oidcStates[state] = st
+	return state, st, nil
+}
+
+// takeOIDCState 取出并作废授权上下文，每个 state 只能使用一次
+func takeOIDCState(state string) *oidcState {
+	oidcStatesMu.Lock()
+	defer oidcStatesMu.Unlock()
+	st, ok := oidcStates[state]
+	if !ok {
+		return nil
+	}
+	delete(oidcStates, state)
+	if time.Now().After(st.expireAt) {
+		return nil
+	}
+	return st
+}
+
+// oidcConfigHandler 单点登录是否可用，供登录页决定是否展示入口
+func oidcConfigHandler(c *gin.Context) {
+	utils.Resp(0, "success", gin.H{
+		"enabled": oidc.Enabled(),
+	}).Success(c)
+}
+
+// oidcAuthorizeHandler 生成跳转到 IdP 的授权地址
+func oidcAuthorizeHandler(c *gin.Context) {
+	if !oidc.Enabled() {
+		utils.Resp(404, "未启用单点登录", gin.H{}).Fail(c)
+		return
+	}
+
+	state, st, err := newOIDCState()
+	if err != nil {
+		utils.Resp(500, "单点登录失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	url, err := oidc.AuthCodeURL(c.Request.Context(), state, st.nonce, st.verifier)
+	if err != nil {
+		logger.Error("oidc authorize failed", zap.Error(err))
+		utils.Resp(502, "单点登录服务不可用", gin.H{}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"url":   url,
+		"state": state,
+	}).Success(c)
+}
+
+// OIDCCallbackReq IdP 回调参数，由登录页转发
+type OIDCCallbackReq struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// oidcCallbackHandler 用授权码完成单点登录，首次登录自动创建用户，已有同名的单点登录账号则直接关联，
+// 开启 link_local_accounts 时也可以关联邮箱一致的本地账号
+func oidcCallbackHandler(c *gin.Context) {
+	var req OIDCCallbackReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	st := takeOIDCState(req.State)
+	if st == nil {
+		utils.Resp(400, "登录已过期，请重新登录", gin.H{}).Fail(c)
+		return
+	}
+	identity, err := oidc.Exchange(c.Request.Context(), req.Code, st.verifier, st.nonce)
+	if err != nil {
+		logger.Warn("oidc exchange failed", zap.Error(err))
+		utils.Resp(401, "单点登录失败", gin.H{}).Fail(c)
+		return
+	}
+
+	user, err := provisionUser(c.Request.Context(), identity.Account, identity.Name, db.UserSourceOIDC,
+		linkableLocalUser(identity), oidc.Conf().DefaultRole, oidc.GroupRole(identity.Groups), utils.MappedRoleNames(oidc.Conf().GroupRoles))
+	if errors.Is(err, errSourceConflict) {
+		logger.Warn("oidc account conflicts with existing user", zap.String("account", identity.Account))
+		utils.Resp(403, "该账号已存在，不能使用单点登录", gin.H{}).Fail(c)
+		return
+	}
+	if err != nil {
+		utils.Resp(500, "单点登录失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	completeLogin(c, user)
+}
+
+// linkableLocalUser 开启 link_local_accounts 时，允许关联邮箱与已验证 email 一致的本地账号
+func linkableLocalUser(identity *oidc.Identity) func(*db.User) bool {
+	if !oidc.Conf().LinkLocalAccounts {
+		return nil
+	}
+	return func(user *db.User) bool {
+		return user.Source == db.UserSourceLocal && identity.EmailVerified && identity.Email != "" &&
+			strings.EqualFold(user.Email, identity.Email)
+	}
+}
@@ -0,0 +1,257 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/app"
+	"pionex-administrative-sys/utils/logger"
+	mailer "pionex-administrative-sys/utils/mail"
+	"pionex-administrative-sys/utils/pwdpolicy"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	passwordResetTTL = 30 * time.Minute
+	// 同一账号申请找回密码的最小间隔
+	passwordResetInterval = time.Minute
+	mailSendTimeout       = 30 * time.Second
+)
+
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// checkPwdPolicy 按密码策略检查新密码，userId 大于 0 时同时检查历史密码，不满足时返回违规原因
+func checkPwdPolicy(c *gin.Context, pwd, account string, userId int64) bool {
+	violations := pwdpolicy.Check(pwd, account)
+	if n := pwdpolicy.Conf().History; userId > 0 && n > 0 {
+		reused, err := db.IsPasswordReused(c.Request.Context(), userId, pwd, n)
+		if err != nil {
+			utils.Resp(500, "密码校验失败", gin.H{"error": err.Error()}).Fail(c)
+			return false
+		}
+		if reused {
+			violations = append(violations, pwdpolicy.ReusedViolation())
+		}
+	}
+	if len(violations) > 0 {
+		utils.Resp(400, "密码不符合安全策略："+violations.Error(), gin.H{"violations": violations}).Fail(c)
+		return false
+	}
+	return true
+}
+
+// CheckDefaultAdminPwd 启动时检查默认管理员是否仍在使用初始密码，按策略要求修改或拒绝启动
+func CheckDefaultAdminPwd(ctx context.Context) error {
+	admin, inUse, err := db.DefaultAdminPwdInUse(ctx)
+	if err != nil || !inUse {
+		return err
+	}
+	if pwdpolicy.Conf().DefaultAdmin == pwdpolicy.DefaultAdminRefuse {
+		return errors.New("default admin password is still in use, change it before starting the server")
+	}
+	if !admin.MustChangePwd {
+		if err := db.UpdateUserFields(ctx, admin.Id, map[string]interface{}{"must_change_pwd": true}); err != nil {
+			return err
+		}
+	}
+	logger.Warn("default admin password is still in use, it must be changed at next login",
+		zap.String("account", admin.Account))
+	return nil
+}
+
+// updateUserFields 更新用户字段，包含新密码时记录历史密码，保留策略允许的最大数量
+func updateUserFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	if _, ok := fields["pwd_hash"]; ok {
+		return db.UpdateUserPwd(ctx, id, fields, pwdpolicy.MaxHistory)
+	}
+	return db.UpdateUserFields(ctx, id, fields)
+}
+
+// afterPasswordChanged 修改密码后吊销所有会话并作废未使用的找回密码链接
+func afterPasswordChanged(c *gin.Context, userId int64) error {
+	if err := db.RevokeUserTokens(c.Request.Context(), userId); err != nil {
+		return err
+	}
+	return db.InvalidatePasswordResets(c.Request.Context(), userId)
+}
+
+// ChangePasswordReq 修改密码请求
+type ChangePasswordReq struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// changePasswordHandler 修改自己的密码，被要求修改密码时也可以调用
+func changePasswordHandler(c *gin.Context) {
+	var req ChangePasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	userId := middleware.GetCurrentClaims(c).UserId
+	if !checkPwdPolicy(c, req.Password, middleware.GetCurrentUser(c).Account, userId) {
+		return
+	}
+	fields, err := db.PwdFields(req.Password)
+	if err != nil {
+		utils.Resp(500, "修改失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	fields["must_change_pwd"] = false
+	before := loadUserSnapshot(c.Request.Context(), userId)
+	if err := db.UpdateUserPwd(c.Request.Context(), userId, fields, pwdpolicy.MaxHistory); err != nil {
+		utils.Resp(500, "修改失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "user.password_change", db.AuditTargetUser, userId, before, loadUserSnapshot(c.Request.Context(), userId))
+
+	// 修改密码后需要重新登录
+	if err := afterPasswordChanged(c, userId); err != nil {
+		utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// ForgotPasswordReq 找回密码请求
+type ForgotPasswordReq struct {
+	Account string `json:"account" binding:"required"`
+}
+
+// forgotPasswordHandler 向账号绑定的邮箱发送重置链接，无论账号是否存在都返回成功，避免账号枚举
+func forgotPasswordHandler(c *gin.Context) {
+	var req ForgotPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	if err := sendPasswordReset(c.Request.Context(), req.Account); err != nil {
+		logger.Warn("send password reset failed", zap.String("account", req.Account), zap.Error(err))
+	}
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// sendPasswordReset 生成找回密码 token 并异步发送邮件，外部认证和没有邮箱的账号直接忽略
+func sendPasswordReset(ctx context.Context, account string) error {
+	user, err := db.GetUserByAccount(ctx, account)
+	if err != nil {
+		return nil
+	}
+	if user.Email == "" || user.Disabled || (user.Source != "" && user.Source != db.UserSourceLocal) {
+		return nil
+	}
+	baseURL := strings.TrimRight(app.Conf().BaseURL, "/")
+	if baseURL == "" {
+		// 不使用请求的 Host 生成链接，防止伪造 Host 把 token 发到攻击者的地址
+		return errors.New("base_url not configured")
+	}
+
+	last, err := db.GetLastPasswordResetAt(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if time.Since(time.UnixMilli(last)) < passwordResetInterval {
+		return nil
+	}
+
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := db.CreatePasswordReset(ctx, &db.PasswordReset{
+		UserId:    user.Id,
+		TokenHash: utils.SHA256(raw),
+		ExpiresAt: time.Now().Add(passwordResetTTL).UnixMilli(),
+	}); err != nil {
+		return err
+	}
+
+	msg := &mailer.Message{
+		To:      []string{user.Email},
+		Subject: "PAS 找回密码",
+		Body: "你好 " + user.Name + "：\n\n" +
+			"我们收到了重置账号 " + user.Account + " 密码的申请，请在 30 分钟内打开以下链接设置新密码：\n\n" +
+			baseURL + "/static/html/reset.html?token=" + raw + "\n\n" +
+			"链接只能使用一次。如果不是你本人操作，请忽略此邮件。\n",
+	}
+	// 异步发送，响应时间不暴露账号是否存在
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := mailer.Send(ctx, msg); err != nil {
+			logger.Error("send password reset mail failed", zap.Int64("user_id", user.Id), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// ResetPasswordReq 重置密码请求
+type ResetPasswordReq struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// resetPasswordHandler 使用邮件中的一次性 token 重置密码
+func resetPasswordHandler(c *gin.Context) {
+	var req ResetPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokenHash := utils.SHA256(req.Token)
+	user, err := db.GetPasswordResetUser(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetInvalid) {
+			utils.Resp(400, "链接无效或已过期", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "重置失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !checkPwdPolicy(c, req.Password, user.Account, user.Id) {
+		return
+	}
+
+	fields, err := db.PwdFields(req.Password)
+	if err != nil {
+		utils.Resp(500, "重置失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	userId, err := db.ResetPassword(ctx, tokenHash, fields, pwdpolicy.MaxHistory)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetInvalid) {
+			utils.Resp(400, "链接无效或已过期", gin.H{}).Fail(c)
+			return
+		}
+		utils.Resp(500, "重置失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	// 未登录的操作，操作人为空
+	middleware.Audit(c, "user.password_reset", db.AuditTargetUser, userId, snapshotUser(ctx, user), loadUserSnapshot(ctx, userId))
+
+	// 重置后吊销所有会话，并解除账号的登录锁定
+	if err := db.RevokeUserTokens(ctx, userId); err != nil {
+		utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if err := db.ResetLoginFailure(ctx, db.LoginFailureAccount, user.Account); err != nil {
+		logger.Warn("reset login failure failed", zap.Int64("user_id", userId), zap.Error(err))
+	}
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RevealPrivateKeyReq 查看私钥请求，需要重新校验密码，已启用两步验证时还需要验证码
+type RevealPrivateKeyReq struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"`
+}
+
+// revealPrivateKeyHandler 重新认证后返回解密的私钥
+func revealPrivateKeyHandler(c *gin.Context) {
+	var req RevealPrivateKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	current := middleware.GetCurrentUser(c)
+	// 与登录共用失败计数，防止借此暴力猜测密码
+	if checkLoginLock(c, current.Account) {
+		return
+	}
+	user, err := authenticate(c.Request.Context(), current.Account, req.Password)
+	if err != nil {
+		if errors.Is(err, errAuthFailed) {
+			loginFailed(c, current.Account, "密码错误")
+			return
+		}
+		logger.Error("authenticate failed", zap.String("account", current.Account), zap.Error(err))
+		utils.Resp(503, "认证服务不可用", gin.H{}).Fail(c)
+		return
+	}
+	if user.Id != current.Id {
+		loginFailed(c, current.Account, "密码错误")
+		return
+	}
+	if user.TotpEnabled {
+		ok, err := verifySecondFactor(c, user, req.Code)
+		if err != nil {
+			utils.Resp(500, "验证失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+		if !ok {
+			loginFailed(c, current.Account, "验证码错误")
+			return
+		}
+	}
+	loginSucceeded(c, current.Account)
+
+	key, err := user.DecryptPrivateKey()
+	if err != nil {
+		logger.Error("decrypt private key failed", zap.Int64("user_id", user.Id), zap.Error(err))
+		utils.Resp(500, "解密失败", gin.H{}).Fail(c)
+		return
+	}
+	logger.Info("private key revealed", zap.Int64("user_id", user.Id), zap.String("ip", c.ClientIP()))
+	middleware.Audit(c, "user.private_key_reveal", db.AuditTargetUser, user.Id, nil, gin.H{"private_key_fp": user.PrivateKeyFp})
+
+	utils.Resp(0, "success", gin.H{
+		"private_key": key,
+	}).Success(c)
+}
@@ -0,0 +1,297 @@
+package user
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	inviteDefaultDays = 7
+	inviteMaxDays     = 365
+)
+
+// RegistrationItem 注册申请列表项
+type RegistrationItem struct {
+	Id         int64  `json:"id"`
+	Name       string `json:"name"`
+	Account    string `json:"account"`
+	Status     int    `json:"status"` // 0=待审核 1=已通过 2=已拒绝
+	UserId     int64  `json:"user_id"`
+	ReviewerId int64  `json:"reviewer_id"`
+	Reason     string `json:"reason"`
+	ReviewedAt int64  `json:"reviewed_at"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// registrationListHandler 注册申请列表，默认查询待审核
+func registrationListHandler(c *gin.Context) {
+	status, _ := strconv.Atoi(c.DefaultQuery("status", strconv.Itoa(db.RegistrationPending)))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+
+	list, err := db.GetRegistrations(c.Request.Context(), status, (page-1)*size, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	total, _ := db.CountRegistrations(c.Request.Context(), status)
+
+	items := make([]RegistrationItem, 0, len(list))
+	for _, r := range list {
+		items = append(items, RegistrationItem{
+			Id:         r.Id,
+			Name:       r.Name,
+			Account:    r.Account,
+			Status:     r.Status,
+			UserId:     r.UserId,
+			ReviewerId: r.ReviewerId,
+			Reason:     r.Reason,
+			ReviewedAt: r.ReviewedAt,
+			CreatedAt:  r.CreatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":  items,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// ApproveRegistrationReq 审核通过请求
+type ApproveRegistrationReq struct {
+	Id      int64   `json:"id" binding:"required"`
+	RoleIds []int64 `json:"role_ids"` // 角色，不传则默认为内置的登录角色
+}
+
+// approveRegistrationHandler 审核通过并按指定角色创建用户
+func approveRegistrationHandler(c *gin.Context) {
+	var req ApproveRegistrationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	roleIds, ok := resolveAssignRoles(c, req.RoleIds)
+	if !ok {
+		return
+	}
+
+	reviewerId := middleware.GetCurrentClaims(c).UserId
+	user, err := db.ApproveRegistration(c.Request.Context(), req.Id, roleIds, reviewerId)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrRegistrationNotFound):
+			utils.Resp(404, "申请不存在或已处理", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrAccountExists):
+			utils.Resp(400, "账号已存在", gin.H{}).Fail(c)
+		default:
+			utils.Resp(500, "审核失败", gin.H{"error": err.Error()}).Fail(c)
+		}
+		return
+	}
+
+	middleware.Audit(c, "registration.approve", db.AuditTargetRegistration, req.Id, nil, gin.H{
+		"user_id":  user.Id,
+		"account":  user.Account,
+		"role_ids": roleIds,
+	})
+
+	utils.Resp(0, "success", gin.H{
+		"user_id":  user.Id,
+		"account":  user.Account,
+		"role_ids": roleIds,
+	}).Success(c)
+}
+
+// RejectRegistrationReq 拒绝请求
+type RejectRegistrationReq struct {
+	Id     int64  `json:"id" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// rejectRegistrationHandler 拒绝注册申请
+func rejectRegistrationHandler(c *gin.Context) {
+	var req RejectRegistrationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	reviewerId := middleware.GetCurrentClaims(c).UserId
+	ok, err := db.RejectRegistration(c.Request.Context(), req.Id, reviewerId, req.Reason)
+	if err != nil {
+		utils.Resp(500, "审核失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !ok {
+		utils.Resp(404, "申请不存在或已处理", gin.H{}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "registration.reject", db.AuditTargetRegistration, req.Id, nil, gin.H{"reason": req.Reason})
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
+
+// InviteItem 邀请码列表项
+type InviteItem struct {
+	Id        int64   `json:"id"`
+	Prefix    string  `json:"prefix"`
+	RoleIds   []int64 `json:"role_ids"`
+	MaxUses   int     `json:"max_uses"`
+	UsedCount int     `json:"used_count"`
+	Note      string  `json:"note"`
+	Active    bool    `json:"active"`
+	ExpiresAt int64   `json:"expires_at"`
+	CreatedBy int64   `json:"created_by"`
+	RevokedAt int64   `json:"revoked_at"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+// inviteListHandler 邀请码列表
+func inviteListHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+
+	list, err := db.GetInviteCodes(c.Request.Context(), (page-1)*size, size)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	total, _ := db.CountInviteCodes(c.Request.Context())
+
+	items := make([]InviteItem, 0, len(list))
+	for _, v := range list {
+		items = append(items, InviteItem{
+			Id:        v.Id,
+			Prefix:    v.Prefix,
+			RoleIds:   v.RoleIds,
+			MaxUses:   v.MaxUses,
+			UsedCount: v.UsedCount,
+			Note:      v.Note,
+			Active:    v.IsActive(),
+			ExpiresAt: v.ExpiresAt,
+			CreatedBy: v.CreatedBy,
+			RevokedAt: v.RevokedAt,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+
+	utils.Resp(0, "success", gin.H{
+		"list":  items,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	}).Success(c)
+}
+
+// CreateInviteReq 创建邀请码请求
+type CreateInviteReq struct {
+	RoleIds       []int64 `json:"role_ids"`        // 注册后的角色，不传则默认为内置的登录角色
+	MaxUses       int     `json:"max_uses"`        // 可使用次数，默认 1
+	ExpiresInDays int     `json:"expires_in_days"` // 有效天数，默认 7，最长 365
+	Note          string  `json:"note"`
+}
+
+// createInviteHandler 创建邀请码，明文只在创建时返回一次
+func createInviteHandler(c *gin.Context) {
+	var req CreateInviteReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = inviteDefaultDays
+	}
+	if req.MaxUses < 0 {
+		utils.Resp(400, "参数错误", gin.H{"error": "可使用次数必须大于 0"}).Fail(c)
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > inviteMaxDays {
+		utils.Resp(400, "参数错误", gin.H{"error": "有效天数必须在 1-365 之间"}).Fail(c)
+		return
+	}
+	roleIds, ok := resolveAssignRoles(c, req.RoleIds)
+	if !ok {
+		return
+	}
+
+	raw, err := utils.RandomToken(12)
+	if err != nil {
+		utils.Resp(500, "创建失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	code := &db.InviteCode{
+		CodeHash:  utils.SHA256(raw),
+		Prefix:    raw[:4],
+		RoleIds:   roleIds,
+		MaxUses:   req.MaxUses,
+		Note:      req.Note,
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays).UnixMilli(),
+		CreatedBy: middleware.GetCurrentClaims(c).UserId,
+	}
+	if err := db.CreateInviteCode(c.Request.Context(), code); err != nil {
+		utils.Resp(500, "创建失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "invite.create", db.AuditTargetInvite, code.Id, nil, gin.H{
+		"prefix":     code.Prefix,
+		"role_ids":   code.RoleIds,
+		"max_uses":   code.MaxUses,
+		"note":       code.Note,
+		"expires_at": code.ExpiresAt,
+	})
+
+	utils.Resp(0, "success", gin.H{
+		"id":         code.Id,
+		"code":       raw,
+		"role_ids":   code.RoleIds,
+		"max_uses":   code.MaxUses,
+		"expires_at": code.ExpiresAt,
+	}).Success(c)
+}
+
+// revokeInviteHandler 作废邀请码
+func revokeInviteHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": "无效的邀请码ID"}).Fail(c)
+		return
+	}
+
+	ok, err := db.RevokeInviteCode(c.Request.Context(), id)
+	if err != nil {
+		utils.Resp(500, "作废失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !ok {
+		utils.Resp(404, "邀请码不存在", gin.H{}).Fail(c)
+		return
+	}
+	middleware.Audit(c, "invite.revoke", db.AuditTargetInvite, id, nil, nil)
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
@@ -0,0 +1,73 @@
+package user
+
+import (
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveAssignRoles 校验要分配的角色，未指定时使用默认的登录角色，默认角色同样不能超出操作人的权限
+func resolveAssignRoles(c *gin.Context, roleIds []int64) ([]int64, bool) {
+	if roleIds == nil {
+		ids, err := db.DefaultRoleIds(c.Request.Context())
+		if err != nil {
+			utils.Resp(500, "查询角色失败", gin.H{"error": err.Error()}).Fail(c)
+			return nil, false
+		}
+		roleIds = ids
+	}
+	return roleIds, checkAssignRoles(c, roleIds)
+}
+
+// checkAssignRoles 校验角色存在，且操作人拥有这些角色的全部权限，防止越权分配
+func checkAssignRoles(c *gin.Context, roleIds []int64) bool {
+	ctx := c.Request.Context()
+	ok, err := db.ValidRoleIds(ctx, roleIds)
+	if err != nil {
+		utils.Resp(500, "查询角色失败", gin.H{"error": err.Error()}).Fail(c)
+		return false
+	}
+	if !ok {
+		utils.Resp(400, "角色不存在", gin.H{}).Fail(c)
+		return false
+	}
+	perms, err := db.RolesPermissions(ctx, roleIds)
+	if err != nil {
+		utils.Resp(500, "查询角色失败", gin.H{"error": err.Error()}).Fail(c)
+		return false
+	}
+	if !middleware.GetCurrentPermissions(c).Contains(perms) {
+		utils.Resp(403, "不能分配超出自身权限的角色", gin.H{}).Fail(c)
+		return false
+	}
+	return true
+}
+
+// checkRoleChange 校验修改用户角色，新增和移除的角色都不能超出操作人的权限
+func checkRoleChange(c *gin.Context, userId int64, roleIds []int64) bool {
+	current, err := db.GetUserRoleIds(c.Request.Context(), userId)
+	if err != nil {
+		utils.Resp(500, "查询角色失败", gin.H{"error": err.Error()}).Fail(c)
+		return false
+	}
+	inNew := make(map[int64]bool, len(roleIds))
+	for _, id := range roleIds {
+		inNew[id] = true
+	}
+	inCurrent := make(map[int64]bool, len(current))
+	var changed []int64
+	for _, id := range current {
+		inCurrent[id] = true
+		if !inNew[id] {
+			changed = append(changed, id)
+		}
+	}
+	for _, id := range roleIds {
+		if !inCurrent[id] {
+			changed = append(changed, id)
+		}
+	}
+	return checkAssignRoles(c, changed)
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"errors"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/server/middleware"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/pwdpolicy"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// issueTokens 签发 access token 和 refresh token，family 为空时开启新的登录会话
+func issueTokens(c *gin.Context, user *db.User, family string) (*LoginResp, error) {
+	if family == "" {
+		var err error
+		if family, err = utils.RandomToken(16); err != nil {
+			return nil, err
+		}
+	}
+	jti, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := db.GetUserAccess(c.Request.Context(), user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token, err := utils.GenerateToken(user.Id, jti, accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateRefreshToken(c.Request.Context(), &db.RefreshToken{
+		UserId:          user.Id,
+		Family:          family,
+		TokenHash:       utils.SHA256(refreshToken),
+		AccessJti:       jti,
+		AccessExpiresAt: now.Add(accessTokenTTL).UnixMilli(),
+		ExpiresAt:       now.Add(refreshTokenTTL).UnixMilli(),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &LoginResp{
+		Token:             token,
+		ExpiresIn:         int64(accessTokenTTL.Seconds()),
+		RefreshToken:      refreshToken,
+		RefreshExpiresIn:  int64(refreshTokenTTL.Seconds()),
+		Permissions:       access.Permissions.List(),
+		CouponGrants:      access.CouponGrants,
+		Name:              user.Name,
+		PwdChangeRequired: user.MustChangePwd || user.PwdExpired(pwdpolicy.MaxAge()),
+	}, nil
+}
+
+// RefreshReq 刷新 token 请求
+type RefreshReq struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// refreshHandler 使用 refresh token 换取新的 token，旧 refresh token 同时作废
+func refreshHandler(c *gin.Context) {
+	var req RefreshReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	old, err := db.UseRefreshToken(c.Request.Context(), utils.SHA256(req.RefreshToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrRefreshTokenInvalid), errors.Is(err, db.ErrRefreshTokenReused):
+			utils.Resp(401, "refresh token 无效", gin.H{}).Fail(c)
+		case errors.Is(err, db.ErrRefreshTokenExpired):
+			utils.Resp(401, "refresh token 已过期", gin.H{}).Fail(c)
+		default:
+			utils.Resp(500, "刷新失败", gin.H{"error": err.Error()}).Fail(c)
+		}
+		return
+	}
+
+	user, err := db.GetUserById(c.Request.Context(), old.UserId)
+	if err != nil {
+		utils.Resp(401, "账号不存在", gin.H{}).Fail(c)
+		return
+	}
+	if user.Disabled {
+		utils.Resp(403, "账号已停用", gin.H{}).Fail(c)
+		return
+	}
+	access, err := db.GetUserAccess(c.Request.Context(), user.Id)
+	if err != nil {
+		utils.Resp(500, "刷新失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !access.Permissions.Has(db.PermLogin) {
+		utils.Resp(403, "账号无登录权限", gin.H{}).Fail(c)
+		return
+	}
+
+	resp, err := issueTokens(c, user, old.Family)
+	if err != nil {
+		utils.Resp(500, "token生成失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", resp).Success(c)
+}
+
+// logoutHandler 退出登录，吊销当前会话的所有 token
+func logoutHandler(c *gin.Context) {
+	claims := middleware.GetCurrentClaims(c)
+	if err := db.RevokeTokenByJti(c.Request.Context(), claims.ID); err != nil {
+		utils.Resp(500, "退出失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	utils.Resp(0, "success", gin.H{}).Success(c)
+}
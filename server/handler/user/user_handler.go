@@ -1,13 +1,18 @@
 package user
 
 import (
+	"errors"
 	"pionex-administrative-sys/db"
 	"pionex-administrative-sys/server/middleware"
 	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/app"
+	"pionex-administrative-sys/utils/logger"
+	"slices"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 // Register 注册路由
@@ -17,62 +22,169 @@ func Register(r gin.IRouter) {
 	// 公开接口
 	g.POST("/login", loginHandler)
 	g.POST("/register", registerHandler)
+	g.POST("/login/2fa", loginMFAHandler)
+	g.POST("/refresh", refreshHandler)
+	g.GET("/oidc/config", oidcConfigHandler)
+	g.GET("/oidc/authorize", oidcAuthorizeHandler)
+	g.POST("/oidc/callback", oidcCallbackHandler)
+	g.POST("/password/forgot", forgotPasswordHandler)
+	g.POST("/password/reset", resetPasswordHandler)
+
+	// 需要修改密码或按策略需要启用两步验证但尚未完成时，仍可访问的接口
+	p := g.Group("", middleware.AuthAllowSetup())
+	p.POST("/logout", middleware.RequireSession(), logoutHandler)
+	p.GET("/profile", profileHandler)
+	p.PUT("/profile/password", middleware.RequireSession(), changePasswordHandler)
+	p.GET("/profile/2fa", mfaStatusHandler)
+	p.POST("/profile/2fa/setup", middleware.RequireSession(), mfaSetupHandler)
+	p.POST("/profile/2fa/enable", middleware.RequireSession(), mfaEnableHandler)
 
 	// 需要登录权限的接口
 	g.Use(middleware.Auth())
-	g.GET("/profile", profileHandler)
-	g.PUT("/profile", updateProfileHandler)
-
-	// 需要管理员权限的接口
-	g.Use(middleware.RequireRole(db.RoleAdmin))
-	g.GET("/roles", allRoleHandler)
-	g.POST("/add", addUserHandler)
-	g.GET("/list", listHandler)
-	g.PUT("/update", updateHandler)
-	g.DELETE("/delete/:id", deleteHandler)
+	g.PUT("/profile", middleware.RequireSession(), updateProfileHandler)
+	g.POST("/profile/2fa/disable", middleware.RequireSession(), mfaDisableHandler)
+	g.POST("/profile/2fa/recovery-codes", middleware.RequireSession(), mfaRecoveryCodesHandler)
+	g.GET("/profile/tokens", apiTokenListHandler)
+	g.POST("/profile/tokens", middleware.RequireSession(), createAPITokenHandler)
+	g.DELETE("/profile/tokens/:id", middleware.RequireSession(), revokeAPITokenHandler)
+	g.POST("/profile/private-key/reveal", middleware.RequireSession(), revealPrivateKeyHandler)
+
+	// 用户管理接口，按操作校验权限
+	perm := middleware.RequirePermission
+	g.GET("/roles", perm(db.PermUserView), allRoleHandler)
+	g.POST("/add", perm(db.PermUserCreate), addUserHandler)
+	g.GET("/list", perm(db.PermUserView), listHandler)
+	g.PUT("/update", perm(db.PermUserUpdate), updateHandler)
+	g.DELETE("/delete/:id", perm(db.PermUserDelete), deleteHandler)
+	g.GET("/locks", perm(db.PermUserSecurity), lockListHandler)
+	g.POST("/unlock", perm(db.PermUserSecurity), unlockHandler)
+	g.GET("/2fa/policy", perm(db.PermUserSecurity), mfaPolicyHandler)
+	g.PUT("/2fa/policy", perm(db.PermUserSecurity), updateMFAPolicyHandler)
+	g.POST("/2fa/reset", perm(db.PermUserSecurity), resetMFAHandler)
+	g.GET("/registrations", perm(db.PermRegistrationReview), registrationListHandler)
+	g.POST("/registrations/approve", perm(db.PermRegistrationReview), approveRegistrationHandler)
+	g.POST("/registrations/reject", perm(db.PermRegistrationReview), rejectRegistrationHandler)
+	g.GET("/invites", perm(db.PermInviteManage), inviteListHandler)
+	g.POST("/invites", perm(db.PermInviteManage), createInviteHandler)
+	g.DELETE("/invites/:id", perm(db.PermInviteManage), revokeInviteHandler)
 }
 
 // RegisterReq 注册请求
 type RegisterReq struct {
-	Name     string `json:"name" binding:"required"`
-	Account  string `json:"account" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Name       string `json:"name" binding:"required"`
+	Account    string `json:"account" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	Email      string `json:"email"`       // 用于找回密码，可选
+	InviteCode string `json:"invite_code"` // 使用邀请码时直接创建用户，不需要审核
 }
 
-// registerHandler 用户注册
+// 注册结果状态
+const (
+	registerStatusActive  = "active"
+	registerStatusPending = "pending"
+)
+
+// registerHandler 用户注册，使用邀请码时直接创建用户，否则提交申请等待管理员审核
 func registerHandler(c *gin.Context) {
 	var req RegisterReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.Resp(400, "参数错误", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	req.InviteCode = strings.TrimSpace(req.InviteCode)
+	if req.Email != "" && !validEmail(req.Email) {
+		utils.Resp(400, "邮箱格式错误", gin.H{}).Fail(c)
+		return
+	}
+	if !checkPwdPolicy(c, req.Password, req.Account, 0) {
+		return
+	}
+	if req.InviteCode == "" && app.Conf().Register.DisableOpen {
+		utils.Resp(403, "未开放注册，请使用邀请码注册", gin.H{}).Fail(c)
+		return
+	}
 
-	// 检查账号是否已存在
-	if _, err := db.GetUserByAccount(c.Request.Context(), req.Account); err == nil {
+	// 检查账号是否已存在或已在审核中
+	ctx := c.Request.Context()
+	if _, err := db.GetUserByAccount(ctx, req.Account); err == nil {
 		utils.Resp(400, "账号已存在", gin.H{}).Fail(c)
 		return
 	}
+	if pending, err := db.HasPendingRegistration(ctx, req.Account); err != nil {
+		utils.Resp(500, "注册失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	} else if pending {
+		utils.Resp(400, "该账号已提交注册申请，请等待审核", gin.H{}).Fail(c)
+		return
+	}
 
-	// 创建用户
-	user := &db.User{
+	pwdHash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		utils.Resp(500, "注册失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+
+	if req.InviteCode != "" {
+		user := &db.User{
+			Name:    req.Name,
+			Account: req.Account,
+			Email:   req.Email,
+			PwdHash: pwdHash,
+		}
+		if err := db.RegisterWithInvite(ctx, utils.SHA256(req.InviteCode), user); err != nil {
+			if errors.Is(err, db.ErrInviteCodeInvalid) {
+				utils.Resp(400, "邀请码无效或已用完", gin.H{}).Fail(c)
+				return
+			}
+			utils.Resp(500, "注册失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+		middleware.Audit(c, "user.register", db.AuditTargetUser, user.Id, nil, loadUserSnapshot(ctx, user.Id))
+		utils.Resp(0, "success", gin.H{"account": req.Account, "status": registerStatusActive}).Success(c)
+		return
+	}
+
+	reg := &db.Registration{
 		Name:    req.Name,
 		Account: req.Account,
-		Md5Pwd:  utils.MD5(req.Password),
+		Email:   req.Email,
+		PwdHash: pwdHash,
 	}
-	if err := db.CreateUser(c.Request.Context(), user); err != nil {
+	if err := db.CreateRegistration(ctx, reg); err != nil {
 		utils.Resp(500, "注册失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	logger.Info("registration submitted", zap.String("account", req.Account))
+	middleware.Audit(c, "registration.submit", db.AuditTargetRegistration, reg.Id, nil, gin.H{
+		"name":    reg.Name,
+		"account": reg.Account,
+		"email":   reg.Email,
+	})
 
-	utils.Resp(0, "success", gin.H{"account": req.Account}).Success(c)
+	utils.Resp(0, "success", gin.H{"account": req.Account, "status": registerStatusPending}).Success(c)
+}
+
+// checkDepartment 校验部门存在，0 表示不属于任何部门
+func checkDepartment(c *gin.Context, deptId int64) bool {
+	if deptId == 0 {
+		return true
+	}
+	if _, err := db.GetDepartmentById(c.Request.Context(), deptId); err != nil {
+		utils.Resp(400, "部门不存在", gin.H{}).Fail(c)
+		return false
+	}
+	return true
 }
 
 // AddUserReq 管理员添加用户请求
 type AddUserReq struct {
-	Name     string `json:"name" binding:"required"`
-	Account  string `json:"account" binding:"required"`
-	Password string `json:"password" binding:"required"`
-	Role     *int   `json:"role"` // 权限位，不传则默认为 RoleLogin
+	Name          string  `json:"name" binding:"required"`
+	Account       string  `json:"account" binding:"required"`
+	Password      string  `json:"password" binding:"required"`
+	Email         string  `json:"email"`
+	RoleIds       []int64 `json:"role_ids"`        // 角色，不传则默认为内置的登录角色
+	DepartmentId  int64   `json:"department_id"`   // 所属部门
+	MustChangePwd bool    `json:"must_change_pwd"` // 下次登录必须修改密码
 }
 
 // addUserHandler 管理员添加用户
@@ -89,28 +201,45 @@ func addUserHandler(c *gin.Context) {
 		return
 	}
 
-	// 设置权限，默认为登录权限
-	role := db.RoleLogin.Role
-	if req.Role != nil {
-		role = *req.Role
+	// 设置角色，默认为登录角色
+	roleIds, ok := resolveAssignRoles(c, req.RoleIds)
+	if !ok {
+		return
+	}
+
+	if req.Email != "" && !validEmail(req.Email) {
+		utils.Resp(400, "邮箱格式错误", gin.H{}).Fail(c)
+		return
+	}
+	if !checkDepartment(c, req.DepartmentId) {
+		return
+	}
+	if !checkPwdPolicy(c, req.Password, req.Account, 0) {
+		return
 	}
 
 	// 创建用户
 	user := &db.User{
-		Name:    req.Name,
-		Account: req.Account,
-		Md5Pwd:  utils.MD5(req.Password),
-		Role:    role,
+		Name:          req.Name,
+		Account:       req.Account,
+		Email:         req.Email,
+		DepartmentId:  req.DepartmentId,
+		MustChangePwd: req.MustChangePwd,
+	}
+	if err := user.SetPwd(req.Password); err != nil {
+		utils.Resp(500, "创建用户失败", gin.H{"error": err.Error()}).Fail(c)
+		return
 	}
-	if err := db.CreateUser(c.Request.Context(), user); err != nil {
+	if err := db.CreateUserWithRoles(c.Request.Context(), user, roleIds); err != nil {
 		utils.Resp(500, "创建用户失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	middleware.Audit(c, "user.add", db.AuditTargetUser, user.Id, nil, loadUserSnapshot(c.Request.Context(), user.Id))
 
 	utils.Resp(0, "success", gin.H{
-		"id":      user.Id,
-		"account": req.Account,
-		"role":    role,
+		"id":       user.Id,
+		"account":  req.Account,
+		"role_ids": roleIds,
 	}).Success(c)
 }
 
@@ -122,12 +251,27 @@ type LoginReq struct {
 
 // LoginResp 登录响应
 type LoginResp struct {
-	Token     string `json:"token"`
-	ExpiresIn int64  `json:"expires_in"`
-	Role      int    `json:"role"` // 权限位: 1=admin, 2=login
-	Name      string `json:"name"`
+	Token             string          `json:"token"`
+	ExpiresIn         int64           `json:"expires_in"`
+	RefreshToken      string          `json:"refresh_token"`
+	RefreshExpiresIn  int64           `json:"refresh_expires_in"`
+	Permissions       []string        `json:"permissions"`             // 当前拥有的权限
+	CouponGrants      db.CouponGrants `json:"coupon_grants,omitempty"` // 按卡券类型授予的权限
+	Name              string          `json:"name"`
+	MFAEnrollRequired bool            `json:"mfa_enroll_required,omitempty"` // 按策略需要先启用两步验证
+	PwdChangeRequired bool            `json:"pwd_change_required,omitempty"` // 需要先修改密码
 }
 
+// LoginMFAResp 需要两步验证时的登录响应，使用 mfa_token 调用 /user/login/2fa 完成登录
+type LoginMFAResp struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// 账号不存在和密码错误使用相同的提示，避免账号枚举
+const loginFailedMsg = "账号或密码错误"
+
 // loginHandler 用户登录
 func loginHandler(c *gin.Context) {
 	var req LoginReq
@@ -136,48 +280,89 @@ func loginHandler(c *gin.Context) {
 		return
 	}
 
-	// 查询用户
-	user, err := db.GetUserByAccount(c.Request.Context(), req.Account)
-	if err != nil {
-		utils.Resp(401, "账号不存在", gin.H{"error": err.Error()}).Fail(c)
+	// 账号或 IP 失败次数过多时拒绝登录
+	if checkLoginLock(c, req.Account) {
 		return
 	}
 
-	// 校验密码
-	if err := user.CheckPwd(req.Password); err != nil {
-		utils.Resp(401, "密码错误", gin.H{"error": err.Error()}).Fail(c)
+	// 依次尝试各认证方式，账号不存在和密码错误返回相同信息，避免账号枚举
+	user, err := authenticate(c.Request.Context(), req.Account, req.Password)
+	if err != nil {
+		if errors.Is(err, errAuthFailed) {
+			loginFailed(c, req.Account, loginFailedMsg)
+			return
+		}
+		logger.Error("authenticate failed", zap.String("account", req.Account), zap.Error(err))
+		utils.Resp(503, "认证服务不可用", gin.H{}).Fail(c)
 		return
 	}
+	// 启用两步验证时，验证码通过后才清除失败记录
+	if !user.TotpEnabled {
+		loginSucceeded(c, req.Account)
+	}
 
-	// 校验登录权限
-	if !user.HasRole(db.RoleLogin) {
+	completeLogin(c, user)
+}
+
+// completeLogin 第一步认证通过后的公共流程：校验登录权限，按需进入两步验证，签发 token
+func completeLogin(c *gin.Context, user *db.User) {
+	// 校验账号状态和登录权限
+	if user.Disabled {
+		utils.Resp(403, "账号已停用", gin.H{}).Fail(c)
+		return
+	}
+	access, err := db.GetUserAccess(c.Request.Context(), user.Id)
+	if err != nil {
+		utils.Resp(500, "登录失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if !access.Permissions.Has(db.PermLogin) {
 		utils.Resp(403, "账号无登录权限", gin.H{}).Fail(c)
 		return
 	}
 
-	// 签发 JWT token (24小时有效)
-	expireDuration := 24 * time.Hour
-	token, err := utils.GenerateToken(user.Id, user.Role, expireDuration)
+	// 已启用两步验证，需要继续校验验证码
+	if user.TotpEnabled {
+		mfaToken, err := newMFAChallenge(user)
+		if err != nil {
+			utils.Resp(500, "登录失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+		utils.Resp(0, "success", LoginMFAResp{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(mfaChallengeTTL.Seconds()),
+		}).Success(c)
+		return
+	}
+
+	// 签发短期 access token 和可轮换的 refresh token
+	resp, err := issueTokens(c, user, "")
 	if err != nil {
 		utils.Resp(500, "token生成失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
 
-	utils.Resp(0, "success", LoginResp{
-		Token:     token,
-		ExpiresIn: int64(expireDuration.Seconds()),
-		Role:      user.Role,
-		Name:      user.Name,
-	}).Success(c)
+	// 按策略必须启用两步验证时，登录后只能访问绑定相关接口
+	if need, err := db.UserNeedMFA(c.Request.Context(), user.Id); err == nil {
+		resp.MFAEnrollRequired = need
+	}
+
+	utils.Resp(0, "success", resp).Success(c)
 }
 
 // UserItem 用户列表项
 type UserItem struct {
-	Id        int64  `json:"id"`
-	Name      string `json:"name"`
-	Account   string `json:"account"`
-	Role      int    `json:"role"` // 权限位: 1=admin, 2=login
-	CreatedAt int64  `json:"created_at"`
+	Id            int64   `json:"id"`
+	Name          string  `json:"name"`
+	Account       string  `json:"account"`
+	Email         string  `json:"email"`
+	RoleIds       []int64 `json:"role_ids"`
+	DepartmentId  int64   `json:"department_id"`
+	Source        string  `json:"source"`
+	Disabled      bool    `json:"disabled"`
+	MustChangePwd bool    `json:"must_change_pwd"`
+	CreatedAt     int64   `json:"created_at"`
 }
 
 // listHandler 用户列表
@@ -191,23 +376,59 @@ func listHandler(c *gin.Context) {
 		size = 10
 	}
 
+	// 按部门筛选，包含下级部门
+	filter := db.UserFilter{}
+	if deptStr := c.Query("department_id"); deptStr != "" {
+		deptId, err := strconv.ParseInt(deptStr, 10, 64)
+		if err != nil {
+			utils.Resp(400, "参数错误", gin.H{"error": "无效的部门ID"}).Fail(c)
+			return
+		}
+		if deptId == 0 {
+			// 未分配部门的用户
+			filter.DepartmentIds = []int64{0}
+		} else if filter.DepartmentIds, err = db.GetDepartmentSubtreeIds(c.Request.Context(), deptId); err != nil {
+			utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+	}
+
 	offset := (page - 1) * size
-	users, err := db.GetUserList(c.Request.Context(), offset, size)
+	users, err := db.GetUserListWithFilter(c.Request.Context(), filter, offset, size)
 	if err != nil {
 		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
 
-	total, _ := db.CountUsers(c.Request.Context())
+	total, _ := db.CountUsersWithFilter(c.Request.Context(), filter)
+
+	userIds := make([]int64, 0, len(users))
+	for _, u := range users {
+		userIds = append(userIds, u.Id)
+	}
+	roleMap, err := db.GetUsersRoleIds(c.Request.Context(), userIds)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
 
 	list := make([]UserItem, 0, len(users))
 	for _, u := range users {
+		roleIds := roleMap[u.Id]
+		if roleIds == nil {
+			roleIds = []int64{}
+		}
 		list = append(list, UserItem{
-			Id:        u.Id,
-			Name:      u.Name,
-			Account:   u.Account,
-			Role:      u.Role,
-			CreatedAt: u.CreatedAt,
+			Id:            u.Id,
+			Name:          u.Name,
+			Account:       u.Account,
+			Email:         u.Email,
+			RoleIds:       roleIds,
+			DepartmentId:  u.DepartmentId,
+			Source:        u.Source,
+			Disabled:      u.Disabled,
+			MustChangePwd: u.MustChangePwd,
+			CreatedAt:     u.CreatedAt,
 		})
 	}
 
@@ -221,11 +442,15 @@ func listHandler(c *gin.Context) {
 
 // UpdateReq 更新请求
 type UpdateReq struct {
-	Id       int64   `json:"id" binding:"required"`
-	Name     *string `json:"name"`
-	Account  *string `json:"account"`
-	Password *string `json:"password"`
-	Role     *int    `json:"role"` // 权限位: 1=admin, 2=login
+	Id            int64    `json:"id" binding:"required"`
+	Name          *string  `json:"name"`
+	Account       *string  `json:"account"`
+	Password      *string  `json:"password"`
+	Email         *string  `json:"email"`
+	RoleIds       *[]int64 `json:"role_ids"`      // 替换用户的角色
+	DepartmentId  *int64   `json:"department_id"` // 为 0 时移出部门
+	Disabled      *bool    `json:"disabled"`
+	MustChangePwd *bool    `json:"must_change_pwd"`
 }
 
 // updateHandler 更新用户
@@ -250,22 +475,79 @@ func updateHandler(c *gin.Context) {
 		fields["account"] = *req.Account
 	}
 	if req.Password != nil && *req.Password != "" {
-		fields["md5_pwd"] = utils.MD5(*req.Password)
+		account, _ := fields["account"].(string)
+		if account == "" {
+			target, err := db.GetUserById(c.Request.Context(), req.Id)
+			if err != nil {
+				utils.Resp(400, "用户不存在", gin.H{}).Fail(c)
+				return
+			}
+			account = target.Account
+		}
+		if !checkPwdPolicy(c, *req.Password, account, req.Id) {
+			return
+		}
+		pwdFields, err := db.PwdFields(*req.Password)
+		if err != nil {
+			utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+		for k, v := range pwdFields {
+			fields[k] = v
+		}
+	}
+	if req.Email != nil {
+		if *req.Email != "" && !validEmail(*req.Email) {
+			utils.Resp(400, "邮箱格式错误", gin.H{}).Fail(c)
+			return
+		}
+		fields["email"] = *req.Email
 	}
-	if req.Role != nil {
-		fields["role"] = *req.Role
+	if req.Disabled != nil {
+		fields["disabled"] = *req.Disabled
+	}
+	if req.MustChangePwd != nil {
+		fields["must_change_pwd"] = *req.MustChangePwd
+	}
+	if req.DepartmentId != nil {
+		if !checkDepartment(c, *req.DepartmentId) {
+			return
+		}
+		fields["department_id"] = *req.DepartmentId
 	}
 
-	if len(fields) == 0 {
-		utils.Resp(400, "没有要更新的字段", gin.H{}).Fail(c)
+	if req.RoleIds != nil && !checkRoleChange(c, req.Id, *req.RoleIds) {
 		return
 	}
 
-	if err := db.UpdateUserFields(c.Request.Context(), req.Id, fields); err != nil {
-		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+	if len(fields) == 0 && req.RoleIds == nil {
+		utils.Resp(400, "没有要更新的字段", gin.H{}).Fail(c)
 		return
 	}
 
+	before := loadUserSnapshot(c.Request.Context(), req.Id)
+	if len(fields) > 0 {
+		if err := updateUserFields(c.Request.Context(), req.Id, fields); err != nil {
+			utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+	}
+	if req.RoleIds != nil {
+		if err := db.SetUserRoles(c.Request.Context(), req.Id, *req.RoleIds); err != nil {
+			utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+	}
+	middleware.Audit(c, "user.update", db.AuditTargetUser, req.Id, before, loadUserSnapshot(c.Request.Context(), req.Id))
+
+	// 修改密码或停用后吊销该用户的所有会话
+	if _, ok := fields["pwd_hash"]; ok || (req.Disabled != nil && *req.Disabled) {
+		if err := db.RevokeUserTokens(c.Request.Context(), req.Id); err != nil {
+			utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+	}
+
 	utils.Resp(0, "success", gin.H{}).Success(c)
 }
 
@@ -278,22 +560,37 @@ func deleteHandler(c *gin.Context) {
 		return
 	}
 
+	before := loadUserSnapshot(c.Request.Context(), id)
 	if err := db.DeleteUser(c.Request.Context(), id); err != nil {
 		utils.Resp(500, "删除失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	middleware.Audit(c, "user.delete", db.AuditTargetUser, id, before, nil)
+
+	// 吊销被删除用户的所有会话和 API token
+	if err := db.RevokeUserTokens(c.Request.Context(), id); err != nil {
+		utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	if err := db.RevokeUserAPITokens(c.Request.Context(), id); err != nil {
+		utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
 
 	utils.Resp(0, "success", gin.H{}).Success(c)
 }
 
 // ProfileResp 用户资料响应
 type ProfileResp struct {
-	Id         int64  `json:"id"`
-	Name       string `json:"name"`
-	Account    string `json:"account"`
-	Role       int    `json:"role"` // 权限位: 1=admin, 2=login
-	PrivateKey string `json:"private_key"`
-	CreatedAt  int64  `json:"created_at"`
+	Id                    int64           `json:"id"`
+	Name                  string          `json:"name"`
+	Account               string          `json:"account"`
+	Email                 string          `json:"email"`
+	Roles                 []string        `json:"roles"`                   // 角色名
+	Permissions           []string        `json:"permissions"`             // 当前请求可用的权限
+	CouponGrants          db.CouponGrants `json:"coupon_grants"`           // 当前请求可用的卡券类型授权
+	PrivateKeyFingerprint string          `json:"private_key_fingerprint"` // 私钥指纹，为空表示未设置，原文需通过 reveal 接口查看
+	CreatedAt             int64           `json:"created_at"`
 }
 
 // profileHandler 获取当前用户资料
@@ -305,27 +602,65 @@ func profileHandler(c *gin.Context) {
 		utils.Resp(404, "用户不存在", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	roles, err := db.GetUserRoleNames(c.Request.Context(), userId)
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
 
 	utils.Resp(0, "success", ProfileResp{
-		Id:         user.Id,
-		Name:       user.Name,
-		Account:    user.Account,
-		Role:       user.Role,
-		PrivateKey: user.PrivateKey,
-		CreatedAt:  user.CreatedAt,
+		Id:                    user.Id,
+		Name:                  user.Name,
+		Account:               user.Account,
+		Email:                 user.Email,
+		Roles:                 roles,
+		Permissions:           middleware.GetCurrentPermissions(c).List(),
+		CouponGrants:          middleware.GetCurrentCouponGrants(c),
+		PrivateKeyFingerprint: user.PrivateKeyFp,
+		CreatedAt:             user.CreatedAt,
 	}).Success(c)
 }
 
-// profileHandler 获取当前用户资料
+// RoleBrief 角色简要信息，用于分配角色
+type RoleBrief struct {
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Builtin     bool   `json:"builtin"`
+	Default     bool   `json:"default"` // 未指定角色时默认分配
+}
+
+// allRoleHandler 获取所有角色
 func allRoleHandler(c *gin.Context) {
+	roles, err := db.GetRoles(c.Request.Context())
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	defaults, err := db.DefaultRoleIds(c.Request.Context())
+	if err != nil {
+		utils.Resp(500, "查询失败", gin.H{"error": err.Error()}).Fail(c)
+		return
+	}
+	list := make([]RoleBrief, 0, len(roles))
+	for _, r := range roles {
+		list = append(list, RoleBrief{
+			Id:          r.Id,
+			Name:        r.Name,
+			Description: r.Description,
+			Builtin:     r.Builtin,
+			Default:     slices.Contains(defaults, r.Id),
+		})
+	}
 	utils.Resp(0, "success", gin.H{
-		"list": db.AllRoles(),
+		"list": list,
 	}).Success(c)
 }
 
 // UpdateProfileReq 更新资料请求
 type UpdateProfileReq struct {
 	Name       *string `json:"name"`
+	Email      *string `json:"email"`
 	Password   *string `json:"password"`
 	PrivateKey *string `json:"private_key"`
 }
@@ -344,11 +679,36 @@ func updateProfileHandler(c *gin.Context) {
 	if req.Name != nil && *req.Name != "" {
 		fields["name"] = *req.Name
 	}
+	if req.Email != nil {
+		if *req.Email != "" && !validEmail(*req.Email) {
+			utils.Resp(400, "邮箱格式错误", gin.H{}).Fail(c)
+			return
+		}
+		fields["email"] = *req.Email
+	}
 	if req.Password != nil && *req.Password != "" {
-		fields["md5_pwd"] = utils.MD5(*req.Password)
+		if !checkPwdPolicy(c, *req.Password, middleware.GetCurrentUser(c).Account, userId) {
+			return
+		}
+		pwdFields, err := db.PwdFields(*req.Password)
+		if err != nil {
+			utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+		for k, v := range pwdFields {
+			fields[k] = v
+		}
+		fields["must_change_pwd"] = false
 	}
 	if req.PrivateKey != nil {
-		fields["private_key"] = *req.PrivateKey
+		keyFields, err := db.PrivateKeyFields(userId, *req.PrivateKey)
+		if err != nil {
+			utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+		for k, v := range keyFields {
+			fields[k] = v
+		}
 	}
 
 	if len(fields) == 0 {
@@ -356,10 +716,20 @@ func updateProfileHandler(c *gin.Context) {
 		return
 	}
 
-	if err := db.UpdateUserFields(c.Request.Context(), userId, fields); err != nil {
+	before := loadUserSnapshot(c.Request.Context(), userId)
+	if err := updateUserFields(c.Request.Context(), userId, fields); err != nil {
 		utils.Resp(500, "更新失败", gin.H{"error": err.Error()}).Fail(c)
 		return
 	}
+	middleware.Audit(c, "user.profile_update", db.AuditTargetUser, userId, before, loadUserSnapshot(c.Request.Context(), userId))
+
+	// 修改密码后吊销所有会话和未使用的找回密码链接，需要重新登录
+	if _, ok := fields["pwd_hash"]; ok {
+		if err := afterPasswordChanged(c, userId); err != nil {
+			utils.Resp(500, "吊销会话失败", gin.H{"error": err.Error()}).Fail(c)
+			return
+		}
+	}
 
 	utils.Resp(0, "success", gin.H{}).Success(c)
 }
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pionex-administrative-sys/db"
+	"pionex-administrative-sys/utils/logger"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Audit 记录审计日志。before/after 为变更前后的快照，按 JSON 字段比较后只保存有变化的字段；
+// 新建时 before 为 nil，删除时 after 为 nil。快照中不能包含密码哈希、密钥等敏感数据。
+// 记录失败只写错误日志，不影响已完成的操作
+func Audit(c *gin.Context, action, targetType string, targetId interface{}, before, after interface{}) {
+	e := &db.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   fmt.Sprint(targetId),
+		IP:         c.ClientIP(),
+		RequestId:  GetRequestId(c),
+		CreatedAt:  time.Now().UnixMilli(),
+	}
+	if u := GetCurrentUser(c); u != nil {
+		e.ActorId = u.Id
+		e.Actor = u.Account
+	}
+	var err error
+	if e.Before, e.After, err = auditDiff(before, after); err != nil {
+		logger.Error("audit diff failed", zap.String("action", action), zap.Error(err))
+	}
+	// 客户端断开连接也要写入
+	if err := db.AppendAuditEvent(context.WithoutCancel(c.Request.Context()), e); err != nil {
+		logger.Error("append audit event failed",
+			zap.String("action", action),
+			zap.String("target_type", targetType),
+			zap.String("target_id", e.TargetId),
+			zap.Error(err),
+		)
+	}
+}
+
+// auditDiff 去掉前后相同的字段
+func auditDiff(before, after interface{}) (string, string, error) {
+	b, err := toAuditMap(before)
+	if err != nil {
+		return "", "", err
+	}
+	a, err := toAuditMap(after)
+	if err != nil {
+		return "", "", err
+	}
+	if b != nil && a != nil {
+		for k, v := range b {
+			if av, ok := a[k]; ok && reflect.DeepEqual(v, av) {
+				delete(b, k)
+				delete(a, k)
+			}
+		}
+	}
+	bs, err := encodeAuditMap(b)
+	if err != nil {
+		return "", "", err
+	}
+	as, err := encodeAuditMap(a)
+	return bs, as, err
+}
+
+func toAuditMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func encodeAuditMap(m map[string]interface{}) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
@@ -1,16 +1,24 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"pionex-administrative-sys/db"
 	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/logger"
+	"pionex-administrative-sys/utils/pwdpolicy"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 const (
-	ContextKeyClaims = "claims"
+	ContextKeyClaims       = "claims"
+	ContextKeyUser         = "user"
+	ContextKeyAPIToken     = "api_token"
+	ContextKeyPermissions  = "permissions"
+	ContextKeyCouponGrants = "coupon_grants"
 )
 
 func r(c *gin.Context, code int, data string) {
@@ -21,8 +29,17 @@ func r(c *gin.Context, code int, data string) {
 	c.Abort()
 }
 
-// Auth JWT 认证中间件
+// Auth JWT 认证中间件，必须修改密码、密码已过期或按策略必须启用两步验证但尚未启用的用户会被拒绝
 func Auth() gin.HandlerFunc {
+	return auth(true)
+}
+
+// AuthAllowSetup 不检查强制改密和两步验证策略的认证中间件，用于修改密码、绑定两步验证等接口
+func AuthAllowSetup() gin.HandlerFunc {
+	return auth(false)
+}
+
+func auth(enforceSetup bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从 Header 获取 token
 		authHeader := c.GetHeader("Authorization")
@@ -38,25 +55,142 @@ func Auth() gin.HandlerFunc {
 			return
 		}
 
-		// 解析 token
-		claims, err := utils.ParseToken(parts[1])
+		// 个人 API token 和 JWT 都可以认证
+		var (
+			claims   *utils.Claims
+			apiToken *db.APIToken
+			ok       bool
+		)
+		if strings.HasPrefix(parts[1], db.APITokenPrefix) {
+			apiToken, ok = parseAPIToken(c, parts[1])
+			if !ok {
+				return
+			}
+			claims = &utils.Claims{UserId: apiToken.UserId}
+		} else if claims, ok = parseJWT(c, parts[1]); !ok {
+			return
+		}
+
+		// 以用户当前状态为准，已删除或失去登录权限的用户立即失效
+		user, err := db.GetCachedUser(c.Request.Context(), claims.UserId)
 		if err != nil {
-			r(c, http.StatusUnauthorized, "无效的 token: "+err.Error())
+			r(c, http.StatusUnauthorized, "账号不存在")
+			return
+		}
+		if user.Disabled {
+			r(c, http.StatusUnauthorized, "账号已停用")
+			return
+		}
+		access, err := db.GetCachedUserAccess(c.Request.Context(), user.Id)
+		if err != nil {
+			r(c, http.StatusInternalServerError, "权限查询失败")
+			return
+		}
+		if !access.Permissions.Has(db.PermLogin) {
+			r(c, http.StatusUnauthorized, "账号无登录权限")
 			return
 		}
 
+		if enforceSetup && user.MustChangePwd {
+			r(c, http.StatusForbidden, "请先修改密码")
+			return
+		}
+		if enforceSetup && user.PwdExpired(pwdpolicy.MaxAge()) {
+			r(c, http.StatusForbidden, "密码已过期，请先修改密码")
+			return
+		}
+		if enforceSetup && !user.TotpEnabled {
+			need, err := db.UserNeedMFA(c.Request.Context(), user.Id)
+			if err != nil {
+				r(c, http.StatusInternalServerError, "两步验证策略查询失败")
+				return
+			}
+			if need {
+				r(c, http.StatusForbidden, "请先启用两步验证")
+				return
+			}
+		}
+
+		// API token 的权限不超过创建时指定的范围
+		perms := access.Permissions
+		grants := access.CouponGrants
+		if apiToken != nil {
+			tokenPerms := db.NewPermissionSet(apiToken.Permissions)
+			perms = perms.Intersect(tokenPerms)
+			grants = grants.Filter(tokenPerms)
+			c.Set(ContextKeyAPIToken, apiToken)
+		}
+
 		// 将用户信息存入上下文
 		c.Set(ContextKeyClaims, claims)
+		c.Set(ContextKeyUser, user)
+		c.Set(ContextKeyPermissions, perms)
+		c.Set(ContextKeyCouponGrants, grants)
+
+		c.Next()
+	}
+}
+
+// parseJWT 解析并校验 JWT，检查是否已被吊销
+func parseJWT(c *gin.Context, token string) (*utils.Claims, bool) {
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		r(c, http.StatusUnauthorized, "无效的 token: "+err.Error())
+		return nil, false
+	}
+
+	// 检查 token 是否已被吊销
+	if claims.ID == "" {
+		r(c, http.StatusUnauthorized, "token 已失效，请重新登录")
+		return nil, false
+	}
+	revoked, err := db.IsTokenRevoked(c.Request.Context(), claims.ID)
+	if err != nil {
+		r(c, http.StatusInternalServerError, "token 校验失败")
+		return nil, false
+	}
+	if revoked {
+		r(c, http.StatusUnauthorized, "token 已被吊销")
+		return nil, false
+	}
+	return claims, true
+}
 
+// parseAPIToken 校验个人 API token 并记录使用情况
+func parseAPIToken(c *gin.Context, token string) (*db.APIToken, bool) {
+	t, err := db.GetAPITokenByHash(c.Request.Context(), utils.SHA256(token))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrAPITokenInvalid):
+			r(c, http.StatusUnauthorized, "无效的 API token")
+		case errors.Is(err, db.ErrAPITokenExpired):
+			r(c, http.StatusUnauthorized, "API token 已过期")
+		default:
+			r(c, http.StatusInternalServerError, "token 校验失败")
+		}
+		return nil, false
+	}
+	if err := db.TouchAPIToken(c.Request.Context(), t, c.ClientIP()); err != nil {
+		logger.Warn("touch api token failed", zap.Int64("id", t.Id), zap.Error(err))
+	}
+	return t, true
+}
+
+// RequireSession 要求使用登录会话而不是 API token，用于管理 token 等敏感操作
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get(ContextKeyAPIToken); exists {
+			r(c, http.StatusForbidden, "API token 不能执行此操作")
+			return
+		}
 		c.Next()
 	}
 }
 
-// RequireRole 检查用户是否拥有指定权限
-func RequireRole(role db.CommonRole) gin.HandlerFunc {
+// RequirePermission 检查用户是否拥有指定权限，以数据库中角色的当前权限为准，API token 不超过其权限范围
+func RequirePermission(perm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		u := GetCurrentClaims(c)
-		if !u.HasRole(role.Role) {
+		if !GetCurrentPermissions(c).Has(perm) {
 			r(c, http.StatusForbidden, "权限不足")
 			return
 		}
@@ -64,6 +198,30 @@ func RequireRole(role db.CommonRole) gin.HandlerFunc {
 	}
 }
 
+// RequireCouponPermission 检查用户是否拥有卡券权限，全局权限或任一卡券类型的授权均可，具体类型由接口通过 CanAccessCouponType 校验
+func RequireCouponPermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetCurrentPermissions(c).Has(perm) && len(GetCurrentCouponGrants(c)[perm]) == 0 {
+			r(c, http.StatusForbidden, "权限不足")
+			return
+		}
+		c.Next()
+	}
+}
+
+// CanAccessCouponType 是否可以对指定类型的卡券执行操作
+func CanAccessCouponType(c *gin.Context, perm string, couponType int) bool {
+	return GetCurrentPermissions(c).Has(perm) || GetCurrentCouponGrants(c).Has(perm, couponType)
+}
+
+// AllowedCouponTypes 可以执行操作的卡券类型，all 为 true 时不限类型
+func AllowedCouponTypes(c *gin.Context, perm string) (types []int, all bool) {
+	if GetCurrentPermissions(c).Has(perm) {
+		return nil, true
+	}
+	return GetCurrentCouponGrants(c)[perm], false
+}
+
 // GetCurrentClaims 从上下文获取 Claims
 func GetCurrentClaims(c *gin.Context) *utils.Claims {
 	if claims, exists := c.Get(ContextKeyClaims); exists {
@@ -71,3 +229,27 @@ func GetCurrentClaims(c *gin.Context) *utils.Claims {
 	}
 	return nil
 }
+
+// GetCurrentUser 从上下文获取当前用户
+func GetCurrentUser(c *gin.Context) *db.User {
+	if user, exists := c.Get(ContextKeyUser); exists {
+		return user.(*db.User)
+	}
+	return nil
+}
+
+// GetCurrentPermissions 从上下文获取当前请求可用的权限
+func GetCurrentPermissions(c *gin.Context) db.PermissionSet {
+	if perms, exists := c.Get(ContextKeyPermissions); exists {
+		return perms.(db.PermissionSet)
+	}
+	return nil
+}
+
+// GetCurrentCouponGrants 从上下文获取当前请求可用的卡券类型授权
+func GetCurrentCouponGrants(c *gin.Context) db.CouponGrants {
+	if grants, exists := c.Get(ContextKeyCouponGrants); exists {
+		return grants.(db.CouponGrants)
+	}
+	return nil
+}
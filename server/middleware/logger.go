@@ -26,6 +26,7 @@ func Logger() gin.HandlerFunc {
 			zap.String("path", path),
 			zap.String("query", query),
 			zap.String("ip", c.ClientIP()),
+			zap.String("request_id", GetRequestId(c)),
 			zap.Duration("latency", latency),
 			zap.Int("size", c.Writer.Size()),
 		}
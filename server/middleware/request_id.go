@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"pionex-administrative-sys/utils"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HeaderRequestId     = "X-Request-Id"
+	ContextKeyRequestId = "request_id"
+)
+
+// 只接受简单字符的请求 ID，避免日志注入
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID 为每个请求分配 ID，优先使用上游网关传入的 ID，并在响应头中返回
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderRequestId)
+		if !requestIdPattern.MatchString(id) {
+			id, _ = utils.RandomToken(12)
+		}
+		c.Set(ContextKeyRequestId, id)
+		c.Header(HeaderRequestId, id)
+		c.Next()
+	}
+}
+
+// GetRequestId 从上下文获取请求 ID
+func GetRequestId(c *gin.Context) string {
+	return c.GetString(ContextKeyRequestId)
+}
@@ -3,17 +3,29 @@ package server
 import (
 	"context"
 	"net/http"
+	"pionex-administrative-sys/db"
 	"pionex-administrative-sys/server/handler"
+	"pionex-administrative-sys/server/handler/coupon"
+	"pionex-administrative-sys/server/handler/user"
 	"pionex-administrative-sys/static"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/app"
+	"pionex-administrative-sys/utils/ldap"
 	"pionex-administrative-sys/utils/logger"
+	"pionex-administrative-sys/utils/mail"
+	"pionex-administrative-sys/utils/oidc"
+	"pionex-administrative-sys/utils/pwdpolicy"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 type Server struct {
 	engine *gin.Engine
 	srv    *http.Server
 	addr   string
+	// 取消后台任务
+	cancel context.CancelFunc
 }
 
 func New(addr string) *Server {
@@ -28,6 +40,41 @@ func (s *Server) Init() {
 	gin.DefaultErrorWriter = logger.ErrorWriter()
 	gin.SetMode(gin.ReleaseMode)
 
+	if err := db.Init(); err != nil {
+		logger.Fatal("init db failed", zap.Error(err))
+	}
+	if err := utils.InitJWT(app.Conf().JWT, app.Home()); err != nil {
+		logger.Fatal("init jwt keys failed", zap.Error(err))
+	}
+	if err := utils.InitEncryption(app.Conf().Encryption, app.Home()); err != nil {
+		logger.Fatal("init encryption failed", zap.Error(err))
+	}
+	if n, err := db.EncryptPrivateKeys(context.Background()); err != nil {
+		logger.Fatal("encrypt private keys failed", zap.Error(err))
+	} else if n > 0 {
+		logger.Info("private keys encrypted", zap.Int("count", n))
+	}
+	if n, err := db.EncryptTotpSecrets(context.Background()); err != nil {
+		logger.Fatal("encrypt totp secrets failed", zap.Error(err))
+	} else if n > 0 {
+		logger.Info("totp secrets encrypted", zap.Int("count", n))
+	}
+	if err := oidc.Init(app.Conf().OIDC); err != nil {
+		logger.Fatal("init oidc failed", zap.Error(err))
+	}
+	if err := ldap.Init(app.Conf().LDAP); err != nil {
+		logger.Fatal("init ldap failed", zap.Error(err))
+	}
+	if err := mail.Init(app.Conf().Mail); err != nil {
+		logger.Fatal("init mail failed", zap.Error(err))
+	}
+	if err := pwdpolicy.Init(app.Conf().Password); err != nil {
+		logger.Fatal("init password policy failed", zap.Error(err))
+	}
+	if err := user.CheckDefaultAdminPwd(context.Background()); err != nil {
+		logger.Fatal("check default admin password failed", zap.Error(err))
+	}
+
 	s.engine = gin.New()
 	static.Register(s.engine)
 	handler.Register(s.engine)
@@ -38,9 +85,17 @@ func (s *Server) Init() {
 }
 
 func (s *Server) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	user.StartLDAPSync(ctx)
+	coupon.StartExpiryJob(ctx)
+	coupon.StartDistributionJob(ctx)
 	return s.srv.ListenAndServe()
 }
 
 func (s *Server) Shutdown(ctx context.Context) {
+	if s.cancel != nil {
+		s.cancel()
+	}
 	_ = s.srv.Shutdown(ctx)
 }
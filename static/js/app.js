@@ -1,10 +1,15 @@
 // app.js - 主应用逻辑
 
 const token = localStorage.getItem('token');
-const role = parseInt(localStorage.getItem('role') || '0');
-const isAdmin = (role & 1) !== 0; // 1 = admin 权限位
-const canStock = (role & 4) !== 0; // 4 = 库存管理权限位
-const canApplyCoupon = (role & 8) !== 0; // 8 = 卡券申请权限位
+const permissions = JSON.parse(localStorage.getItem('permissions') || '[]');
+const couponGrants = JSON.parse(localStorage.getItem('coupon_grants') || '{}'); // 按卡券类型授予的权限
+// 拥有全局权限或任一卡券类型的授权
+function hasCouponPerm(perm) {
+    return permissions.includes(perm) || (couponGrants[perm] || []).length > 0;
+}
+const isAdmin = permissions.includes('user.view'); // 用户管理权限
+const canStock = hasCouponPerm('coupon.view'); // 库存管理权限
+const canApplyCoupon = hasCouponPerm('coupon.take'); // 卡券申请权限
 const userName = localStorage.getItem('user_name') || '';
 if (!token) window.location.href = '/static/html/login.html';
 
@@ -18,6 +23,7 @@ const pageSize = 10;
 let totalUsers = 0;
 let userList = [];
 let roleList = []; // 系统角色列表
+let departmentList = []; // 部门列表
 
 // 卡券管理相关
 let couponPage = 1;
@@ -32,7 +38,7 @@ let totalMyCoupons = 0;
 let myCouponList = [];
 
 // 请求封装
-async function request(url, options = {}) {
+async function request(url, options = {}, retried = false) {
     const currentToken = localStorage.getItem('token');
     if (!currentToken) {
         window.location.href = '/static/html/login.html';
@@ -48,13 +54,48 @@ async function request(url, options = {}) {
     });
     const data = await resp.json();
     if (data.code === 401) {
-        localStorage.removeItem('token');
-        localStorage.removeItem('role');
+        // access token 过期时使用 refresh token 续期后重试一次
+        if (!retried && await refreshToken()) {
+            return request(url, options, true);
+        }
+        clearSession();
         window.location.href = '/static/html/login.html';
     }
     return data;
 }
 
+// 使用 refresh token 换取新的 token
+let refreshing = null;
+function refreshToken() {
+    const rt = localStorage.getItem('refresh_token');
+    if (!rt) return Promise.resolve(false);
+    if (!refreshing) {
+        refreshing = fetch('/api/v1/user/refresh', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: rt })
+        }).then(resp => resp.json()).then(data => {
+            if (data.code !== 0) return false;
+            localStorage.setItem('token', data.data.token);
+            localStorage.setItem('refresh_token', data.data.refresh_token);
+            localStorage.setItem('permissions', JSON.stringify(data.data.permissions || []));
+            localStorage.setItem('coupon_grants', JSON.stringify(data.data.coupon_grants || {}));
+            return true;
+        }).catch(() => false).finally(() => {
+            refreshing = null;
+        });
+    }
+    return refreshing;
+}
+
+function clearSession() {
+    localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
+    localStorage.removeItem('permissions');
+    localStorage.removeItem('coupon_grants');
+    localStorage.removeItem('user_name');
+}
+
 // ========== 角色相关 ==========
 async function loadRoles() {
     const data = await request('/api/v1/user/roles');
@@ -63,27 +104,69 @@ async function loadRoles() {
     }
 }
 
-function formatRoleTags(userRole) {
+function formatRoleTags(roleIds) {
+    const ids = roleIds || [];
     const tags = roleList
-        .filter(r => (userRole & r.Role) !== 0)
-        .map(r => `<span class="perm-tag perm-role-${r.Role}">${r.Name}</span>`);
+        .filter(r => ids.includes(r.id))
+        .map(r => `<span class="perm-tag perm-role-${r.id % 4}">${r.name}</span>`);
     return tags.length > 0 ? `<div class="permission-tags">${tags.join('')}</div>` : '-';
 }
 
-function renderRoleCheckboxes(userRole = 0) {
-    const container = document.getElementById('roleGrid');
+// 默认角色，新增用户和审核注册时默认勾选
+function defaultRoleIds() {
+    return roleList.filter(r => r.default).map(r => r.id);
+}
+
+function renderRoleCheckboxes(roleIds = [], containerId = 'roleGrid') {
+    const container = document.getElementById(containerId);
     container.innerHTML = roleList.map(r => `
-        <label class="role-item">
-            <input type="checkbox" name="roleCheck" value="${r.Role}" ${(userRole & r.Role) !== 0 ? 'checked' : ''}>
-            <span class="role-label">${r.Name}</span>
+        <label class="role-item" title="${r.description || ''}">
+            <input type="checkbox" name="roleCheck" value="${r.id}" ${roleIds.includes(r.id) ? 'checked' : ''}>
+            <span class="role-label">${r.name}</span>
         </label>
     `).join('');
 }
 
+function checkedRoleIds(containerId = 'roleGrid') {
+    return Array.from(document.querySelectorAll(`#${containerId} input[name="roleCheck"]:checked`))
+        .map(cb => parseInt(cb.value));
+}
+
+// ========== 部门相关 ==========
+async function loadDepartments() {
+    const data = await request('/api/v1/department/list');
+    if (data.code !== 0) return;
+    departmentList = data.data.list || [];
+
+    // 按层级排序并缩进显示
+    const ordered = [];
+    const walk = (parentId, depth) => {
+        departmentList.filter(d => d.parent_id === parentId).forEach(d => {
+            ordered.push({ ...d, depth });
+            walk(d.id, depth + 1);
+        });
+    };
+    walk(0, 0);
+    const options = ordered.map(d =>
+        `<option value="${d.id}">${'　'.repeat(d.depth)}${d.name}</option>`
+    ).join('');
+    document.getElementById('filterDepartment').innerHTML =
+        '<option value="">全部</option><option value="0">未分配</option>' + options;
+    document.getElementById('inputDepartment').innerHTML = '<option value="0">未分配</option>' + options;
+}
+
+function departmentName(id) {
+    const d = departmentList.find(v => v.id === id);
+    return d ? d.name : '-';
+}
+
 // ========== 用户相关 ==========
 
 async function loadUsers() {
-    const data = await request(`/api/v1/user/list?page=${currentPage}&size=${pageSize}`);
+    const deptFilter = document.getElementById('filterDepartment').value;
+    let url = `/api/v1/user/list?page=${currentPage}&size=${pageSize}`;
+    if (deptFilter !== '') url += `&department_id=${deptFilter}`;
+    const data = await request(url);
     if (data.code !== 0) {
         toast(data.msg, 'error');
         return;
@@ -96,7 +179,8 @@ async function loadUsers() {
             <td data-label="ID">${u.id}</td>
             <td data-label="昵称">${u.name}</td>
             <td data-label="账号">${u.account}</td>
-            <td data-label="权限">${formatRoleTags(u.role)}</td>
+            <td data-label="部门">${departmentName(u.department_id)}</td>
+            <td data-label="权限">${formatRoleTags(u.role_ids)}</td>
             <td data-label="创建时间">${formatTimestamp(u.created_at)}</td>
             <td class="actions">
                 <button class="btn btn-primary btn-sm" onclick="showEditModal(${u.id})">编辑</button>
@@ -121,8 +205,11 @@ function showAddModal() {
     document.getElementById('inputName').value = '';
     document.getElementById('inputAccount').value = '';
     document.getElementById('inputPassword').value = '';
-    // 渲染权限复选框，默认勾选登录权限(2)
-    renderRoleCheckboxes(2);
+    document.getElementById('inputEmail').value = '';
+    document.getElementById('inputMustChangePwd').checked = false;
+    document.getElementById('inputDepartment').value = '0';
+    // 渲染角色复选框，默认勾选默认角色
+    renderRoleCheckboxes(defaultRoleIds());
     document.getElementById('accountGroup').style.display = 'block';
     document.getElementById('roleGroup').style.display = 'block';
     document.getElementById('userModal').classList.add('show');
@@ -136,8 +223,11 @@ function showEditModal(id) {
     document.getElementById('inputName').value = user.name;
     document.getElementById('inputAccount').value = user.account;
     document.getElementById('inputPassword').value = '';
-    // 根据用户权限渲染复选框
-    renderRoleCheckboxes(user.role);
+    document.getElementById('inputEmail').value = user.email || '';
+    document.getElementById('inputMustChangePwd').checked = !!user.must_change_pwd;
+    document.getElementById('inputDepartment').value = String(user.department_id || 0);
+    // 根据用户角色渲染复选框
+    renderRoleCheckboxes(user.role_ids || []);
     document.getElementById('accountGroup').style.display = 'block';
     document.getElementById('roleGroup').style.display = 'block';
     document.getElementById('userModal').classList.add('show');
@@ -152,19 +242,22 @@ async function saveUser() {
     const name = document.getElementById('inputName').value.trim();
     const account = document.getElementById('inputAccount').value.trim();
     const password = document.getElementById('inputPassword').value;
+    const email = document.getElementById('inputEmail').value.trim();
+    const mustChangePwd = document.getElementById('inputMustChangePwd').checked;
+    const departmentId = parseInt(document.getElementById('inputDepartment').value || '0');
 
-    // 收集所有选中的权限位
-    let role = 0;
-    document.querySelectorAll('input[name="roleCheck"]:checked').forEach(cb => {
-        role |= parseInt(cb.value);
-    });
+    // 收集所有选中的角色
+    const roleIds = checkedRoleIds();
 
     if (id) {
         const body = { id: parseInt(id) };
         if (name) body.name = name;
         if (account) body.account = account;
         if (password) body.password = password;
-        body.role = role;
+        body.email = email;
+        body.role_ids = roleIds;
+        body.must_change_pwd = mustChangePwd;
+        body.department_id = departmentId;
 
         showLoading();
         try {
@@ -191,7 +284,7 @@ async function saveUser() {
         try {
             const data = await request('/api/v1/user/add', {
                 method: 'POST',
-                body: JSON.stringify({ name, account, password, role })
+                body: JSON.stringify({ name, account, password, email, role_ids: roleIds, department_id: departmentId, must_change_pwd: mustChangePwd })
             });
             if (data.code === 0) {
                 closeModal();
@@ -222,11 +315,91 @@ async function deleteUser(id) {
     }
 }
 
-function logout() {
-    localStorage.removeItem('token');
-    localStorage.removeItem('role');
-    localStorage.removeItem('user_name');
-    window.location.href = '/static/html/login.html';
+// ========== 注册审核 ==========
+let registrationList = [];
+
+async function loadRegistrations() {
+    const data = await request('/api/v1/user/registrations?status=0&size=100');
+    if (data.code !== 0) return;
+    registrationList = data.data.list || [];
+    document.getElementById('pendingCount').textContent = data.data.total;
+    document.getElementById('pendingRegistrations').style.display = registrationList.length > 0 ? 'block' : 'none';
+    document.getElementById('registrationTable').innerHTML = registrationList.map(r => `
+        <tr>
+            <td data-label="昵称">${r.name}</td>
+            <td data-label="账号">${r.account}</td>
+            <td data-label="申请时间">${formatTimestamp(r.created_at)}</td>
+            <td class="actions">
+                <button class="btn btn-primary btn-sm" onclick="showApproveModal(${r.id})">通过</button>
+                <button class="btn btn-danger btn-sm" onclick="rejectRegistration(${r.id})">拒绝</button>
+            </td>
+        </tr>
+    `).join('');
+}
+
+function showApproveModal(id) {
+    const r = registrationList.find(v => v.id === id);
+    if (!r) return;
+    document.getElementById('approveId').value = id;
+    document.getElementById('approveAccount').value = r.account;
+    // 默认勾选默认角色
+    renderRoleCheckboxes(defaultRoleIds(), 'approveRoleGrid');
+    document.getElementById('approveModal').classList.add('show');
+}
+
+function closeApproveModal() {
+    document.getElementById('approveModal').classList.remove('show');
+}
+
+async function approveRegistration() {
+    const id = parseInt(document.getElementById('approveId').value);
+    const roleIds = checkedRoleIds('approveRoleGrid');
+    showLoading();
+    try {
+        const data = await request('/api/v1/user/registrations/approve', {
+            method: 'POST',
+            body: JSON.stringify({ id, role_ids: roleIds })
+        });
+        if (data.code === 0) {
+            closeApproveModal();
+            toast('已通过', 'success');
+            await loadRegistrations();
+            await loadUsers();
+        } else {
+            toast(data.msg, 'error');
+        }
+    } finally {
+        hideLoading();
+    }
+}
+
+async function rejectRegistration(id) {
+    const reason = prompt('请输入拒绝原因（可选）');
+    if (reason === null) return;
+    showLoading();
+    try {
+        const data = await request('/api/v1/user/registrations/reject', {
+            method: 'POST',
+            body: JSON.stringify({ id, reason })
+        });
+        if (data.code === 0) {
+            toast('已拒绝', 'success');
+            await loadRegistrations();
+        } else {
+            toast(data.msg, 'error');
+        }
+    } finally {
+        hideLoading();
+    }
+}
+
+async function logout() {
+    try {
+        await request('/api/v1/user/logout', { method: 'POST' });
+    } finally {
+        clearSession();
+        window.location.href = '/static/html/login.html';
+    }
 }
 
 // ========== 用户下拉菜单 ==========
@@ -253,6 +426,7 @@ async function showSettingsModal() {
         const data = await request('/api/v1/user/profile');
         if (data.code === 0) {
             document.getElementById('settingsName').value = data.data.name || '';
+            document.getElementById('settingsEmail').value = data.data.email || '';
             document.getElementById('settingsPassword').value = '';
             document.getElementById('settingsModal').classList.add('show');
         } else {
@@ -270,8 +444,9 @@ function closeSettingsModal() {
 async function saveSettings() {
     const name = document.getElementById('settingsName').value.trim();
     const password = document.getElementById('settingsPassword').value;
+    const email = document.getElementById('settingsEmail').value.trim();
 
-    const body = {};
+    const body = { email };
     if (name) body.name = name;
     if (password) body.password = password;
 
@@ -286,6 +461,15 @@ async function saveSettings() {
 
         if (data.code === 0) {
             closeSettingsModal();
+            // 修改密码后所有会话已失效，需要重新登录
+            if (password) {
+                toast('密码已修改，请重新登录', 'success');
+                clearSession();
+                setTimeout(() => {
+                    window.location.href = '/static/html/login.html';
+                }, 1000);
+                return;
+            }
             toast('保存成功', 'success');
             // 更新页面上显示的用户名
             if (name) {
@@ -371,22 +555,27 @@ async function loadCouponTypes() {
     }
 }
 
+// 类型名称，带图标
+function couponTypeLabel(t) {
+    return t.icon ? `${t.icon} ${t.name}` : t.name;
+}
+
 function renderCouponTypeOptions() {
     // 筛选下拉
     const filterSelect = document.getElementById('filterCouponType');
     filterSelect.innerHTML = '<option value="">全部</option>' +
-        couponTypeList.map(t => `<option value="${t.type}">${t.name}</option>`).join('');
+        couponTypeList.map(t => `<option value="${t.type}">${couponTypeLabel(t)}</option>`).join('');
 
     // 添加/编辑卡券弹窗
     const inputSelect = document.getElementById('inputCouponType');
     inputSelect.innerHTML = couponTypeList.map(t =>
-        `<option value="${t.type}">${t.name}</option>`
+        `<option value="${t.type}">${couponTypeLabel(t)}</option>`
     ).join('');
 
     // 导入弹窗
     const importSelect = document.getElementById('importCouponType');
     importSelect.innerHTML = couponTypeList.map(t =>
-        `<option value="${t.type}">${t.name}</option>`
+        `<option value="${t.type}">${couponTypeLabel(t)}</option>`
     ).join('');
 }
 
@@ -395,14 +584,109 @@ function getCouponTypeName(type) {
     return t ? t.name : '未知类型';
 }
 
+// ========== 卡券有效期 ==========
+// 毫秒时间戳转为 datetime-local 输入框的值，0 表示不限制
+function toDatetimeLocal(ms) {
+    if (!ms) return '';
+    const d = new Date(ms);
+    const pad = n => String(n).padStart(2, '0');
+    return `${d.getFullYear()}-${pad(d.getMonth() + 1)}-${pad(d.getDate())}T${pad(d.getHours())}:${pad(d.getMinutes())}`;
+}
+
+// 读取 datetime-local 输入框为毫秒时间戳，留空为 0
+function readDatetimeLocal(id) {
+    const value = document.getElementById(id).value;
+    return value ? new Date(value).getTime() : 0;
+}
+
+function formatValidUntil(ms) {
+    return ms ? formatTimestamp(ms) : '长期有效';
+}
+
+// ========== 卡券状态 ==========
+const couponStates = {
+    issued: { label: '未领取', cls: 'status-available' },
+    taken: { label: '已领取', cls: 'status-taken' },
+    used: { label: '已使用', cls: 'status-used' },
+    returned: { label: '已退回', cls: 'status-returned' },
+    voided: { label: '已作废', cls: 'status-voided' }
+};
+
+function couponStateLabel(state) {
+    return couponStates[state] ? couponStates[state].label : state;
+}
+
+function couponStatusTag(c) {
+    const inStock = c.state === 'issued' || c.state === 'returned';
+    if (inStock && c.is_expired) return '<span class="status-tag status-expired">已过期</span>';
+    const s = couponStates[c.state] || { label: c.state, cls: '' };
+    return `<span class="status-tag ${s.cls}">${s.label}</span>`;
+}
+
+// 未使用和未作废的卡券可以作废
+function canVoidCoupon(c) {
+    if (c.state === 'used' || c.state === 'voided') return false;
+    return permissions.includes('coupon.void') || (couponGrants['coupon.void'] || []).includes(c.type);
+}
+
+async function voidCoupon(id) {
+    const reason = prompt('确定要作废该卡券吗？请输入作废原因（可选）');
+    if (reason === null) return;
+
+    showLoading();
+    try {
+        const data = await request('/api/v1/coupon/void', {
+            method: 'POST',
+            body: JSON.stringify({ id, reason })
+        });
+        if (data.code === 0) {
+            toast('已作废', 'success');
+            await loadCoupons();
+        } else {
+            toast(data.msg, 'error');
+        }
+    } finally {
+        hideLoading();
+    }
+}
+
+async function showCouponEvents(id) {
+    showLoading();
+    try {
+        const data = await request(`/api/v1/coupon/events/${id}`);
+        if (data.code !== 0) {
+            toast(data.msg, 'error');
+            return;
+        }
+        const events = data.data.list || [];
+        document.getElementById('couponEventsTable').innerHTML = events.map(e => `
+            <tr>
+                <td>${formatTimestamp(e.created_at)}</td>
+                <td>${e.from_state ? couponStateLabel(e.from_state) + ' → ' : '入库 → '}${couponStateLabel(e.to_state)}</td>
+                <td>${e.operator_name || (e.operator ? '#' + e.operator : '系统')}</td>
+                <td>${escapeHtml(e.note || '-')}</td>
+            </tr>
+        `).join('');
+        document.getElementById('couponEventsModal').classList.add('show');
+    } finally {
+        hideLoading();
+    }
+}
+
+function closeCouponEventsModal() {
+    document.getElementById('couponEventsModal').classList.remove('show');
+}
+
 // ========== 卡券列表 ==========
 async function loadCoupons() {
     const typeFilter = document.getElementById('filterCouponType').value;
-    const takenFilter = document.getElementById('filterTakenStatus').value;
+    const stateFilter = document.getElementById('filterCouponState').value;
+    const expiredFilter = document.getElementById('filterExpired').value;
 
     let url = `/api/v1/coupon/list?page=${couponPage}&size=${pageSize}`;
     if (typeFilter) url += `&type=${typeFilter}`;
-    if (takenFilter !== '') url += `&taken=${takenFilter}`;
+    if (stateFilter) url += `&state=${stateFilter}`;
+    if (expiredFilter !== '') url += `&expired=${expiredFilter}`;
 
     const data = await request(url);
     if (data.code !== 0) {
@@ -425,17 +709,11 @@ function renderCouponTable() {
             <td data-label="ID">${c.id}</td>
             <td data-label="卡券码" class="coupon-code">${c.coupon}</td>
             <td data-label="类型"><span class="type-tag">${c.type_name}</span></td>
-            <td data-label="状态">${c.is_taken ?
-                '<span class="status-tag status-taken">已领取</span>' :
-                '<span class="status-tag status-available">未领取</span>'}</td>
+            <td data-label="状态">${couponStatusTag(c)}</td>
             <td data-label="领取人">${c.is_taken ? (c.taker_name || '-') : '-'}</td>
+            <td data-label="有效期至">${formatValidUntil(c.valid_until)}</td>
             <td data-label="创建时间">${formatTimestamp(c.created_at)}</td>
-            <td class="actions">
-                ${!c.is_taken ? `
-                    <button class="btn btn-primary btn-sm" onclick="showEditCouponModal(${c.id})">编辑</button>
-                    <button class="btn btn-danger btn-sm" onclick="deleteCoupon(${c.id})">删除</button>
-                ` : '<span class="text-muted">-</span>'}
-            </td>
+            <td class="actions">${couponActions(c)}</td>
         </tr>
     `).join('');
 }
@@ -446,31 +724,41 @@ function renderCouponCards() {
         <div class="coupon-card">
             <div class="coupon-card-header">
                 <span class="coupon-card-id">#${c.id}</span>
-                ${c.is_taken ?
-                    '<span class="status-tag status-taken">已领取</span>' :
-                    '<span class="status-tag status-available">未领取</span>'}
+                ${couponStatusTag(c)}
             </div>
             <div class="coupon-card-code">${c.coupon}</div>
             <div class="coupon-card-info">
                 <span class="type-tag">${c.type_name}</span>
                 <span class="coupon-card-time">${formatTimestamp(c.created_at)}</span>
             </div>
+            ${c.valid_until ? `
+                <div class="coupon-card-taker">
+                    <span class="taker-label">有效期至：</span>
+                    <span class="taker-name">${formatTimestamp(c.valid_until)}</span>
+                </div>
+            ` : ''}
             ${c.is_taken && c.taker_name ? `
                 <div class="coupon-card-taker">
                     <span class="taker-label">领取人：</span>
                     <span class="taker-name">${c.taker_name}</span>
                 </div>
             ` : ''}
-            ${!c.is_taken ? `
-                <div class="coupon-card-actions">
-                    <button class="btn btn-primary btn-sm" onclick="showEditCouponModal(${c.id})">编辑</button>
-                    <button class="btn btn-danger btn-sm" onclick="deleteCoupon(${c.id})">删除</button>
-                </div>
-            ` : ''}
+            <div class="coupon-card-actions">${couponActions(c)}</div>
         </div>
     `).join('');
 }
 
+// 库存中的卡券可以编辑，入库后没有状态变更的可以删除
+function couponActions(c) {
+    const inStock = c.state === 'issued' || c.state === 'returned';
+    return `
+        ${inStock ? `<button class="btn btn-primary btn-sm" onclick="showEditCouponModal(${c.id})">编辑</button>` : ''}
+        ${c.state === 'issued' ? `<button class="btn btn-danger btn-sm" onclick="deleteCoupon(${c.id})">删除</button>` : ''}
+        ${canVoidCoupon(c) ? `<button class="btn btn-warning btn-sm" onclick="voidCoupon(${c.id})">作废</button>` : ''}
+        <button class="btn btn-sm" onclick="showCouponEvents(${c.id})">记录</button>
+    `;
+}
+
 function updateCouponPagination() {
     const totalPages = Math.ceil(totalCoupons / pageSize);
     document.getElementById('couponPageInfo').textContent = `第 ${couponPage} 页 / 共 ${totalPages} 页`;
@@ -488,6 +776,8 @@ function showAddCouponModal() {
     document.getElementById('couponModalTitle').textContent = '添加卡券';
     document.getElementById('couponEditId').value = '';
     document.getElementById('inputCouponCode').value = '';
+    document.getElementById('inputCouponValidFrom').value = '';
+    document.getElementById('inputCouponValidUntil').value = '';
     if (couponTypeList.length > 0) {
         document.getElementById('inputCouponType').value = couponTypeList[0].type;
     }
@@ -502,6 +792,8 @@ function showEditCouponModal(id) {
     document.getElementById('couponEditId').value = id;
     document.getElementById('inputCouponCode').value = coupon.coupon;
     document.getElementById('inputCouponType').value = coupon.type;
+    document.getElementById('inputCouponValidFrom').value = toDatetimeLocal(coupon.valid_from);
+    document.getElementById('inputCouponValidUntil').value = toDatetimeLocal(coupon.valid_until);
     document.getElementById('couponModal').classList.add('show');
 }
 
@@ -513,6 +805,8 @@ async function saveCoupon() {
     const id = document.getElementById('couponEditId').value;
     const coupon = document.getElementById('inputCouponCode').value.trim();
     const type = parseInt(document.getElementById('inputCouponType').value);
+    const valid_from = readDatetimeLocal('inputCouponValidFrom');
+    const valid_until = readDatetimeLocal('inputCouponValidUntil');
 
     if (!coupon) {
         toast('请输入卡券码', 'warning');
@@ -525,7 +819,7 @@ async function saveCoupon() {
             // 编辑
             const data = await request('/api/v1/coupon/update', {
                 method: 'PUT',
-                body: JSON.stringify({ id: parseInt(id), coupon, type })
+                body: JSON.stringify({ id: parseInt(id), coupon, type, valid_from, valid_until })
             });
             if (data.code === 0) {
                 closeCouponModal();
@@ -538,7 +832,7 @@ async function saveCoupon() {
             // 新增
             const data = await request('/api/v1/coupon/add', {
                 method: 'POST',
-                body: JSON.stringify({ coupon, type })
+                body: JSON.stringify({ coupon, type, valid_from, valid_until })
             });
             if (data.code === 0) {
                 closeCouponModal();
@@ -573,6 +867,8 @@ async function deleteCoupon(id) {
 // ========== 批量导入弹窗 ==========
 function showImportModal() {
     document.getElementById('importCoupons').value = '';
+    document.getElementById('importValidFrom').value = '';
+    document.getElementById('importValidUntil').value = '';
     if (couponTypeList.length > 0) {
         document.getElementById('importCouponType').value = couponTypeList[0].type;
     }
@@ -586,6 +882,8 @@ function closeImportModal() {
 async function importCoupons() {
     const coupons = document.getElementById('importCoupons').value.trim();
     const type = parseInt(document.getElementById('importCouponType').value);
+    const valid_from = readDatetimeLocal('importValidFrom');
+    const valid_until = readDatetimeLocal('importValidUntil');
 
     if (!coupons) {
         toast('请输入卡券码', 'warning');
@@ -598,7 +896,7 @@ async function importCoupons() {
     try {
         const data = await request('/api/v1/coupon/import', {
             method: 'POST',
-            body: JSON.stringify({ coupons, type })
+            body: JSON.stringify({ coupons, type, valid_from, valid_until })
         });
 
         if (data.code === 0) {
@@ -652,9 +950,11 @@ function closeImportResultModal() {
 // ========== 我的卡券 ==========
 async function loadMyCoupons() {
     const typeFilter = document.getElementById('filterMyCouponType').value;
+    const stateFilter = document.getElementById('filterMyCouponState').value;
 
     let url = `/api/v1/my-coupon/list?page=${myCouponPage}&size=${pageSize}`;
     if (typeFilter) url += `&type=${typeFilter}`;
+    if (stateFilter) url += `&state=${stateFilter}`;
 
     const data = await request(url);
     if (data.code !== 0) {
@@ -665,20 +965,65 @@ async function loadMyCoupons() {
     totalMyCoupons = data.data.total;
     myCouponList = data.data.list || [];
 
+    renderExpiryWarning(data.data.warnings || []);
+    loadTransferInbox();
     renderMyCouponTable();
     renderMyCouponCards();
     updateMyCouponPagination();
 }
 
+// 提示即将过期的卡券
+function renderExpiryWarning(warnings) {
+    const el = document.getElementById('myCouponWarning');
+    if (warnings.length === 0) {
+        el.style.display = 'none';
+        return;
+    }
+    el.textContent = `有 ${warnings.length} 张卡券即将过期：` + warnings.map(w =>
+        `${w.type_name} #${w.id}（${formatTimestamp(w.valid_until)}）`
+    ).join('、');
+    el.style.display = '';
+}
+
+// 转给我的、等待确认的转让
+async function loadTransferInbox() {
+    const el = document.getElementById('myTransferInbox');
+    const data = await request('/api/v1/my-coupon/transfers?box=in&status=pending&size=100');
+    const list = data.code === 0 ? (data.data.list || []) : [];
+    if (list.length === 0) {
+        el.style.display = 'none';
+        return;
+    }
+    el.innerHTML = list.map(t => `
+        <div class="transfer-inbox-item">
+            <span>${escapeHtml(t.from_name)} 向你转让了一张${escapeHtml(t.type_name)} #${t.coupon_id}${t.note ? '：' + escapeHtml(t.note) : ''}</span>
+            <span>
+                <button class="btn btn-success btn-sm" onclick="handleTransfer(${t.id}, 'accept')">接收</button>
+                <button class="btn btn-cancel btn-sm" onclick="handleTransfer(${t.id}, 'decline')">拒绝</button>
+            </span>
+        </div>
+    `).join('');
+    el.style.display = '';
+}
+
+function myCouponValidityTag(c) {
+    if (c.is_expired) return '<span class="status-tag status-expired">已过期</span>';
+    if (c.expiring_soon) return '<span class="status-tag status-expiring">即将过期</span>';
+    return '';
+}
+
 function renderMyCouponTable() {
     const tbody = document.getElementById('myCouponTable');
     tbody.innerHTML = myCouponList.map(c => `
         <tr>
             <td data-label="ID">${c.id}</td>
             <td data-label="类型"><span class="type-tag">${c.type_name}</span></td>
+            <td data-label="状态">${couponStatusTag(c)}</td>
             <td data-label="领取时间">${formatTimestamp(c.taken_at)}</td>
+            <td data-label="有效期至">${formatValidUntil(c.valid_until)} ${myCouponValidityTag(c)}</td>
             <td class="actions">
                 <button class="btn btn-primary btn-sm" onclick="showMyCouponDetail(${c.id})">详情</button>
+                ${myCouponActions(c)}
             </td>
         </tr>
     `).join('');
@@ -691,18 +1036,96 @@ function renderMyCouponCards() {
             <div class="my-coupon-card-header">
                 <span class="my-coupon-card-id">#${c.id}</span>
                 <span class="type-tag">${c.type_name}</span>
+                ${couponStatusTag(c)}
             </div>
             <div class="my-coupon-card-info">
                 <span class="my-coupon-card-label">领取时间</span>
                 <span class="my-coupon-card-time">${formatTimestamp(c.taken_at)}</span>
             </div>
+            <div class="my-coupon-card-info">
+                <span class="my-coupon-card-label">有效期至</span>
+                <span class="my-coupon-card-time">${formatValidUntil(c.valid_until)} ${myCouponValidityTag(c)}</span>
+            </div>
             <div class="my-coupon-card-actions">
                 <button class="btn btn-primary btn-sm" onclick="showMyCouponDetail(${c.id})">查看详情</button>
+                ${myCouponActions(c)}
             </div>
         </div>
     `).join('');
 }
 
+// 已领取未使用的卡券可以标记使用、退回或转让，转让中只能撤回
+function myCouponActions(c) {
+    if (c.state !== 'taken') return '';
+    if (c.transfer_id) {
+        return `<button class="btn btn-cancel btn-sm" onclick="handleTransfer(${c.transfer_id}, 'cancel')">撤回转让</button>`;
+    }
+    return `
+        <button class="btn btn-success btn-sm" onclick="changeMyCouponState(${c.id}, 'use')">已使用</button>
+        <button class="btn btn-cancel btn-sm" onclick="changeMyCouponState(${c.id}, 'return')">退回</button>
+        ${c.transferable ? `<button class="btn btn-primary btn-sm" onclick="transferMyCoupon(${c.id})">转让</button>` : ''}
+    `;
+}
+
+async function transferMyCoupon(id) {
+    const account = prompt('请输入接收人账号，对方确认后卡券转给对方');
+    if (!account || !account.trim()) return;
+
+    showLoading();
+    try {
+        const data = await request('/api/v1/my-coupon/transfer', {
+            method: 'POST',
+            body: JSON.stringify({ id, to_account: account.trim() })
+        });
+        if (data.code === 0) {
+            toast('已发起转让，等待对方确认', 'success');
+            await loadMyCoupons();
+        } else {
+            toast(data.msg, 'error');
+        }
+    } finally {
+        hideLoading();
+    }
+}
+
+// 接收、拒绝或撤回转让
+async function handleTransfer(id, action) {
+    const tips = { accept: '确定接收该卡券吗？', decline: '确定拒绝该转让吗？', cancel: '确定撤回该转让吗？' };
+    if (!confirm(tips[action])) return;
+
+    showLoading();
+    try {
+        const data = await request(`/api/v1/my-coupon/transfer/${action}/${id}`, { method: 'POST' });
+        if (data.code === 0) {
+            const done = { accept: data.data && data.data.status === 'approving' ? '已接收，等待审批' : '已接收', decline: '已拒绝', cancel: '已撤回' };
+            toast(done[action], 'success');
+            await loadMyCoupons();
+        } else {
+            toast(data.msg, 'error');
+        }
+    } finally {
+        hideLoading();
+    }
+}
+
+async function changeMyCouponState(id, action) {
+    const tip = action === 'use' ? '确定标记该卡券已使用吗？' : '确定退回该卡券吗？退回后卡券将重新进入库存';
+    if (!confirm(tip)) return;
+
+    showLoading();
+    try {
+        const data = await request(`/api/v1/my-coupon/${action}/${id}`, { method: 'POST' });
+        if (data.code === 0) {
+            toast(action === 'use' ? '已标记使用' : '已退回', 'success');
+            await loadMyCoupons();
+        } else {
+            toast(data.msg, 'error');
+        }
+    } finally {
+        hideLoading();
+    }
+}
+
 function updateMyCouponPagination() {
     const totalPages = Math.ceil(totalMyCoupons / pageSize);
     document.getElementById('myCouponPageInfo').textContent = `第 ${myCouponPage} 页 / 共 ${totalPages || 1} 页`;
@@ -728,6 +1151,8 @@ async function showMyCouponDetail(id) {
         document.getElementById('detailCouponType').textContent = detail.type_name;
         document.getElementById('detailCouponCode').textContent = detail.coupon;
         document.getElementById('detailTakenAt').textContent = formatTimestamp(detail.taken_at);
+        document.getElementById('detailValidUntil').textContent = formatValidUntil(detail.valid_until) +
+            (detail.is_expired ? '（已过期）' : '');
         document.getElementById('myCouponDetailModal').classList.add('show');
     } finally {
         hideLoading();
@@ -769,7 +1194,7 @@ function renderMyCouponTypeOptions() {
     const filterSelect = document.getElementById('filterMyCouponType');
     if (filterSelect) {
         filterSelect.innerHTML = '<option value="">全部</option>' +
-            couponTypeList.map(t => `<option value="${t.type}">${t.name}</option>`).join('');
+            couponTypeList.map(t => `<option value="${t.type}">${couponTypeLabel(t)}</option>`).join('');
     }
 }
 
@@ -778,7 +1203,7 @@ function showApplyCouponModal() {
     // 渲染卡券类型选项
     const select = document.getElementById('applyCouponType');
     select.innerHTML = couponTypeList.map(t =>
-        `<option value="${t.type}">${t.name}</option>`
+        `<option value="${t.type}">${couponTypeLabel(t)}</option>`
     ).join('');
 
     // 加载第一个类型的库存
@@ -808,6 +1233,7 @@ async function loadCouponStock(type) {
             const stock = data.data.stock;
             stockValue.textContent = stock > 0 ? stock : '0 (无库存)';
             stockValue.className = 'stock-value' + (stock > 0 ? ' has-stock' : ' no-stock');
+            renderQuota(data.data.quota);
         } else {
             stockValue.textContent = '查询失败';
         }
@@ -816,6 +1242,23 @@ async function loadCouponStock(type) {
     }
 }
 
+// 显示当前用户的剩余配额，没有配额策略时隐藏
+function renderQuota(quota) {
+    const info = document.getElementById('quotaInfo');
+    const hint = document.getElementById('quotaHint');
+    if (!quota || quota.unlimited) {
+        info.style.display = 'none';
+        hint.textContent = '';
+        return;
+    }
+    const value = document.getElementById('quotaValue');
+    value.textContent = quota.remaining;
+    value.className = 'stock-value' + (quota.remaining > 0 ? ' has-stock' : ' no-stock');
+    info.style.display = '';
+    hint.textContent = quota.description +
+        (quota.reset_at ? `，${formatTimestamp(quota.reset_at)} 后可再次领取` : '');
+}
+
 async function confirmApplyCoupon() {
     const type = parseInt(document.getElementById('applyCouponType').value);
 
@@ -862,6 +1305,7 @@ function showTakenCouponResult(couponData) {
     document.getElementById('detailCouponType').textContent = couponData.type_name;
     document.getElementById('detailCouponCode').textContent = couponData.coupon;
     document.getElementById('detailTakenAt').textContent = '刚刚领取';
+    document.getElementById('detailValidUntil').textContent = formatValidUntil(couponData.valid_until);
     document.getElementById('myCouponDetailModal').classList.add('show');
 }
 
@@ -887,7 +1331,9 @@ async function init() {
     // 确定默认页面
     if (isAdmin) {
         await loadRoles();
+        await loadDepartments();
         await loadUsers();
+        await loadRegistrations();
     } else if (canStock) {
         // 有库存权限，默认显示卡券管理页面
         switchPage('coupons');
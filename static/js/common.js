@@ -52,6 +52,13 @@ function hideLoading() {
     }
 }
 
+// 转义用户输入的文本，用于拼接 HTML
+function escapeHtml(str) {
+    return String(str).replace(/[&<>"']/g, ch => ({
+        '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'
+    })[ch]);
+}
+
 // 格式化13位时间戳为 yyyy-MM-dd HH:mm:ss
 function formatTimestamp(timestamp) {
     if (!timestamp) return '-';
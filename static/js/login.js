@@ -1,5 +1,47 @@
 // login.js - 登录页面逻辑
 
+// 处理登录接口的响应，需要两步验证时继续输入验证码
+async function finishLogin(data) {
+    // 已启用两步验证，继续输入验证码
+    if (data.code === 0 && data.data.mfa_required) {
+        const code = prompt('请输入认证器中的 6 位验证码或恢复码');
+        if (!code) {
+            toast('已取消登录', 'warning');
+            return;
+        }
+        const mfaResp = await fetch('/api/v1/user/login/2fa', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ mfa_token: data.data.mfa_token, code: code.trim() })
+        });
+        data = await mfaResp.json();
+    }
+
+    // 管理员要求修改密码，到修改密码页面设置新密码后重新登录
+    if (data.code === 0 && data.data.pwd_change_required) {
+        sessionStorage.setItem('pwd_change_token', data.data.token);
+        window.location.href = '/static/html/reset.html?change=1';
+        return;
+    }
+
+    if (data.code === 0) {
+        if (data.data.mfa_enroll_required) {
+            toast('当前账号要求启用两步验证，请在设置中完成绑定', 'warning', 5000);
+        }
+        toast('登录成功！', 'success');
+        localStorage.setItem('token', data.data.token);
+        localStorage.setItem('refresh_token', data.data.refresh_token);
+        localStorage.setItem('permissions', JSON.stringify(data.data.permissions || []));
+        localStorage.setItem('coupon_grants', JSON.stringify(data.data.coupon_grants || {}));
+        localStorage.setItem('user_name', data.data.name || '');
+        setTimeout(() => {
+            window.location.href = '/static/html/main.html';
+        }, 800);
+    } else {
+        toast(data.msg || '登录失败', 'error');
+    }
+}
+
 document.getElementById('loginForm').addEventListener('submit', async function(e) {
     e.preventDefault();
 
@@ -20,23 +62,74 @@ document.getElementById('loginForm').addEventListener('submit', async function(e
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ account, password })
         });
+        await finishLogin(await resp.json());
+    } catch (err) {
+        toast('网络错误，请重试', 'error');
+    } finally {
+        setBtnLoading(btn, false);
+    }
+});
 
+// 单点登录：跳转到 IdP，state 保存在本页会话中，回调时比对防止登录 CSRF
+document.getElementById('btnSSO').addEventListener('click', async function() {
+    const btn = this;
+    setBtnLoading(btn, true);
+    try {
+        const resp = await fetch('/api/v1/user/oidc/authorize');
         const data = await resp.json();
-
-        if (data.code === 0) {
-            toast('登录成功！', 'success');
-            localStorage.setItem('token', data.data.token);
-            localStorage.setItem('role', String(data.data.role || 0));
-            localStorage.setItem('user_name', data.data.name || '');
-            setTimeout(() => {
-                window.location.href = '/static/html/main.html';
-            }, 800);
-        } else {
-            toast(data.msg || '登录失败', 'error');
+        if (data.code !== 0) {
+            toast(data.msg || '单点登录失败', 'error');
+            setBtnLoading(btn, false);
+            return;
         }
+        sessionStorage.setItem('oidc_state', data.data.state);
+        window.location.href = data.data.url;
     } catch (err) {
         toast('网络错误，请重试', 'error');
-    } finally {
         setBtnLoading(btn, false);
     }
 });
+
+// 单点登录回调
+async function handleSSOCallback(params) {
+    const state = params.get('state');
+    const expected = sessionStorage.getItem('oidc_state');
+    sessionStorage.removeItem('oidc_state');
+    history.replaceState(null, '', window.location.pathname);
+
+    if (params.get('error')) {
+        toast('单点登录失败：' + params.get('error'), 'error');
+        return;
+    }
+    if (!state || state !== expected) {
+        toast('单点登录状态校验失败，请重试', 'error');
+        return;
+    }
+
+    try {
+        const resp = await fetch('/api/v1/user/oidc/callback', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code: params.get('code'), state })
+        });
+        await finishLogin(await resp.json());
+    } catch (err) {
+        toast('网络错误，请重试', 'error');
+    }
+}
+
+(async function() {
+    const params = new URLSearchParams(window.location.search);
+    if (params.has('state')) {
+        handleSSOCallback(params);
+    }
+    try {
+        const resp = await fetch('/api/v1/user/oidc/config');
+        const data = await resp.json();
+        if (data.code === 0 && data.data.enabled) {
+            document.getElementById('ssoLogin').style.display = '';
+        }
+    } catch (err) {
+        // 获取失败时不展示单点登录入口
+    }
+})();
@@ -7,6 +7,8 @@ document.getElementById('registerForm').addEventListener('submit', async functio
     const name = document.getElementById('name').value.trim();
     const account = document.getElementById('account').value.trim();
     const password = document.getElementById('password').value;
+    const email = document.getElementById('email').value.trim();
+    const inviteCode = document.getElementById('inviteCode').value.trim();
 
     if (!name || !account || !password) {
         toast('请填写完整信息', 'warning');
@@ -19,12 +21,14 @@ document.getElementById('registerForm').addEventListener('submit', async functio
         const resp = await fetch('/api/v1/user/register', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ name, account, password })
+            body: JSON.stringify({ name, account, password, email, invite_code: inviteCode })
         });
 
         const data = await resp.json();
 
-        if (data.code === 0) {
+        if (data.code === 0 && data.data.status === 'pending') {
+            toast('注册申请已提交，请等待管理员审核', 'success', 5000);
+        } else if (data.code === 0) {
             toast('注册成功！即将跳转登录...', 'success');
             setTimeout(() => {
                 window.location.href = '/static/html/login.html';
@@ -0,0 +1,101 @@
+// reset.js - 找回密码页面逻辑
+
+const params = new URLSearchParams(window.location.search);
+// 邮件中的重置 token
+const resetToken = params.get('token');
+// 登录后被要求修改密码时的会话 token
+const changeToken = params.has('change') ? sessionStorage.getItem('pwd_change_token') : null;
+
+if (resetToken || changeToken) {
+    if (changeToken) {
+        document.querySelector('.login-title').textContent = '修改密码';
+        toast('管理员要求你修改密码后再登录', 'warning', 5000);
+    }
+    document.getElementById('resetForm').style.display = '';
+    // 不在地址栏和历史记录中保留 token
+    history.replaceState(null, '', window.location.pathname);
+} else {
+    document.getElementById('forgotForm').style.display = '';
+}
+
+function submitNewPassword(password) {
+    if (changeToken) {
+        return fetch('/api/v1/user/profile/password', {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + changeToken },
+            body: JSON.stringify({ password })
+        });
+    }
+    return fetch('/api/v1/user/password/reset', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: resetToken, password })
+    });
+}
+
+document.getElementById('forgotForm').addEventListener('submit', async function(e) {
+    e.preventDefault();
+
+    const btn = document.getElementById('btnForgot');
+    const account = document.getElementById('account').value.trim();
+    if (!account) {
+        toast('请输入账号', 'warning');
+        return;
+    }
+
+    setBtnLoading(btn, true);
+
+    try {
+        const resp = await fetch('/api/v1/user/password/forgot', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ account })
+        });
+        const data = await resp.json();
+        if (data.code === 0) {
+            toast('如果账号已绑定邮箱，重置链接将发送到该邮箱', 'success', 5000);
+        } else {
+            toast(data.msg || '发送失败', 'error');
+        }
+    } catch (err) {
+        toast('网络错误，请重试', 'error');
+    } finally {
+        setBtnLoading(btn, false);
+    }
+});
+
+document.getElementById('resetForm').addEventListener('submit', async function(e) {
+    e.preventDefault();
+
+    const btn = document.getElementById('btnReset');
+    const password = document.getElementById('password').value;
+    const confirmPassword = document.getElementById('confirmPassword').value;
+    if (!password) {
+        toast('请输入新密码', 'warning');
+        return;
+    }
+    if (password !== confirmPassword) {
+        toast('两次输入的密码不一致', 'warning');
+        return;
+    }
+
+    setBtnLoading(btn, true);
+
+    try {
+        const resp = await submitNewPassword(password);
+        const data = await resp.json();
+        if (data.code === 0) {
+            sessionStorage.removeItem('pwd_change_token');
+            toast('密码已修改，即将跳转登录...', 'success');
+            setTimeout(() => {
+                window.location.href = '/static/html/login.html';
+            }, 1000);
+        } else {
+            toast(data.msg || '重置失败', 'error');
+        }
+    } catch (err) {
+        toast('网络错误，请重试', 'error');
+    } finally {
+        setBtnLoading(btn, false);
+    }
+});
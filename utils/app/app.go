@@ -26,6 +26,9 @@ func init() {
 	if err := utils.TryMkdir(logPath); err != nil {
 		panic(err.Error())
 	}
+	if err := loadConfig(); err != nil {
+		panic(err.Error())
+	}
 }
 
 func Home() string {
@@ -9,6 +9,7 @@ var (
 	port    = flag.String("p", "8080", "port to listen on")
 	daemon  = flag.Bool("d", false, "daemon process")
 	fileLog = flag.Bool("fl", false, "file log")
+	rotate  = flag.Bool("rk", false, "rotate jwt signing key and exit")
 )
 
 func Parse() {
@@ -26,3 +27,7 @@ func Daemon() bool {
 func FileLog() bool {
 	return *fileLog
 }
+
+func RotateKey() bool {
+	return *rotate
+}
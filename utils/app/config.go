@@ -0,0 +1,74 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"pionex-administrative-sys/utils"
+	"pionex-administrative-sys/utils/consts"
+	"pionex-administrative-sys/utils/ldap"
+	"pionex-administrative-sys/utils/mail"
+	"pionex-administrative-sys/utils/oidc"
+	"pionex-administrative-sys/utils/pwdpolicy"
+	"time"
+)
+
+// Config 配置文件，默认路径为 $PAS_HOME/config.json，可通过 PAS_CONFIG 指定
+type Config struct {
+	BaseURL  string           `json:"base_url"` // 对外访问地址，用于生成邮件中的链接，如 https://pas.example.com
+	JWT      utils.JWTConfig  `json:"jwt"`
+	OIDC     oidc.Config      `json:"oidc"`
+	LDAP     ldap.Config      `json:"ldap"`
+	Register RegisterConfig   `json:"register"`
+	Mail     mail.Config      `json:"mail"`
+	Password pwdpolicy.Config `json:"password"`
+	Coupon   CouponConfig     `json:"coupon"`
+	// 敏感字段加密
+	Encryption utils.EncryptionConfig `json:"encryption"`
+}
+
+// RegisterConfig 自助注册配置
+type RegisterConfig struct {
+	DisableOpen bool `json:"disable_open"` // 关闭公开注册，只能使用邀请码注册
+}
+
+// CouponConfig 卡券有效期配置
+type CouponConfig struct {
+	ExpiryInterval int `json:"expiry_interval"`  // 过期检查间隔（分钟），默认 10
+	ExpiryWarnDays int `json:"expiry_warn_days"` // 即将过期提醒的天数，默认 7
+}
+
+// ExpiryCheckInterval 过期检查间隔
+func (c CouponConfig) ExpiryCheckInterval() time.Duration {
+	if c.ExpiryInterval <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.ExpiryInterval) * time.Minute
+}
+
+// ExpiryWarnWindow 距离过期多久开始提醒
+func (c CouponConfig) ExpiryWarnWindow() time.Duration {
+	if c.ExpiryWarnDays <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(c.ExpiryWarnDays) * 24 * time.Hour
+}
+
+var conf Config
+
+func loadConfig() error {
+	path := utils.Env(consts.APP_CONFIG_KEY, filepath.Join(appHome, "config.json"))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &conf)
+}
+
+// Conf 获取配置
+func Conf() *Config {
+	return &conf
+}
@@ -1,6 +1,8 @@
 package consts
 
 const (
-	APP_HOME_KEY = "PAS_HOME"
-	HOME         = "HOME"
+	APP_HOME_KEY           = "PAS_HOME"
+	APP_CONFIG_KEY         = "PAS_CONFIG"
+	APP_ADMIN_PASSWORD_KEY = "PAS_ADMIN_PASSWORD"
+	HOME                   = "HOME"
 )
@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// 加密后的格式: enc:v1:<主密钥 kid>:<被主密钥加密的数据密钥>:<被数据密钥加密的数据>
+const sealedPrefix = "enc:v1:"
+
+var (
+	ErrEncryptionNotInit = errors.New("encryption not initialized")
+	ErrUnknownMasterKey  = errors.New("unknown master key")
+	ErrSealedFormat      = errors.New("invalid sealed value")
+)
+
+// EncryptionConfig 敏感字段加密配置，使用主密钥加密每条数据单独生成的数据密钥
+type EncryptionConfig struct {
+	MasterKey    string   `json:"master_key"`    // base64 编码的 32 字节主密钥，配置后不再读取密钥文件
+	KeyFile      string   `json:"key_file"`      // 主密钥文件，默认 $PAS_HOME/master.key，不存在时自动生成
+	PreviousKeys []string `json:"previous_keys"` // 轮换前的主密钥，只用于解密，启动时会用新主密钥重新加密数据密钥
+}
+
+type masterKey struct {
+	kid  string
+	aead cipher.AEAD
+}
+
+type masterKeySet struct {
+	active *masterKey
+	keys   map[string]*masterKey
+}
+
+var masterKeys atomic.Pointer[masterKeySet]
+
+// KeyFilePath 主密钥文件路径
+func (c EncryptionConfig) KeyFilePath(home string) string {
+	if c.KeyFile != "" {
+		return c.KeyFile
+	}
+	return filepath.Join(home, "master.key")
+}
+
+// InitEncryption 加载主密钥
+func InitEncryption(conf EncryptionConfig, home string) error {
+	encoded := conf.MasterKey
+	if encoded == "" {
+		var err error
+		if encoded, err = loadOrCreateMasterKey(conf.KeyFilePath(home)); err != nil {
+			return err
+		}
+	}
+	active, err := parseMasterKey(encoded)
+	if err != nil {
+		return err
+	}
+	ks := &masterKeySet{active: active, keys: map[string]*masterKey{active.kid: active}}
+	for _, s := range conf.PreviousKeys {
+		k, err := parseMasterKey(s)
+		if err != nil {
+			return err
+		}
+		ks.keys[k.kid] = k
+	}
+	masterKeys.Store(ks)
+	return nil
+}
+
+// GenerateMasterKey 生成 base64 编码的随机主密钥
+func GenerateMasterKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// IsSealed 是否为加密后的值
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, sealedPrefix)
+}
+
+// Seal 生成随机数据密钥加密明文，aad 绑定数据所在的位置，防止密文被挪到其他行使用
+func Seal(plaintext []byte, aad string) (string, error) {
+	ks := masterKeys.Load()
+	if ks == nil {
+		return "", ErrEncryptionNotInit
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	data, err := gcmSeal(dek, plaintext, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := wrapKey(ks.active, dek)
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + ks.active.kid + ":" + wrapped + ":" + data, nil
+}
+
+// Open 解密 Seal 生成的值
+func Open(sealed, aad string) ([]byte, error) {
+	ks := masterKeys.Load()
+	if ks == nil {
+		return nil, ErrEncryptionNotInit
+	}
+	kid, wrapped, data, err := splitSealed(sealed)
+	if err != nil {
+		return nil, err
+	}
+	mk, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMasterKey, kid)
+	}
+	dek, err := unwrapKey(mk, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrSealedFormat
+	}
+	return gcmOpen(dek, raw, []byte(aad))
+}
+
+// Rewrap 使用当前主密钥重新加密数据密钥，数据本身不变；已是当前主密钥时返回 false
+func Rewrap(sealed string) (string, bool, error) {
+	ks := masterKeys.Load()
+	if ks == nil {
+		return "", false, ErrEncryptionNotInit
+	}
+	kid, wrapped, data, err := splitSealed(sealed)
+	if err != nil {
+		return "", false, err
+	}
+	if kid == ks.active.kid {
+		return sealed, false, nil
+	}
+	mk, ok := ks.keys[kid]
+	if !ok {
+		return "", false, fmt.Errorf("%w %q", ErrUnknownMasterKey, kid)
+	}
+	dek, err := unwrapKey(mk, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if wrapped, err = wrapKey(ks.active, dek); err != nil {
+		return "", false, err
+	}
+	return sealedPrefix + ks.active.kid + ":" + wrapped + ":" + data, true, nil
+}
+
+// Fingerprint 敏感数据的指纹，用于展示而不暴露原文
+func Fingerprint(plaintext string) string {
+	h := sha256.Sum256([]byte(plaintext))
+	return "SHA256:" + hex.EncodeToString(h[:8])
+}
+
+// SensitiveString 写入数据库的敏感值，日志中不输出原文
+type SensitiveString string
+
+func (s SensitiveString) Value() (driver.Value, error) {
+	return string(s), nil
+}
+
+func (s SensitiveString) String() string {
+	return "[REDACTED]"
+}
+
+// LooksSensitive 判断日志参数是否为敏感值：加密数据、密码哈希或标记为敏感的值
+func LooksSensitive(v interface{}) bool {
+	switch s := v.(type) {
+	case SensitiveString, *SensitiveString:
+		return true
+	case string:
+		return IsSealed(s) || strings.HasPrefix(s, "$argon2") || strings.HasPrefix(s, "$2a$") ||
+			strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+	}
+	return false
+}
+
+func parseMasterKey(encoded string) (*masterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes encoded in base64")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(key)
+	return &masterKey{kid: hex.EncodeToString(h[:4]), aead: aead}, nil
+}
+
+func loadOrCreateMasterKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return string(data), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	key, err := GenerateMasterKey()
+	if err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(key+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return key, os.Rename(tmp, path)
+}
+
+func splitSealed(sealed string) (kid, wrapped, data string, err error) {
+	if !IsSealed(sealed) {
+		return "", "", "", ErrSealedFormat
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", "", "", ErrSealedFormat
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func wrapKey(mk *masterKey, dek []byte) (string, error) {
+	nonce := make([]byte, mk.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := mk.aead.Seal(nonce, nonce, dek, []byte(mk.kid))
+	return base64.RawStdEncoding.EncodeToString(out), nil
+}
+
+func unwrapKey(mk *masterKey, wrapped string) ([]byte, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil || len(raw) < mk.aead.NonceSize() {
+		return nil, ErrSealedFormat
+	}
+	n := mk.aead.NonceSize()
+	return mk.aead.Open(nil, raw[:n], raw[n:], []byte(mk.kid))
+}
+
+func gcmSeal(key, plaintext, aad []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+func gcmOpen(key, raw, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(raw) < n {
+		return nil, ErrSealedFormat
+	}
+	return aead.Open(nil, raw[:n], raw[n:], aad)
+}
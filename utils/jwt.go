@@ -2,46 +2,58 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("pas-secret-key")
-
 // Claims JWT claims
 type Claims struct {
 	UserId int64 `json:"user_id"`
-	Role   int   `json:"role"` // 权限位: 1=admin, 2=login
 	jwt.RegisteredClaims
 }
 
-// HasRole 检查是否拥有指定权限
-func (c Claims) HasRole(role int) bool {
-	return c.Role&role != 0
-}
+// GenerateToken 生成 JWT token，使用当前 active 密钥签名并在 header 中写入 kid，jti 用于吊销
+func GenerateToken(userId int64, jti string, expireDuration time.Duration) (string, error) {
+	ks := currentKeySet()
+	if ks == nil {
+		return "", ErrJWTKeysNotInit
+	}
+	k := ks.active
 
-// GenerateToken 生成 JWT token
-func GenerateToken(userId int64, role int, expireDuration time.Duration) (string, error) {
 	claims := Claims{
 		UserId: userId,
-		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expireDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "pas",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.signKey)
 }
 
-// ParseToken 解析 JWT token
+// ParseToken 解析 JWT token，按 kid 在 active 和 retiring 密钥中查找校验密钥
 func ParseToken(tokenString string) (*Claims, error) {
+	ks := currentKeySet()
+	if ks == nil {
+		return nil, ErrJWTKeysNotInit
+	}
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		k, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return k.verifyKey, nil
+	}, jwt.WithValidMethods(ks.algs()))
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +65,13 @@ func ParseToken(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
-// SetJWTSecret 设置 JWT 密钥
+// SetJWTSecret 使用单个 HS256 密钥替换当前密钥集
 func SetJWTSecret(secret string) {
-	jwtSecret = []byte(secret)
+	ks, _ := newKeySet([]JWTKeyDef{{
+		Kid:    "default",
+		Alg:    JWTAlgHS256,
+		Status: JWTKeyActive,
+		Secret: secret,
+	}})
+	setKeySet(ks)
 }
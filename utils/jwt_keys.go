@@ -0,0 +1,346 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgEdDSA = "EdDSA"
+	JWTAlgRS256 = "RS256"
+
+	JWTKeyActive   = "active"   // 用于签发和校验
+	JWTKeyRetiring = "retiring" // 只用于校验，等待旧 token 过期
+
+	// 轮换时保留的 retiring 密钥数量
+	maxRetiringKeys = 3
+)
+
+var (
+	ErrJWTKeysNotInit = errors.New("jwt keys not initialized")
+	ErrNoActiveJWTKey = errors.New("no active jwt key")
+)
+
+// JWTConfig JWT 签名配置
+type JWTConfig struct {
+	Alg     string      `json:"alg"`      // 自动生成密钥的算法: HS256(默认)/EdDSA/RS256
+	KeyFile string      `json:"key_file"` // 密钥文件，默认 $PAS_HOME/jwt_keys.json，不存在时自动生成
+	Keys    []JWTKeyDef `json:"keys"`     // 直接配置密钥，配置后不再读取密钥文件
+}
+
+// JWTKeyDef 密钥定义
+type JWTKeyDef struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"`
+	Status     string `json:"status"`                // active/retiring
+	Secret     string `json:"secret,omitempty"`      // HS256 密钥
+	PrivateKey string `json:"private_key,omitempty"` // EdDSA/RS256 私钥 PEM
+	PublicKey  string `json:"public_key,omitempty"`  // EdDSA/RS256 公钥 PEM，可只配置公钥用于校验
+}
+
+// jwtKeyFile 密钥文件格式
+type jwtKeyFile struct {
+	Keys []JWTKeyDef `json:"keys"`
+}
+
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type jwtKeySet struct {
+	active *jwtKey
+	keys   map[string]*jwtKey
+}
+
+var keySet atomic.Pointer[jwtKeySet]
+
+func currentKeySet() *jwtKeySet {
+	return keySet.Load()
+}
+
+func setKeySet(ks *jwtKeySet) {
+	keySet.Store(ks)
+}
+
+func (ks *jwtKeySet) algs() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range ks.keys {
+		if alg := k.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// KeyFilePath 密钥文件路径
+func (c JWTConfig) KeyFilePath(home string) string {
+	if c.KeyFile != "" {
+		return c.KeyFile
+	}
+	return filepath.Join(home, "jwt_keys.json")
+}
+
+// InitJWT 加载 JWT 密钥集
+func InitJWT(conf JWTConfig, home string) error {
+	defs := conf.Keys
+	if len(defs) == 0 {
+		var err error
+		if defs, err = loadOrCreateKeyFile(conf.KeyFilePath(home), conf.Alg); err != nil {
+			return err
+		}
+	}
+	ks, err := newKeySet(defs)
+	if err != nil {
+		return err
+	}
+	setKeySet(ks)
+	return nil
+}
+
+// RotateJWTKeyFile 轮换密钥文件：生成新的 active 密钥，原 active 密钥转为 retiring
+func RotateJWTKeyFile(path, alg string) (string, error) {
+	f, err := readKeyFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	nk, err := GenerateJWTKey(alg)
+	if err != nil {
+		return "", err
+	}
+	// 旧密钥全部转为 retiring，只保留最近的几个
+	old := f.Keys
+	if len(old) > maxRetiringKeys {
+		old = old[len(old)-maxRetiringKeys:]
+	}
+	keys := make([]JWTKeyDef, 0, len(old)+1)
+	for _, k := range old {
+		k.Status = JWTKeyRetiring
+		keys = append(keys, k)
+	}
+	keys = append(keys, nk)
+	if err := writeKeyFile(path, jwtKeyFile{Keys: keys}); err != nil {
+		return "", err
+	}
+	return nk.Kid, nil
+}
+
+// GenerateJWTKey 生成指定算法的密钥
+func GenerateJWTKey(alg string) (JWTKeyDef, error) {
+	if alg == "" {
+		alg = JWTAlgHS256
+	}
+	kid, err := randomHex(8)
+	if err != nil {
+		return JWTKeyDef{}, err
+	}
+	def := JWTKeyDef{Kid: kid, Alg: alg, Status: JWTKeyActive}
+	switch alg {
+	case JWTAlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return def, err
+		}
+		def.Secret = base64.RawStdEncoding.EncodeToString(secret)
+	case JWTAlgEdDSA:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return def, err
+		}
+		if def.PrivateKey, def.PublicKey, err = encodeKeyPair(priv, pub); err != nil {
+			return def, err
+		}
+	case JWTAlgRS256:
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return def, err
+		}
+		if def.PrivateKey, def.PublicKey, err = encodeKeyPair(priv, &priv.PublicKey); err != nil {
+			return def, err
+		}
+	default:
+		return def, fmt.Errorf("unsupported jwt alg %q", alg)
+	}
+	return def, nil
+}
+
+// JWKS 导出非对称密钥的公钥，供其他服务校验 token
+func JWKS() []map[string]string {
+	ks := currentKeySet()
+	if ks == nil {
+		return nil
+	}
+	list := make([]map[string]string, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		switch pub := k.verifyKey.(type) {
+		case ed25519.PublicKey:
+			list = append(list, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"use": "sig",
+				"alg": JWTAlgEdDSA,
+				"kid": k.kid,
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			list = append(list, map[string]string{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": JWTAlgRS256,
+				"kid": k.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	return list
+}
+
+func newKeySet(defs []JWTKeyDef) (*jwtKeySet, error) {
+	ks := &jwtKeySet{keys: make(map[string]*jwtKey)}
+	for _, def := range defs {
+		k, err := parseKeyDef(def)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", def.Kid, err)
+		}
+		if _, ok := ks.keys[k.kid]; ok {
+			return nil, fmt.Errorf("duplicate jwt kid %q", k.kid)
+		}
+		ks.keys[k.kid] = k
+		if def.Status == JWTKeyActive && ks.active == nil {
+			if k.signKey == nil {
+				return nil, fmt.Errorf("jwt key %q: active key has no private key", k.kid)
+			}
+			ks.active = k
+		}
+	}
+	if ks.active == nil {
+		return nil, ErrNoActiveJWTKey
+	}
+	return ks, nil
+}
+
+func parseKeyDef(def JWTKeyDef) (*jwtKey, error) {
+	if def.Kid == "" {
+		return nil, errors.New("missing kid")
+	}
+	k := &jwtKey{kid: def.Kid}
+	var err error
+	switch def.Alg {
+	case JWTAlgHS256, "":
+		if def.Secret == "" {
+			return nil, errors.New("missing secret")
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(def.Secret)
+		k.verifyKey = k.signKey
+	case JWTAlgEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+		if def.PrivateKey != "" {
+			priv, err := jwt.ParseEdPrivateKeyFromPEM([]byte(def.PrivateKey))
+			if err != nil {
+				return nil, err
+			}
+			k.signKey = priv
+			k.verifyKey = priv.(ed25519.PrivateKey).Public()
+		} else if k.verifyKey, err = jwt.ParseEdPublicKeyFromPEM([]byte(def.PublicKey)); err != nil {
+			return nil, err
+		}
+	case JWTAlgRS256:
+		k.method = jwt.SigningMethodRS256
+		if def.PrivateKey != "" {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(def.PrivateKey))
+			if err != nil {
+				return nil, err
+			}
+			k.signKey = priv
+			k.verifyKey = &priv.PublicKey
+		} else if k.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(def.PublicKey)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", def.Alg)
+	}
+	return k, nil
+}
+
+func loadOrCreateKeyFile(path, alg string) ([]JWTKeyDef, error) {
+	f, err := readKeyFile(path)
+	if err == nil {
+		return f.Keys, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	def, err := GenerateJWTKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	f.Keys = []JWTKeyDef{def}
+	if err := writeKeyFile(path, f); err != nil {
+		return nil, err
+	}
+	return f.Keys, nil
+}
+
+func readKeyFile(path string) (jwtKeyFile, error) {
+	var f jwtKeyFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(data, &f)
+	return f, err
+}
+
+func writeKeyFile(path string, f jwtKeyFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func encodeKeyPair(priv, pub interface{}) (string, string, error) {
+	privDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", "", err
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDer})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer})), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,237 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"pionex-administrative-sys/utils"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultUserFilter  = "(&(objectClass=person)(uid=%s))"
+	defaultAccountAttr = "uid"
+	defaultNameAttr    = "cn"
+	defaultGroupAttr   = "memberOf"
+
+	dialTimeout = 10 * time.Second
+	pageSize    = 500
+)
+
+var (
+	ErrNotEnabled         = errors.New("ldap not enabled")
+	ErrInvalidCredentials = errors.New("invalid ldap credentials")
+)
+
+// Config LDAP/AD 认证配置
+type Config struct {
+	Enabled            bool                       `json:"enabled"`
+	URL                string                     `json:"url"`                  // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool                       `json:"start_tls"`            // ldap:// 连接后升级为 TLS
+	InsecureSkipVerify bool                       `json:"insecure_skip_verify"` // 跳过证书校验，仅用于测试
+	BindDN             string                     `json:"bind_dn"`              // 查询用的服务账号
+	BindPassword       string                     `json:"bind_password"`
+	BaseDN             string                     `json:"base_dn"`
+	UserFilter         string                     `json:"user_filter"`   // %s 替换为账号，默认 (&(objectClass=person)(uid=%s))，AD 可用 (&(objectClass=user)(sAMAccountName=%s))
+	AccountAttr        string                     `json:"account_attr"`  // 账号属性，默认 uid
+	NameAttr           string                     `json:"name_attr"`     // 姓名属性，默认 cn
+	GroupAttr          string                     `json:"group_attr"`    // 组属性，默认 memberOf
+	DefaultRole        utils.RoleNames            `json:"default_role"`  // 首次登录自动创建用户的角色名，默认为登录角色
+	GroupRoles         map[string]utils.RoleNames `json:"group_roles"`   // 组 DN 或 CN 到角色名的映射
+	SyncInterval       int                        `json:"sync_interval"` // 同步间隔（分钟），0 表示不同步
+}
+
+// Entry 目录中的用户
+type Entry struct {
+	DN      string
+	Account string
+	Name    string
+	Groups  []string
+}
+
+var current atomic.Pointer[Config]
+
+// Init 加载配置
+func Init(conf Config) error {
+	if !conf.Enabled {
+		current.Store(nil)
+		return nil
+	}
+	if conf.URL == "" || conf.BaseDN == "" {
+		return errors.New("ldap url and base_dn are required")
+	}
+	if conf.UserFilter == "" {
+		conf.UserFilter = defaultUserFilter
+	}
+	if !strings.Contains(conf.UserFilter, "%s") {
+		return errors.New("ldap user_filter must contain %s")
+	}
+	if conf.AccountAttr == "" {
+		conf.AccountAttr = defaultAccountAttr
+	}
+	if conf.NameAttr == "" {
+		conf.NameAttr = defaultNameAttr
+	}
+	if conf.GroupAttr == "" {
+		conf.GroupAttr = defaultGroupAttr
+	}
+	current.Store(&conf)
+	return nil
+}
+
+// Enabled 是否启用 LDAP 认证
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Conf 获取生效的配置
+func Conf() Config {
+	if c := current.Load(); c != nil {
+		return *c
+	}
+	return Config{}
+}
+
+// Authenticate 先用服务账号按账号查找用户 DN，再以该 DN 和密码绑定校验
+func Authenticate(account, password string) (*Entry, error) {
+	conf := current.Load()
+	if conf == nil {
+		return nil, ErrNotEnabled
+	}
+	// 空密码在很多目录中会被当作匿名绑定而成功
+	if account == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := dial(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := searchRequest(conf, fmt.Sprintf(conf.UserFilter, goldap.EscapeFilter(account)), 2)
+	res, err := conn.Search(req)
+	if err != nil {
+		// 账号匹配到多个条目，无法确定身份
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := toEntry(conf, res.Entries[0])
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ListAccounts 分页列出目录中所有用户的账号
+func ListAccounts() (map[string]bool, error) {
+	conf := current.Load()
+	if conf == nil {
+		return nil, ErrNotEnabled
+	}
+	conn, err := dial(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := searchRequest(conf, fmt.Sprintf(conf.UserFilter, "*"), 0)
+	res, err := conn.SearchWithPaging(req, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]bool, len(res.Entries))
+	for _, e := range res.Entries {
+		if a := e.GetAttributeValue(conf.AccountAttr); a != "" {
+			accounts[a] = true
+		}
+	}
+	return accounts, nil
+}
+
+// GroupRole 根据所属组计算映射的角色，组可按完整 DN 或 CN 配置
+func GroupRole(groups []string) utils.RoleNames {
+	conf := Conf()
+	var roles utils.RoleNames
+	for _, g := range groups {
+		roles = roles.Merge(conf.GroupRoles[g])
+		if cn := groupCN(g); cn != "" {
+			roles = roles.Merge(conf.GroupRoles[cn])
+		}
+	}
+	return roles
+}
+
+func groupCN(dn string) string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// dial 建立连接并以服务账号绑定，未配置服务账号时匿名查询
+func dial(conf *Config) (*goldap.Conn, error) {
+	tlsConf := &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	conn, err := goldap.DialURL(conf.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: dialTimeout}),
+		goldap.DialWithTLSConfig(tlsConf),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(dialTimeout)
+	if conf.StartTLS {
+		if err := conn.StartTLS(tlsConf); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if conf.BindDN != "" {
+		if err := conn.Bind(conf.BindDN, conf.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func searchRequest(conf *Config, filter string, sizeLimit int) *goldap.SearchRequest {
+	return goldap.NewSearchRequest(
+		conf.BaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, sizeLimit, 0, false,
+		filter,
+		[]string{conf.AccountAttr, conf.NameAttr, conf.GroupAttr},
+		nil,
+	)
+}
+
+func toEntry(conf *Config, e *goldap.Entry) *Entry {
+	entry := &Entry{
+		DN:      e.DN,
+		Account: e.GetAttributeValue(conf.AccountAttr),
+		Name:    e.GetAttributeValue(conf.NameAttr),
+		Groups:  e.GetAttributeValues(conf.GroupAttr),
+	}
+	if entry.Name == "" {
+		entry.Name = entry.Account
+	}
+	return entry
+}
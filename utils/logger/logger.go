@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"pionex-administrative-sys/utils"
 	"pionex-administrative-sys/utils/app"
 	"time"
 
@@ -177,6 +178,8 @@ func ErrorWriter() *Writer {
 	return &Writer{level: zapcore.ErrorLevel}
 }
 
+const redacted = "[REDACTED]"
+
 // GormLogger GORM 日志适配器
 type GormLogger struct {
 	SlowThreshold time.Duration
@@ -247,6 +250,20 @@ func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql
 	}
 }
 
+// ParamsFilter 日志中的 SQL 不输出加密数据、密码哈希等敏感参数，不影响实际执行的参数
 func (l *GormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
-	return sql, params
+	var filtered []interface{}
+	for i, p := range params {
+		if !utils.LooksSensitive(p) {
+			continue
+		}
+		if filtered == nil {
+			filtered = append([]interface{}(nil), params...)
+		}
+		filtered[i] = redacted
+	}
+	if filtered == nil {
+		return sql, params
+	}
+	return sql, filtered
 }
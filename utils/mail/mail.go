@@ -0,0 +1,192 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SMTP 连接加密方式
+const (
+	TLSModeStartTLS = "starttls" // 通过 STARTTLS 升级为 TLS，服务器不支持时不发送（默认）
+	TLSModeTLS      = "tls"      // 直接使用 TLS 连接，通常为 465 端口
+	TLSModeNone     = "none"     // 不加密，仅用于本地测试
+)
+
+const dialTimeout = 10 * time.Second
+
+var (
+	ErrNotConfigured       = errors.New("mail not configured")
+	ErrStartTLSUnsupported = errors.New("smtp server does not support STARTTLS")
+)
+
+// Config 邮件发送配置，未配置 host 时不发送
+type Config struct {
+	Host               string `json:"host"`
+	Port               int    `json:"port"` // 默认 587，tls 模式默认 465
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	From               string `json:"from"`
+	TLSMode            string `json:"tls_mode"`             // starttls/tls/none
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // 跳过证书校验，仅用于测试
+}
+
+// Message 邮件内容
+type Message struct {
+	To      []string
+	Subject string
+	Body    string // 纯文本
+}
+
+// Sender 邮件发送方式
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// senderBox 固定存入 atomic.Value 的类型，不同实现可以相互替换
+type senderBox struct{ Sender }
+
+var current atomic.Value
+
+func init() {
+	current.Store(senderBox{noopSender{}})
+}
+
+// Init 按配置创建发送器
+func Init(conf Config) error {
+	if conf.Host == "" {
+		current.Store(senderBox{noopSender{}})
+		return nil
+	}
+	if conf.From == "" {
+		return errors.New("mail from is required")
+	}
+	switch conf.TLSMode {
+	case "":
+		conf.TLSMode = TLSModeStartTLS
+	case TLSModeStartTLS, TLSModeTLS, TLSModeNone:
+	default:
+		return fmt.Errorf("unknown mail tls_mode %q", conf.TLSMode)
+	}
+	if conf.Port == 0 {
+		conf.Port = 587
+		if conf.TLSMode == TLSModeTLS {
+			conf.Port = 465
+		}
+	}
+	current.Store(senderBox{NewSMTPSender(conf)})
+	return nil
+}
+
+// SetSender 替换发送器
+func SetSender(s Sender) {
+	current.Store(senderBox{s})
+}
+
+// Send 使用当前发送器发送邮件
+func Send(ctx context.Context, msg *Message) error {
+	return current.Load().(senderBox).Send(ctx, msg)
+}
+
+// noopSender 未配置 SMTP 时不发送，由调用方记录
+type noopSender struct{}
+
+func (noopSender) Send(context.Context, *Message) error {
+	return ErrNotConfigured
+}
+
+// SMTPSender 通过 SMTP 发送邮件
+type SMTPSender struct {
+	conf Config
+}
+
+// NewSMTPSender 创建 SMTP 发送器
+func NewSMTPSender(conf Config) *SMTPSender {
+	return &SMTPSender{conf: conf}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	conf := s.conf
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port))
+	tlsConf := &tls.Config{ServerName: conf.Host, InsecureSkipVerify: conf.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if conf.TLSMode == TLSModeTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConf}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	c, err := smtp.NewClient(conn, conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	// 服务器未提供 STARTTLS 时不降级为明文，避免重置链接等内容被窃听
+	if conf.TLSMode == TLSModeStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return ErrStartTLSUnsupported
+		}
+		if err := c.StartTLS(tlsConf); err != nil {
+			return err
+		}
+	}
+	if conf.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(conf.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMessage(conf.From, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func buildMessage(from string, msg *Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + strings.Join(msg.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,328 @@
+package mail
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// delivery SMTP 接收端收到的一封邮件
+type delivery struct {
+	From string
+	To   []string
+	Data string
+	TLS  bool   // 投递时连接是否已加密
+	Auth string // AUTH PLAIN 解码后的凭证
+}
+
+// smtpSink 本地 SMTP 接收端，只实现发送邮件需要的命令
+type smtpSink struct {
+	t        *testing.T
+	ln       net.Listener
+	tlsConf  *tls.Config
+	startTLS bool // EHLO 是否提供 STARTTLS
+
+	mu         sync.Mutex
+	deliveries []delivery
+	commands   []string
+}
+
+// newSMTPSink 启动接收端，implicitTLS 时直接以 TLS 接受连接
+func newSMTPSink(t *testing.T, startTLS, implicitTLS bool) *smtpSink {
+	t.Helper()
+	s := &smtpSink{t: t, tlsConf: selfSignedTLS(t), startTLS: startTLS}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicitTLS {
+		ln = tls.NewListener(ln, s.tlsConf)
+	}
+	s.ln = ln
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpSink) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	_, secure := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) bool {
+		return tp.PrintfLine(format, args...) == nil
+	}
+	if !reply("220 sink ESMTP") {
+		return
+	}
+	var cur delivery
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		s.mu.Lock()
+		s.commands = append(s.commands, verb)
+		s.mu.Unlock()
+
+		switch verb {
+		case "EHLO", "HELO":
+			lines := []string{"250-sink", "250-AUTH PLAIN"}
+			if s.startTLS && !secure {
+				lines = append(lines, "250-STARTTLS")
+			}
+			lines = append(lines, "250 8BITMIME")
+			for _, l := range lines {
+				reply("%s", l)
+			}
+		case "STARTTLS":
+			if !s.startTLS || secure {
+				reply("502 not supported")
+				continue
+			}
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConf)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			if mech != "PLAIN" {
+				reply("504 unsupported")
+				continue
+			}
+			raw, err := base64.StdEncoding.DecodeString(initial)
+			if err != nil {
+				reply("501 bad encoding")
+				continue
+			}
+			cur.Auth = string(raw)
+			reply("235 ok")
+		case "MAIL":
+			cur.From = envelopeAddr(arg, "FROM:")
+			cur.TLS = secure
+			reply("250 ok")
+		case "RCPT":
+			cur.To = append(cur.To, envelopeAddr(arg, "TO:"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			cur.Data = string(data)
+			s.mu.Lock()
+			s.deliveries = append(s.deliveries, cur)
+			s.mu.Unlock()
+			cur = delivery{Auth: cur.Auth}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown")
+		}
+	}
+}
+
+// envelopeAddr 取出 MAIL FROM / RCPT TO 中的地址，忽略 BODY= 等参数
+func envelopeAddr(arg, prefix string) string {
+	addr, _, _ := strings.Cut(strings.TrimPrefix(arg, prefix), " ")
+	return strings.Trim(addr, "<>")
+}
+
+func (s *smtpSink) result() ([]delivery, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]delivery(nil), s.deliveries...), append([]string(nil), s.commands...)
+}
+
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func testMessage() *Message {
+	return &Message{
+		To:      []string{"alice@example.com", "bob@example.com"},
+		Subject: "重置密码",
+		Body:    "点击链接重置密码：\nhttps://pas.example.com/reset?token=abc",
+	}
+}
+
+func sinkConfig(s *smtpSink, mode string) Config {
+	return Config{
+		Host:               "127.0.0.1",
+		Port:               s.port(),
+		Username:           "pas",
+		Password:           "secret",
+		From:               "noreply@example.com",
+		TLSMode:            mode,
+		InsecureSkipVerify: true,
+	}
+}
+
+func TestSMTPSenderStartTLS(t *testing.T) {
+	sink := newSMTPSink(t, true, false)
+	if err := NewSMTPSender(sinkConfig(sink, TLSModeStartTLS)).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ := sink.result()
+	if len(deliveries) != 1 {
+		t.Fatalf("deliveries = %d, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if !d.TLS {
+		t.Fatal("mail delivered without TLS")
+	}
+	if d.From != "noreply@example.com" || strings.Join(d.To, ",") != "alice@example.com,bob@example.com" {
+		t.Fatalf("envelope = %s -> %v", d.From, d.To)
+	}
+	if d.Auth != "\x00pas\x00secret" {
+		t.Fatalf("auth = %q", d.Auth)
+	}
+	for _, want := range []string{
+		"From: noreply@example.com\n",
+		"To: alice@example.com, bob@example.com\n",
+		"Subject: =?UTF-8?b?6YeN572u5a+G56CB?=\n",
+		"Content-Type: text/plain; charset=UTF-8\n",
+		"\n\n点击链接重置密码：\nhttps://pas.example.com/reset?token=abc",
+	} {
+		if !strings.Contains(d.Data, want) {
+			t.Errorf("message missing %q:\n%s", want, d.Data)
+		}
+	}
+}
+
+func TestSMTPSenderStartTLSUnsupported(t *testing.T) {
+	sink := newSMTPSink(t, false, false)
+	err := NewSMTPSender(sinkConfig(sink, TLSModeStartTLS)).Send(context.Background(), testMessage())
+	if !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Fatalf("err = %v, want ErrStartTLSUnsupported", err)
+	}
+	deliveries, commands := sink.result()
+	if len(deliveries) != 0 {
+		t.Fatal("mail delivered in plaintext")
+	}
+	for _, c := range commands {
+		if c == "AUTH" || c == "MAIL" {
+			t.Fatalf("%s sent in plaintext", c)
+		}
+	}
+}
+
+func TestSMTPSenderImplicitTLS(t *testing.T) {
+	sink := newSMTPSink(t, false, true)
+	if err := NewSMTPSender(sinkConfig(sink, TLSModeTLS)).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ := sink.result()
+	if len(deliveries) != 1 || !deliveries[0].TLS {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestSMTPSenderNoTLS(t *testing.T) {
+	sink := newSMTPSink(t, true, false)
+	conf := sinkConfig(sink, TLSModeNone)
+	conf.Username = ""
+	if err := NewSMTPSender(conf).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, commands := sink.result()
+	if len(deliveries) != 1 || deliveries[0].TLS {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+	for _, c := range commands {
+		if c == "STARTTLS" {
+			t.Fatal("STARTTLS used in none mode")
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	t.Cleanup(func() { _ = Init(Config{}) })
+
+	if err := Init(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Send(context.Background(), testMessage()); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("err = %v, want ErrNotConfigured", err)
+	}
+	if err := Init(Config{Host: "smtp.example.com"}); err == nil {
+		t.Fatal("expected error without from")
+	}
+	if err := Init(Config{Host: "smtp.example.com", From: "a@b.c", TLSMode: "ssl"}); err == nil {
+		t.Fatal("expected error for unknown tls_mode")
+	}
+
+	tests := []struct {
+		mode     string
+		wantMode string
+		wantPort int
+	}{
+		{"", TLSModeStartTLS, 587},
+		{TLSModeTLS, TLSModeTLS, 465},
+		{TLSModeNone, TLSModeNone, 587},
+	}
+	for _, tt := range tests {
+		if err := Init(Config{Host: "smtp.example.com", From: "a@b.c", TLSMode: tt.mode}); err != nil {
+			t.Fatal(err)
+		}
+		s, ok := current.Load().(senderBox).Sender.(*SMTPSender)
+		if !ok {
+			t.Fatal("sender is not SMTP")
+		}
+		if s.conf.TLSMode != tt.wantMode || s.conf.Port != tt.wantPort {
+			t.Errorf("mode %q: got %s:%s, want %s:%s", tt.mode,
+				s.conf.TLSMode, strconv.Itoa(s.conf.Port), tt.wantMode, strconv.Itoa(tt.wantPort))
+		}
+	}
+}
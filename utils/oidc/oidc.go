@@ -0,0 +1,370 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"pionex-administrative-sys/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultAccountClaim = "preferred_username"
+	defaultGroupsClaim  = "groups"
+
+	httpTimeout = 10 * time.Second
+	// JWKS 中找不到 kid 时重新拉取的最小间隔
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	ErrNotEnabled   = errors.New("oidc not enabled")
+	ErrInvalidToken = errors.New("invalid id token")
+	ErrNoAccount    = errors.New("id token has no account claim")
+)
+
+// Config OIDC 单点登录配置
+type Config struct {
+	Enabled           bool                       `json:"enabled"`
+	Issuer            string                     `json:"issuer"`              // IdP 地址，通过 /.well-known/openid-configuration 发现端点
+	ClientID          string                     `json:"client_id"`           // 客户端 ID
+	ClientSecret      string                     `json:"client_secret"`       // 客户端密钥，公共客户端可不填
+	RedirectURL       string                     `json:"redirect_url"`        // 回调地址，指向登录页
+	Scopes            []string                   `json:"scopes"`              // 默认 openid profile email
+	AccountClaim      string                     `json:"account_claim"`       // 对应本地账号的 claim，默认 preferred_username，为空时使用已验证的 email
+	GroupsClaim       string                     `json:"groups_claim"`        // 组 claim，默认 groups
+	DefaultRole       utils.RoleNames            `json:"default_role"`        // 首次登录自动创建用户的角色名，默认为登录角色
+	GroupRoles        map[string]utils.RoleNames `json:"group_roles"`         // IdP 组到角色名的映射
+	LinkLocalAccounts bool                       `json:"link_local_accounts"` // 允许关联同名的本地账号，要求 email 已验证且与本地账号的邮箱一致
+}
+
+// Identity ID token 中的用户信息
+type Identity struct {
+	Subject       string
+	Account       string
+	Name          string
+	Email         string
+	EmailVerified bool // IdP 是否已验证 Email
+	Groups        []string
+}
+
+// metadata OIDC 发现文档
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type provider struct {
+	conf   Config
+	client *http.Client
+
+	mu     sync.Mutex
+	meta   *metadata
+	keys   map[string]interface{}
+	keysAt time.Time
+}
+
+var current atomic.Pointer[provider]
+
+// Init 加载配置，端点在首次使用时发现，IdP 暂时不可用不影响服务启动
+func Init(conf Config) error {
+	if !conf.Enabled {
+		current.Store(nil)
+		return nil
+	}
+	if conf.Issuer == "" || conf.ClientID == "" || conf.RedirectURL == "" {
+		return errors.New("oidc issuer, client_id and redirect_url are required")
+	}
+	conf.Issuer = strings.TrimRight(conf.Issuer, "/")
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "profile", "email"}
+	}
+	if conf.AccountClaim == "" {
+		conf.AccountClaim = defaultAccountClaim
+	}
+	if conf.GroupsClaim == "" {
+		conf.GroupsClaim = defaultGroupsClaim
+	}
+	current.Store(&provider{
+		conf:   conf,
+		client: &http.Client{Timeout: httpTimeout},
+	})
+	return nil
+}
+
+// Enabled 是否启用单点登录
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Conf 获取生效的配置
+func Conf() Config {
+	if p := current.Load(); p != nil {
+		return p.conf
+	}
+	return Config{}
+}
+
+// PKCEChallenge 计算 S256 code_challenge
+func PKCEChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// AuthCodeURL 生成跳转到 IdP 的授权地址
+func AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	p := current.Load()
+	if p == nil {
+		return "", ErrNotEnabled
+	}
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.conf.ClientID)
+	v.Set("redirect_uri", p.conf.RedirectURL)
+	v.Set("scope", strings.Join(p.conf.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", PKCEChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange 用授权码换取 ID token，校验签名、issuer、audience、有效期和 nonce
+func Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	p := current.Load()
+	if p == nil {
+		return nil, ErrNotEnabled
+	}
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.conf.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+	}
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &tokenResp); err != nil && tokenResp.Error == "" {
+		return nil, err
+	}
+	if tokenResp.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("token endpoint returned no id_token")
+	}
+	return p.verify(ctx, tokenResp.IDToken, meta.Issuer, nonce)
+}
+
+// verify 校验 ID token 并提取用户信息
+func (p *provider) verify(ctx context.Context, raw, issuer, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(p.conf.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	id := &Identity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Name, _ = claims["name"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified = emailVerified(claims)
+	id.Account, _ = claims[p.conf.AccountClaim].(string)
+	// 邮箱可能由用户在 IdP 自行填写，只有经过验证的邮箱才能作为账号
+	if id.Account == "" && id.EmailVerified {
+		id.Account = id.Email
+	}
+	if id.Subject == "" || id.Account == "" {
+		return nil, ErrNoAccount
+	}
+	if id.Name == "" {
+		id.Name = id.Account
+	}
+	switch g := claims[p.conf.GroupsClaim].(type) {
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = []string{g}
+	}
+	return id, nil
+}
+
+// emailVerified email_verified 是否为 true，部分 IdP 以字符串返回
+func emailVerified(claims jwt.MapClaims) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// GroupRole 根据 IdP 组计算映射的角色
+func GroupRole(groups []string) utils.RoleNames {
+	conf := Conf()
+	var roles utils.RoleNames
+	for _, g := range groups {
+		roles = roles.Merge(conf.GroupRoles[g])
+	}
+	return roles
+}
+
+// discover 获取并缓存发现文档
+func (p *provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.conf.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta metadata
+	if err := p.doJSON(req, &meta); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.conf.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JwksURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// key 按 kid 查找签名公钥，找不到时重新拉取 JWKS 以支持 IdP 轮换密钥
+func (p *provider) key(ctx context.Context, kid string) (interface{}, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	if !p.keysAt.IsZero() && time.Since(p.keysAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	keys, err := p.fetchKeys(ctx, meta.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysAt = keys, time.Now()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+// lookupKey 没有 kid 时只允许 JWKS 中仅有一个密钥
+func (p *provider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func (p *provider) fetchKeys(ctx context.Context, uri string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+// doJSON 发送请求并解析 JSON 响应，非 2xx 时仍尝试解析以便读取错误信息
+func (p *provider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	jsonErr := json.Unmarshal(body, v)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: status %d", req.Method, req.URL.Redacted(), resp.StatusCode)
+	}
+	return jsonErr
+}
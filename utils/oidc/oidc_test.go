@@ -0,0 +1,307 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "pas"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost/static/html/login.html"
+	testKid          = "k1"
+)
+
+// mockIdP 模拟 IdP：发现文档、令牌端点和 JWKS，令牌端点返回 claims 签发的 ID token
+type mockIdP struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	mu       sync.Mutex
+	lastForm url.Values
+	jwksHits int
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIdP{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.jwksHits++
+		m.mu.Unlock()
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		m.lastForm = r.PostForm
+		m.mu.Unlock()
+		if user, pass, ok := r.BasicAuth(); !ok || user != testClientID || pass != testClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]string{"error": "invalid_client"})
+			return
+		}
+		if r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]string{"id_token": m.sign(m.claims)})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+
+	if err := Init(Config{
+		Enabled:      true,
+		Issuer:       m.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Init(Config{}) })
+	return m
+}
+
+func (m *mockIdP) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKid
+	s, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return s
+}
+
+// validClaims 合法 ID token 的 claims
+func (m *mockIdP) validClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                m.srv.URL,
+		"aud":                testClientID,
+		"sub":                "u-1",
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"name":               "Alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"pas-admin", "pas-stock"},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockIdP(t)
+	raw, err := AuthCodeURL(context.Background(), "st", "no", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != m.srv.URL+"/authorize" {
+		t.Fatalf("authorization endpoint = %s", got)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"state":                 "st",
+		"nonce":                 "no",
+		"code_challenge":        PKCEChallenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockIdP(t)
+	m.claims = m.validClaims("n-1")
+
+	id, err := Exchange(context.Background(), "good-code", "verifier", "n-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "u-1" || id.Account != "alice" || id.Name != "Alice" || id.Email != "alice@example.com" || id.EmailVerified {
+		t.Fatalf("identity = %+v", id)
+	}
+	if len(id.Groups) != 2 || id.Groups[0] != "pas-admin" || id.Groups[1] != "pas-stock" {
+		t.Fatalf("groups = %v", id.Groups)
+	}
+
+	m.mu.Lock()
+	form := m.lastForm
+	m.mu.Unlock()
+	want := map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          "good-code",
+		"code_verifier": "verifier",
+		"redirect_uri":  testRedirectURL,
+		"client_id":     testClientID,
+	}
+	for k, v := range want {
+		if form.Get(k) != v {
+			t.Errorf("token request %s = %q, want %q", k, form.Get(k), v)
+		}
+	}
+
+	// JWKS 已缓存，再次登录不重新拉取
+	if _, err := Exchange(context.Background(), "good-code", "verifier", "n-1"); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	hits := m.jwksHits
+	m.mu.Unlock()
+	if hits != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", hits)
+	}
+}
+
+func TestExchangeTokenError(t *testing.T) {
+	newMockIdP(t)
+	if _, err := Exchange(context.Background(), "bad-code", "verifier", "n-1"); err == nil {
+		t.Fatal("expected token endpoint error")
+	}
+}
+
+func TestExchangeRejectsInvalidToken(t *testing.T) {
+	m := newMockIdP(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(c jwt.MapClaims)
+		nonce  string
+		sign   func(c jwt.MapClaims) string
+	}{
+		{name: "nonce mismatch", nonce: "other"},
+		{name: "missing nonce", nonce: "n-1", mutate: func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{name: "wrong audience", nonce: "n-1", mutate: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "wrong issuer", nonce: "n-1", mutate: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", nonce: "n-1", mutate: func(c jwt.MapClaims) {
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+		}},
+		{name: "missing exp", nonce: "n-1", mutate: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "unknown key", nonce: "n-1", sign: func(c jwt.MapClaims) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+			token.Header["kid"] = testKid
+			s, _ := token.SignedString(other)
+			return s
+		}},
+		{name: "hmac", nonce: "n-1", sign: func(c jwt.MapClaims) string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(testClientSecret))
+			return s
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.validClaims("n-1")
+			if tt.mutate != nil {
+				tt.mutate(claims)
+			}
+			raw := ""
+			if tt.sign != nil {
+				raw = tt.sign(claims)
+			} else {
+				raw = m.sign(claims)
+			}
+			p := current.Load()
+			_, err := p.verify(context.Background(), raw, m.srv.URL, tt.nonce)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestEmailFallbackRequiresVerified(t *testing.T) {
+	m := newMockIdP(t)
+	tests := []struct {
+		name     string
+		verified interface{}
+		want     string
+		wantErr  error
+	}{
+		{name: "missing", verified: nil, wantErr: ErrNoAccount},
+		{name: "false", verified: false, wantErr: ErrNoAccount},
+		{name: "string false", verified: "false", wantErr: ErrNoAccount},
+		{name: "true", verified: true, want: "alice@example.com"},
+		{name: "string true", verified: "true", want: "alice@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.validClaims("n-1")
+			delete(claims, "preferred_username")
+			if tt.verified != nil {
+				claims["email_verified"] = tt.verified
+			}
+			m.claims = claims
+			id, err := Exchange(context.Background(), "good-code", "verifier", "n-1")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Account != tt.want || !id.EmailVerified {
+				t.Fatalf("account = %q, email verified = %v, want %q", id.Account, id.EmailVerified, tt.want)
+			}
+		})
+	}
+}
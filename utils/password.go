@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPwdMismatch      = errors.New("wrong password")
+	ErrUnknownPwdFormat = errors.New("unknown password hash format")
+)
+
+// PasswordHasher 密码哈希算法，算法标识和参数编码在哈希串中
+type PasswordHasher interface {
+	// Match 判断哈希串是否由该算法生成
+	Match(encoded string) bool
+	// Hash 计算密码哈希
+	Hash(pwd string) (string, error)
+	// Verify 校验密码
+	Verify(encoded, pwd string) error
+	// NeedRehash 哈希参数是否已过时
+	NeedRehash(encoded string) bool
+}
+
+var (
+	defaultHasher PasswordHasher = NewArgon2idHasher()
+	// 只用于校验的算法，按顺序匹配
+	hashers = []PasswordHasher{
+		NewArgon2idHasher(),
+		NewBcryptHasher(bcrypt.DefaultCost),
+		md5Hasher{},
+	}
+)
+
+// SetPasswordHasher 设置默认的密码哈希算法
+func SetPasswordHasher(h PasswordHasher) {
+	defaultHasher = h
+}
+
+// HashPassword 使用默认算法计算密码哈希
+func HashPassword(pwd string) (string, error) {
+	return defaultHasher.Hash(pwd)
+}
+
+// VerifyPassword 校验密码，返回是否需要使用默认算法重新哈希
+func VerifyPassword(encoded, pwd string) (needRehash bool, err error) {
+	h := findHasher(encoded)
+	if h == nil {
+		return false, ErrUnknownPwdFormat
+	}
+	if err = h.Verify(encoded, pwd); err != nil {
+		return false, err
+	}
+	if h != defaultHasher {
+		return true, nil
+	}
+	return h.NeedRehash(encoded), nil
+}
+
+func findHasher(encoded string) PasswordHasher {
+	if defaultHasher.Match(encoded) {
+		return defaultHasher
+	}
+	for _, h := range hashers {
+		if h.Match(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+// Argon2idHasher argon2id 哈希，格式: $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+type Argon2idHasher struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:  64 * 1024,
+		Time:    3,
+		Threads: 2,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func (a *Argon2idHasher) Match(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a *Argon2idHasher) Hash(pwd string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(pwd), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+type argon2idParams struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, ErrUnknownPwdFormat
+	}
+	var p argon2idParams
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return nil, ErrUnknownPwdFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, ErrUnknownPwdFormat
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownPwdFormat
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, ErrUnknownPwdFormat
+	}
+	return &p, nil
+}
+
+func (a *Argon2idHasher) Verify(encoded, pwd string) error {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(pwd), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return ErrPwdMismatch
+	}
+	return nil
+}
+
+func (a *Argon2idHasher) NeedRehash(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.version != argon2.Version || p.memory != a.Memory || p.time != a.Time ||
+		p.threads != a.Threads || uint32(len(p.key)) != a.KeyLen
+}
+
+// BcryptHasher bcrypt 哈希，格式: $2a$10$...
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (b *BcryptHasher) Match(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *BcryptHasher) Hash(pwd string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(pwd), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+func (b *BcryptHasher) Verify(encoded, pwd string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(pwd)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPwdMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *BcryptHasher) NeedRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// md5Hasher 历史遗留的无盐 MD5，只用于校验旧数据
+type md5Hasher struct{}
+
+func (md5Hasher) Match(encoded string) bool {
+	if len(encoded) != 32 {
+		return false
+	}
+	for _, c := range encoded {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (md5Hasher) Hash(pwd string) (string, error) {
+	return MD5(pwd), nil
+}
+
+func (md5Hasher) Verify(encoded, pwd string) error {
+	if subtle.ConstantTimeCompare([]byte(encoded), []byte(MD5(pwd))) != 1 {
+		return ErrPwdMismatch
+	}
+	return nil
+}
+
+func (md5Hasher) NeedRehash(string) bool {
+	return true
+}
@@ -0,0 +1,187 @@
+package pwdpolicy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultMinLength = 8
+	// 超长密码会拖慢哈希计算，bcrypt 也只使用前 72 字节
+	maxLength = 72
+	// 最多检查的历史密码数量，每个历史哈希都需要计算一次
+	MaxHistory = 24
+)
+
+// 默认管理员密码未修改时的处理方式
+const (
+	DefaultAdminForceChange = "force_change" // 要求登录后修改密码（默认）
+	DefaultAdminRefuse      = "refuse"       // 拒绝启动
+)
+
+// 违规类型
+const (
+	CodeTooShort        = "too_short"
+	CodeTooLong         = "too_long"
+	CodeMissingUpper    = "missing_upper"
+	CodeMissingLower    = "missing_lower"
+	CodeMissingDigit    = "missing_digit"
+	CodeMissingSymbol   = "missing_symbol"
+	CodeBanned          = "banned"
+	CodeContainsAccount = "contains_account"
+	CodeReused          = "reused"
+)
+
+// 内置的常见弱密码，比较时忽略大小写
+var builtinBanned = []string{
+	"123456", "1234567", "12345678", "123456789", "1234567890", "111111", "000000", "888888",
+	"654321", "123123", "abc123", "abc12345", "a123456", "a1234567", "qwerty", "qwerty123",
+	"qwertyuiop", "asdfgh", "asdfghjkl", "zxcvbnm", "1q2w3e4r", "1qaz2wsx", "password",
+	"password1", "password123", "passw0rd", "p@ssw0rd", "p@ssword", "admin", "admin123",
+	"admin@123", "administrator", "root", "root123", "letmein", "welcome", "welcome1",
+	"iloveyou", "monkey", "dragon", "football", "baseball", "sunshine", "princess",
+	"changeme", "default", "test", "test123", "guest", "pionex", "pionex123",
+}
+
+// Config 密码策略配置
+type Config struct {
+	MinLength     int      `json:"min_length"`     // 最小长度，默认 8
+	RequireUpper  bool     `json:"require_upper"`  // 必须包含大写字母
+	RequireLower  bool     `json:"require_lower"`  // 必须包含小写字母
+	RequireDigit  bool     `json:"require_digit"`  // 必须包含数字
+	RequireSymbol bool     `json:"require_symbol"` // 必须包含特殊字符
+	Banned        []string `json:"banned"`         // 额外禁用的密码，内置常见弱密码始终禁用
+	History       int      `json:"history"`        // 不能与最近 N 次使用过的密码相同，0 表示不检查，最多 24
+	MaxAgeDays    int      `json:"max_age_days"`   // 密码有效期（天），过期后必须修改，0 表示不过期
+	DefaultAdmin  string   `json:"default_admin"`  // 默认管理员密码未修改时：force_change 要求修改，refuse 拒绝启动
+}
+
+// Violation 不满足策略的原因
+type Violation struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// Violations 多条违规原因，可直接作为 error 返回
+type Violations []Violation
+
+func (v Violations) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, item := range v {
+		msgs = append(msgs, item.Msg)
+	}
+	return strings.Join(msgs, "；")
+}
+
+type policy struct {
+	conf   Config
+	banned map[string]bool
+}
+
+var current atomic.Pointer[policy]
+
+func init() {
+	_ = Init(Config{})
+}
+
+// Init 加载配置
+func Init(conf Config) error {
+	if conf.MinLength == 0 {
+		conf.MinLength = defaultMinLength
+	}
+	if conf.MinLength < 0 || conf.MinLength > maxLength {
+		return fmt.Errorf("password min_length must be between 1 and %d", maxLength)
+	}
+	if conf.History < 0 || conf.History > MaxHistory {
+		return fmt.Errorf("password history must be between 0 and %d", MaxHistory)
+	}
+	if conf.MaxAgeDays < 0 {
+		return errors.New("password max_age_days must not be negative")
+	}
+	switch conf.DefaultAdmin {
+	case "":
+		conf.DefaultAdmin = DefaultAdminForceChange
+	case DefaultAdminForceChange, DefaultAdminRefuse:
+	default:
+		return fmt.Errorf("unknown password default_admin %q", conf.DefaultAdmin)
+	}
+
+	p := &policy{conf: conf, banned: make(map[string]bool, len(builtinBanned)+len(conf.Banned))}
+	for _, list := range [][]string{builtinBanned, conf.Banned} {
+		for _, s := range list {
+			p.banned[strings.ToLower(s)] = true
+		}
+	}
+	current.Store(p)
+	return nil
+}
+
+// Conf 获取生效的配置
+func Conf() Config {
+	return current.Load().conf
+}
+
+// MaxAge 密码有效期，0 表示不过期
+func MaxAge() time.Duration {
+	return time.Duration(Conf().MaxAgeDays) * 24 * time.Hour
+}
+
+// Check 按策略检查密码，account 为账号，密码中不能包含账号；历史密码由调用方检查
+func Check(pwd, account string) Violations {
+	p := current.Load()
+	conf := p.conf
+	var v Violations
+
+	n := utf8.RuneCountInString(pwd)
+	if n < conf.MinLength {
+		v = append(v, Violation{CodeTooShort, fmt.Sprintf("密码长度至少 %d 位", conf.MinLength)})
+	}
+	if len(pwd) > maxLength {
+		v = append(v, Violation{CodeTooLong, fmt.Sprintf("密码长度不能超过 %d 字节", maxLength)})
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range pwd {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if conf.RequireUpper && !upper {
+		v = append(v, Violation{CodeMissingUpper, "密码必须包含大写字母"})
+	}
+	if conf.RequireLower && !lower {
+		v = append(v, Violation{CodeMissingLower, "密码必须包含小写字母"})
+	}
+	if conf.RequireDigit && !digit {
+		v = append(v, Violation{CodeMissingDigit, "密码必须包含数字"})
+	}
+	if conf.RequireSymbol && !symbol {
+		v = append(v, Violation{CodeMissingSymbol, "密码必须包含特殊字符"})
+	}
+
+	lowerPwd := strings.ToLower(pwd)
+	if p.banned[lowerPwd] {
+		v = append(v, Violation{CodeBanned, "密码过于常见，请更换"})
+	}
+	if account != "" && strings.Contains(lowerPwd, strings.ToLower(account)) {
+		v = append(v, Violation{CodeContainsAccount, "密码不能包含账号"})
+	}
+	return v
+}
+
+// ReusedViolation 与历史密码重复
+func ReusedViolation() Violation {
+	return Violation{CodeReused, fmt.Sprintf("不能使用最近 %d 次用过的密码", Conf().History)}
+}
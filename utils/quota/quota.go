@@ -0,0 +1,167 @@
+// Package quota 领取配额计算，按规则统计时间窗口内的领取次数，与存储无关
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	// 内置时区数据，容器中没有 zoneinfo 时也能加载配置的时区
+	_ "time/tzdata"
+)
+
+// 统计周期
+const (
+	PeriodRolling  = "rolling"  // 滚动窗口，最近 Window 时间内
+	PeriodDay      = "day"      // 自然日
+	PeriodWeek     = "week"     // 自然周，从周一开始
+	PeriodMonth    = "month"    // 自然月
+	PeriodLifetime = "lifetime" // 累计
+)
+
+// Rule 配额规则，Period 内最多领取 Limit 次
+type Rule struct {
+	Period   string
+	Window   time.Duration  // 滚动窗口长度，只用于 rolling
+	Limit    int            // 最多领取次数
+	Location *time.Location // 自然日、周、月的时区，为 nil 时使用服务器时区
+}
+
+// Validate 校验规则
+func (r Rule) Validate() error {
+	if r.Limit < 0 {
+		return errors.New("limit 不能小于 0")
+	}
+	switch r.Period {
+	case PeriodRolling:
+		if r.Window <= 0 {
+			return errors.New("滚动窗口长度必须大于 0")
+		}
+	case PeriodDay, PeriodWeek, PeriodMonth, PeriodLifetime:
+	default:
+		return fmt.Errorf("不支持的周期: %s", r.Period)
+	}
+	return nil
+}
+
+// Describe 规则的可读描述，用于提示
+func (r Rule) Describe() string {
+	switch r.Period {
+	case PeriodRolling:
+		return fmt.Sprintf("%s内最多领%d张", formatDuration(r.Window), r.Limit)
+	case PeriodDay:
+		return fmt.Sprintf("每天只能领%d张", r.Limit)
+	case PeriodWeek:
+		return fmt.Sprintf("每周只能领%d张", r.Limit)
+	case PeriodMonth:
+		return fmt.Sprintf("每月只能领%d张", r.Limit)
+	default:
+		return fmt.Sprintf("最多只能领%d张", r.Limit)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d天", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d小时", d/time.Hour)
+	default:
+		return fmt.Sprintf("%d分钟", d/time.Minute)
+	}
+}
+
+func (r Rule) location() *time.Location {
+	if r.Location != nil {
+		return r.Location
+	}
+	return time.Local
+}
+
+// windowStart 当前统计窗口的开始时间，累计时返回零值
+func (r Rule) windowStart(now time.Time) time.Time {
+	t := now.In(r.location())
+	y, m, d := t.Date()
+	switch r.Period {
+	case PeriodRolling:
+		return now.Add(-r.Window)
+	case PeriodDay:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	case PeriodWeek:
+		offset := (int(t.Weekday()) + 6) % 7 // 周一为 0
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	case PeriodMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// nextWindow 下一个自然周期的开始时间
+func (r Rule) nextWindow(start time.Time) time.Time {
+	switch r.Period {
+	case PeriodDay:
+		return start.AddDate(0, 0, 1)
+	case PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return time.Time{}
+}
+
+// History 查询 since 之后的领取时间，按时间升序；since 为零值时查询全部
+type History func(since time.Time) ([]time.Time, error)
+
+// Result 配额计算结果
+type Result struct {
+	Unlimited bool      // 没有任何规则
+	Limit     int       // 最严格规则的次数上限
+	Used      int       // 最严格规则窗口内已领取次数
+	Remaining int       // 剩余可领取次数
+	ResetAt   time.Time // 剩余次数为 0 时恢复领取的时间，累计上限时为零值
+	Rule      Rule      // 剩余次数最少的规则
+}
+
+// Evaluate 计算所有规则下的剩余次数，取最小值
+func Evaluate(rules []Rule, now time.Time, history History) (Result, error) {
+	if len(rules) == 0 {
+		return Result{Unlimited: true}, nil
+	}
+	var (
+		best  Result
+		found bool
+	)
+	for _, r := range rules {
+		start := r.windowStart(now)
+		times, err := history(start)
+		if err != nil {
+			return Result{}, err
+		}
+		res := Result{Limit: r.Limit, Used: len(times), Rule: r}
+		res.Remaining = max(r.Limit-len(times), 0)
+		if res.Remaining == 0 {
+			switch r.Period {
+			case PeriodRolling:
+				// 窗口内第 len-limit+1 早的记录过期后恢复一次
+				if r.Limit > 0 && len(times) >= r.Limit {
+					res.ResetAt = times[len(times)-r.Limit].Add(r.Window)
+				}
+			case PeriodLifetime:
+			default:
+				res.ResetAt = r.nextWindow(start)
+			}
+		}
+		if !found || res.Remaining < best.Remaining ||
+			(res.Remaining == 0 && best.Remaining == 0 && laterReset(res.ResetAt, best.ResetAt)) {
+			best, found = res, true
+		}
+	}
+	return best, nil
+}
+
+// laterReset 同为 0 次时提示恢复最晚的规则，零值表示不会恢复
+func laterReset(a, b time.Time) bool {
+	if b.IsZero() {
+		return false
+	}
+	return a.IsZero() || a.After(b)
+}
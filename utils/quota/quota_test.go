@@ -0,0 +1,306 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// claims 按领取时间升序保存的历史，模拟 claimed_at >= since 的查询
+func claims(times ...time.Time) History {
+	return func(since time.Time) ([]time.Time, error) {
+		var list []time.Time
+		for _, t := range times {
+			if since.IsZero() || !t.Before(since) {
+				list = append(list, t)
+			}
+		}
+		return list, nil
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+	sh := mustLoad(t, "Asia/Shanghai")
+	ny := mustLoad(t, "America/New_York")
+	at := func(loc *time.Location, y int, m time.Month, d, h, min, sec int) time.Time {
+		return time.Date(y, m, d, h, min, sec, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		rule Rule
+		now  time.Time
+		want time.Time
+	}{
+		{"day start", Rule{Period: PeriodDay, Location: sh}, at(sh, 2026, 10, 17, 0, 0, 0), at(sh, 2026, 10, 17, 0, 0, 0)},
+		{"day end", Rule{Period: PeriodDay, Location: sh}, at(sh, 2026, 10, 17, 23, 59, 59), at(sh, 2026, 10, 17, 0, 0, 0)},
+		{"day in rule location", Rule{Period: PeriodDay, Location: sh}, at(time.UTC, 2026, 10, 16, 16, 0, 0), at(sh, 2026, 10, 17, 0, 0, 0)},
+		{"day before midnight in rule location", Rule{Period: PeriodDay, Location: sh}, at(time.UTC, 2026, 10, 16, 15, 59, 59), at(sh, 2026, 10, 16, 0, 0, 0)},
+		{"week saturday", Rule{Period: PeriodWeek, Location: sh}, at(sh, 2026, 10, 17, 12, 0, 0), at(sh, 2026, 10, 12, 0, 0, 0)},
+		{"week sunday is last day", Rule{Period: PeriodWeek, Location: sh}, at(sh, 2026, 10, 18, 23, 59, 59), at(sh, 2026, 10, 12, 0, 0, 0)},
+		{"week monday starts new week", Rule{Period: PeriodWeek, Location: sh}, at(sh, 2026, 10, 19, 0, 0, 0), at(sh, 2026, 10, 19, 0, 0, 0)},
+		{"week across month", Rule{Period: PeriodWeek, Location: sh}, at(sh, 2026, 11, 1, 8, 0, 0), at(sh, 2026, 10, 26, 0, 0, 0)},
+		{"week across year", Rule{Period: PeriodWeek, Location: sh}, at(sh, 2027, 1, 1, 8, 0, 0), at(sh, 2026, 12, 28, 0, 0, 0)},
+		{"month last instant", Rule{Period: PeriodMonth, Location: sh}, at(sh, 2026, 10, 31, 23, 59, 59), at(sh, 2026, 10, 1, 0, 0, 0)},
+		{"month first instant", Rule{Period: PeriodMonth, Location: sh}, at(sh, 2026, 11, 1, 0, 0, 0), at(sh, 2026, 11, 1, 0, 0, 0)},
+		{"month leap february", Rule{Period: PeriodMonth, Location: sh}, at(sh, 2028, 2, 29, 12, 0, 0), at(sh, 2028, 2, 1, 0, 0, 0)},
+		{"day on dst start", Rule{Period: PeriodDay, Location: ny}, at(ny, 2026, 3, 8, 12, 0, 0), at(ny, 2026, 3, 8, 0, 0, 0)},
+		{"day on dst end", Rule{Period: PeriodDay, Location: ny}, at(ny, 2026, 11, 1, 23, 0, 0), at(ny, 2026, 11, 1, 0, 0, 0)},
+		{"week across dst start", Rule{Period: PeriodWeek, Location: ny}, at(ny, 2026, 3, 10, 9, 0, 0), at(ny, 2026, 3, 9, 0, 0, 0)},
+		{"week containing dst start", Rule{Period: PeriodWeek, Location: ny}, at(ny, 2026, 3, 8, 9, 0, 0), at(ny, 2026, 3, 2, 0, 0, 0)},
+		{"rolling", Rule{Period: PeriodRolling, Window: 36 * time.Hour}, at(sh, 2026, 10, 17, 12, 0, 0), at(sh, 2026, 10, 16, 0, 0, 0)},
+		{"rolling across dst uses elapsed time", Rule{Period: PeriodRolling, Window: 24 * time.Hour}, at(ny, 2026, 3, 8, 12, 0, 0), at(ny, 2026, 3, 7, 11, 0, 0)},
+		{"lifetime", Rule{Period: PeriodLifetime}, at(sh, 2026, 10, 17, 12, 0, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.windowStart(tt.now); !got.Equal(tt.want) {
+				t.Fatalf("windowStart = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	tests := []struct {
+		name  string
+		rule  Rule
+		start time.Time
+		want  time.Time
+		hours float64 // 窗口的实际长度
+	}{
+		{"dst start day is 23 hours", Rule{Period: PeriodDay}, time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 3, 9, 0, 0, 0, 0, ny), 23},
+		{"dst end day is 25 hours", Rule{Period: PeriodDay}, time.Date(2026, 11, 1, 0, 0, 0, 0, ny), time.Date(2026, 11, 2, 0, 0, 0, 0, ny), 25},
+		{"week with dst start", Rule{Period: PeriodWeek}, time.Date(2026, 3, 2, 0, 0, 0, 0, ny), time.Date(2026, 3, 9, 0, 0, 0, 0, ny), 7*24 - 1},
+		{"month", Rule{Period: PeriodMonth}, time.Date(2026, 1, 1, 0, 0, 0, 0, ny), time.Date(2026, 2, 1, 0, 0, 0, 0, ny), 31 * 24},
+		{"month across year", Rule{Period: PeriodMonth}, time.Date(2026, 12, 1, 0, 0, 0, 0, ny), time.Date(2027, 1, 1, 0, 0, 0, 0, ny), 31 * 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rule.nextWindow(tt.start)
+			if !got.Equal(tt.want) {
+				t.Fatalf("nextWindow = %v, want %v", got, tt.want)
+			}
+			if h := got.Sub(tt.start).Hours(); h != tt.hours {
+				t.Fatalf("window length = %vh, want %vh", h, tt.hours)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	sh := mustLoad(t, "Asia/Shanghai")
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, sh) // 周六
+	day := Rule{Period: PeriodDay, Limit: 1, Location: sh}
+	week := Rule{Period: PeriodWeek, Limit: 2, Location: sh}
+	month := Rule{Period: PeriodMonth, Limit: 3, Location: sh}
+	rolling := Rule{Period: PeriodRolling, Window: 24 * time.Hour, Limit: 2}
+	lifetime := Rule{Period: PeriodLifetime, Limit: 3}
+
+	tests := []struct {
+		name          string
+		rules         []Rule
+		history       History
+		wantRemaining int
+		wantUsed      int
+		wantPeriod    string
+		wantReset     time.Time
+	}{
+		{
+			name:          "day claimed before midnight not counted",
+			rules:         []Rule{day},
+			history:       claims(time.Date(2026, 10, 16, 23, 59, 59, 0, sh)),
+			wantRemaining: 1,
+			wantPeriod:    PeriodDay,
+		},
+		{
+			name:          "day exhausted resets at next midnight",
+			rules:         []Rule{day},
+			history:       claims(time.Date(2026, 10, 17, 0, 0, 0, 0, sh)),
+			wantRemaining: 0,
+			wantUsed:      1,
+			wantPeriod:    PeriodDay,
+			wantReset:     time.Date(2026, 10, 18, 0, 0, 0, 0, sh),
+		},
+		{
+			name:          "week exhausted resets next monday",
+			rules:         []Rule{week},
+			history:       claims(time.Date(2026, 10, 12, 0, 0, 0, 0, sh), time.Date(2026, 10, 15, 9, 0, 0, 0, sh)),
+			wantRemaining: 0,
+			wantUsed:      2,
+			wantPeriod:    PeriodWeek,
+			wantReset:     time.Date(2026, 10, 19, 0, 0, 0, 0, sh),
+		},
+		{
+			name:          "week ignores previous sunday",
+			rules:         []Rule{week},
+			history:       claims(time.Date(2026, 10, 11, 23, 0, 0, 0, sh), time.Date(2026, 10, 15, 9, 0, 0, 0, sh)),
+			wantRemaining: 1,
+			wantUsed:      1,
+			wantPeriod:    PeriodWeek,
+		},
+		{
+			name:          "month exhausted resets on the first",
+			rules:         []Rule{month},
+			history:       claims(time.Date(2026, 10, 1, 0, 0, 0, 0, sh), time.Date(2026, 10, 2, 0, 0, 0, 0, sh), time.Date(2026, 10, 3, 0, 0, 0, 0, sh)),
+			wantRemaining: 0,
+			wantUsed:      3,
+			wantPeriod:    PeriodMonth,
+			wantReset:     time.Date(2026, 11, 1, 0, 0, 0, 0, sh),
+		},
+		{
+			name:          "rolling excludes expired claims",
+			rules:         []Rule{rolling},
+			history:       claims(now.Add(-25*time.Hour), now.Add(-time.Hour)),
+			wantRemaining: 1,
+			wantUsed:      1,
+			wantPeriod:    PeriodRolling,
+		},
+		{
+			name:          "rolling counts claim at window start",
+			rules:         []Rule{rolling},
+			history:       claims(now.Add(-24*time.Hour), now.Add(-time.Hour)),
+			wantRemaining: 0,
+			wantUsed:      2,
+			wantPeriod:    PeriodRolling,
+			wantReset:     now,
+		},
+		{
+			name:          "rolling resets when oldest counted claim expires",
+			rules:         []Rule{rolling},
+			history:       claims(now.Add(-23*time.Hour), now.Add(-2*time.Hour), now.Add(-time.Hour)),
+			wantRemaining: 0,
+			wantUsed:      3,
+			wantPeriod:    PeriodRolling,
+			wantReset:     now.Add(22 * time.Hour),
+		},
+		{
+			name:          "lifetime never resets",
+			rules:         []Rule{lifetime},
+			history:       claims(time.Date(2020, 1, 1, 0, 0, 0, 0, sh), time.Date(2024, 1, 1, 0, 0, 0, 0, sh), now),
+			wantRemaining: 0,
+			wantUsed:      3,
+			wantPeriod:    PeriodLifetime,
+		},
+		{
+			name:          "zero limit",
+			rules:         []Rule{{Period: PeriodRolling, Window: time.Hour, Limit: 0}},
+			history:       claims(),
+			wantRemaining: 0,
+			wantPeriod:    PeriodRolling,
+		},
+		{
+			name:          "strictest rule wins",
+			rules:         []Rule{month, day},
+			history:       claims(time.Date(2026, 10, 17, 8, 0, 0, 0, sh)),
+			wantRemaining: 0,
+			wantUsed:      1,
+			wantPeriod:    PeriodDay,
+			wantReset:     time.Date(2026, 10, 18, 0, 0, 0, 0, sh),
+		},
+		{
+			name:          "latest reset wins when all exhausted",
+			rules:         []Rule{day, week},
+			history:       claims(time.Date(2026, 10, 13, 8, 0, 0, 0, sh), time.Date(2026, 10, 17, 8, 0, 0, 0, sh)),
+			wantRemaining: 0,
+			wantUsed:      2,
+			wantPeriod:    PeriodWeek,
+			wantReset:     time.Date(2026, 10, 19, 0, 0, 0, 0, sh),
+		},
+		{
+			name:          "lifetime wins over periodic reset",
+			rules:         []Rule{day, lifetime},
+			history:       claims(time.Date(2026, 10, 1, 8, 0, 0, 0, sh), time.Date(2026, 10, 2, 8, 0, 0, 0, sh), time.Date(2026, 10, 17, 8, 0, 0, 0, sh)),
+			wantRemaining: 0,
+			wantUsed:      3,
+			wantPeriod:    PeriodLifetime,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Evaluate(tt.rules, now, tt.history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Unlimited {
+				t.Fatal("unexpected unlimited")
+			}
+			if res.Remaining != tt.wantRemaining || res.Used != tt.wantUsed || res.Rule.Period != tt.wantPeriod {
+				t.Fatalf("remaining=%d used=%d period=%s, want remaining=%d used=%d period=%s",
+					res.Remaining, res.Used, res.Rule.Period, tt.wantRemaining, tt.wantUsed, tt.wantPeriod)
+			}
+			if !res.ResetAt.Equal(tt.wantReset) {
+				t.Fatalf("resetAt = %v, want %v", res.ResetAt, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestEvaluateUnlimited(t *testing.T) {
+	res, err := Evaluate(nil, time.Now(), func(time.Time) ([]time.Time, error) {
+		t.Fatal("history should not be queried")
+		return nil, nil
+	})
+	if err != nil || !res.Unlimited {
+		t.Fatalf("res = %+v, err = %v", res, err)
+	}
+}
+
+func TestEvaluateHistoryError(t *testing.T) {
+	want := errors.New("db down")
+	_, err := Evaluate([]Rule{{Period: PeriodDay, Limit: 1}}, time.Now(), func(time.Time) ([]time.Time, error) {
+		return nil, want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("err = %v, want %v", err, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		rule    Rule
+		wantErr bool
+	}{
+		{Rule{Period: PeriodDay, Limit: 1}, false},
+		{Rule{Period: PeriodLifetime, Limit: 0}, false},
+		{Rule{Period: PeriodRolling, Window: time.Hour, Limit: 1}, false},
+		{Rule{Period: PeriodRolling, Limit: 1}, true},
+		{Rule{Period: PeriodWeek, Limit: -1}, true},
+		{Rule{Period: "year", Limit: 1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, wantErr %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Period: PeriodRolling, Window: 48 * time.Hour, Limit: 2}, "2天内最多领2张"},
+		{Rule{Period: PeriodRolling, Window: 12 * time.Hour, Limit: 1}, "12小时内最多领1张"},
+		{Rule{Period: PeriodRolling, Window: 90 * time.Minute, Limit: 1}, "90分钟内最多领1张"},
+		{Rule{Period: PeriodDay, Limit: 1}, "每天只能领1张"},
+		{Rule{Period: PeriodWeek, Limit: 2}, "每周只能领2张"},
+		{Rule{Period: PeriodMonth, Limit: 3}, "每月只能领3张"},
+		{Rule{Period: PeriodLifetime, Limit: 5}, "最多只能领5张"},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.Describe(); got != tt.want {
+			t.Errorf("Describe(%+v) = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
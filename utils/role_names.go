@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// RoleNames 配置中引用的角色名，兼容旧版本配置中的权限位整数
+type RoleNames struct {
+	Names      []string
+	LegacyMask int // 旧配置的权限位，按内置角色解析
+}
+
+// UnmarshalJSON 支持角色名数组、单个角色名和旧的权限位整数
+func (r *RoleNames) UnmarshalJSON(data []byte) error {
+	var mask int
+	if err := json.Unmarshal(data, &mask); err == nil {
+		*r = RoleNames{LegacyMask: mask}
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*r = RoleNames{}
+		if name != "" {
+			r.Names = []string{name}
+		}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return errors.New("role must be a role name, a list of role names or a legacy bitmask")
+	}
+	*r = RoleNames{Names: names}
+	return nil
+}
+
+// MarshalJSON 输出角色名数组，旧的权限位原样输出
+func (r RoleNames) MarshalJSON() ([]byte, error) {
+	if r.LegacyMask != 0 && len(r.Names) == 0 {
+		return json.Marshal(r.LegacyMask)
+	}
+	if r.Names == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(r.Names)
+}
+
+// IsEmpty 是否未引用任何角色
+func (r RoleNames) IsEmpty() bool {
+	return len(r.Names) == 0 && r.LegacyMask == 0
+}
+
+// Merge 合并两组角色
+func (r RoleNames) Merge(other RoleNames) RoleNames {
+	out := RoleNames{LegacyMask: r.LegacyMask | other.LegacyMask}
+	seen := make(map[string]bool, len(r.Names)+len(other.Names))
+	for _, list := range [][]string{r.Names, other.Names} {
+		for _, n := range list {
+			if !seen[n] {
+				seen[n] = true
+				out.Names = append(out.Names, n)
+			}
+		}
+	}
+	return out
+}
+
+// MappedRoleNames 组映射中出现的所有角色，登录时按用户所在的组同步这些角色
+func MappedRoleNames(groupRoles map[string]RoleNames) RoleNames {
+	var roles RoleNames
+	for _, r := range groupRoles {
+		roles = roles.Merge(r)
+	}
+	return roles
+}